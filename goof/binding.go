@@ -0,0 +1,210 @@
+package goof
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Where the value of a request field is read from
+type BindingSource string
+
+const (
+	SourcePath   BindingSource = "path"
+	SourceQuery  BindingSource = "query"
+	SourceHeader BindingSource = "header"
+	SourceBody   BindingSource = "body"
+)
+
+// The struct tags used for each binding source
+var sourceTags = []struct {
+	source BindingSource
+	tags   []string
+}{
+	{SourcePath, []string{"uri"}},
+	{SourceQuery, []string{"form", "query"}},
+	{SourceHeader, []string{"header"}},
+	{SourceBody, []string{"json"}},
+}
+
+// Describes a single field of a request type and where it's bound from
+type RequestField struct {
+	Name     string
+	Key      string
+	Source   BindingSource
+	Type     reflect.Type
+	Required bool
+	// Optional explanation of the field for generated documentation
+	Description string
+}
+
+// Get the fields of a request type along with their binding source. A field with several tags is listed once per
+// source.
+func RequestFields(t reflect.Type) (fields []RequestField) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			fields = append(fields, RequestFields(f.Type)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		required := strings.Contains(f.Tag.Get("binding"), "required")
+		for _, st := range sourceTags {
+			for _, tag := range st.tags {
+				key, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+				if key == "" || key == "-" {
+					continue
+				}
+				fields = append(fields, RequestField{
+					Name:     f.Name,
+					Key:      key,
+					Source:   st.source,
+					Type:     f.Type,
+					Required: required,
+				})
+			}
+		}
+	}
+	return
+}
+
+// Bind the request into obj from the path (uri tags), the query (form or query tags), the headers (header tags) and
+// the body. The body is only read if formats are given and is decoded first so values from the path, query and
+// headers take precedence. Defaults from tags like form:"limit,default=10" are only used when no source supplies the
+// field. obj is validated once all sources have been bound.
+func bindRequest(c *gin.Context, obj any, formats []*Format) (err error) {
+	fields := RequestFields(reflect.TypeOf(obj))
+	if err = bindDefaults(obj); err != nil {
+		return
+	}
+	if len(formats) > 0 && hasBody(c.Request) {
+		if err = decodeBody(c.Request, obj, formats); err != nil {
+			return
+		}
+	}
+	if len(fields) > 0 {
+		if err = bindSupplied(c, obj, fields); err != nil {
+			return
+		}
+	}
+	if binding.Validator == nil {
+		return
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// Set the defaults given by tags like form:"limit,default=10". Called before any source is bound so that the sources
+// replace them.
+func bindDefaults(obj any) (err error) {
+	for _, tag := range []string{"uri", "form", "query", "header"} {
+		if err = binding.MapFormWithTag(obj, map[string][]string{}, tag); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Bind the path, query and header fields of obj like bindSources. Fields which aren't in the request keep their
+// value, such as one decoded from the body, rather than being reset to their default.
+func bindSupplied(c *gin.Context, obj any, fields []RequestField) (err error) {
+	// gin sets the default of every missing field so they are put back afterwards
+	value := reflect.ValueOf(obj).Elem()
+	kept := map[string]reflect.Value{}
+	for _, f := range fields {
+		if f.Source == SourceBody || isSupplied(c, f) {
+			continue
+		}
+		if _, ok := kept[f.Name]; ok {
+			continue
+		}
+		if field := value.FieldByName(f.Name); field.IsValid() && field.CanSet() {
+			saved := reflect.New(field.Type()).Elem()
+			saved.Set(field)
+			kept[f.Name] = saved
+		}
+	}
+	defer func() {
+		for name, saved := range kept {
+			value.FieldByName(name).Set(saved)
+		}
+	}()
+	return bindSources(c, obj, fields)
+}
+
+// Check if the request has a value for the field
+func isSupplied(c *gin.Context, f RequestField) bool {
+	switch f.Source {
+	case SourcePath:
+		_, ok := c.Params.Get(f.Key)
+		return ok
+	case SourceQuery:
+		_, ok := c.Request.URL.Query()[f.Key]
+		return ok
+	case SourceHeader:
+		return len(c.Request.Header.Values(f.Key)) > 0
+	}
+	return false
+}
+
+func bindSources(c *gin.Context, obj any, fields []RequestField) (err error) {
+	path := map[string][]string{}
+	query := map[string][]string{}
+	header := map[string][]string{}
+	values := c.Request.URL.Query()
+	for _, f := range fields {
+		switch f.Source {
+		case SourcePath:
+			if v, ok := c.Params.Get(f.Key); ok {
+				path[f.Key] = []string{v}
+			}
+		case SourceQuery:
+			if v, ok := values[f.Key]; ok {
+				query[f.Key] = v
+			}
+		case SourceHeader:
+			if v := c.Request.Header.Values(f.Key); len(v) > 0 {
+				header[f.Key] = v
+			}
+		}
+	}
+	// only keys declared by the request type are passed along so fields aren't bound by their Go name
+	if err = binding.MapFormWithTag(obj, path, "uri"); err != nil {
+		return
+	}
+	if err = binding.MapFormWithTag(obj, query, "form"); err != nil {
+		return
+	}
+	if err = binding.MapFormWithTag(obj, query, "query"); err != nil {
+		return
+	}
+	return binding.MapFormWithTag(obj, header, "header")
+}
+
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+func decodeBody(r *http.Request, obj any, formats []*Format) (err error) {
+	format, err := requestFormat(r.Header.Get("Content-Type"), formats)
+	if err != nil {
+		return
+	}
+	err = format.Decode(r.Body, obj)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package goof
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type bindingRequest struct {
+	Id     int      `uri:"id" binding:"required"`
+	Limit  int      `form:"limit,default=10"`
+	Tags   []string `form:"tag"`
+	Token  string   `header:"X-Token"`
+	Name   string   `json:"name"`
+	Ignore string   `json:"-"`
+}
+
+func serveBinding(r *gin.Engine, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, path, nil)
+	} else {
+		req = httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestJsonBindsAllSources(t *testing.T) {
+	r := problemEngine(false, Json("/item/:id", func(c *gin.Context, req bindingRequest) (res bindingRequest, status int, err error) {
+		return req, 0, nil
+	}).Put())
+	res := serveBinding(r, http.MethodPut, "/item/3?limit=5&tag=a&tag=b&Name=query", `{"name": "body", "Id": 10}`, http.Header{
+		"X-Token": {"secret"},
+	})
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d %s", res.Code, res.Body.String())
+	}
+	var got bindingRequest
+	if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	// Token isn't serialized back since it doesn't have a json tag
+	expected := bindingRequest{Id: 3, Limit: 5, Tags: []string{"a", "b"}, Token: "secret", Name: "body"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v; got %+v", expected, got)
+	}
+}
+
+func TestHandlerWithoutBody(t *testing.T) {
+	var got bindingRequest
+	r := problemEngine(false, Handler("/item/:id", func(c *gin.Context, req bindingRequest) (res struct{}, status int, err error) {
+		got = req
+		return
+	}).Get())
+	res := serveBinding(r, http.MethodGet, "/item/3", "", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d %s", res.Code, res.Body.String())
+	}
+	if got.Id != 3 || got.Limit != 10 {
+		t.Errorf("expected id 3 and the default limit; got %+v", got)
+	}
+
+	res = serveBinding(r, http.MethodGet, "/item/abc", "", nil)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid id; got %d", res.Code)
+	}
+}
+
+func TestRequestFields(t *testing.T) {
+	fields := Handler("/item/:id", func(c *gin.Context, req bindingRequest) (res struct{}, status int, err error) {
+		return
+	}).route.RequestFields()
+	sources := map[string]BindingSource{}
+	for _, f := range fields {
+		sources[f.Key] = f.Source
+	}
+	expected := map[string]BindingSource{
+		"id":      SourcePath,
+		"limit":   SourceQuery,
+		"tag":     SourceQuery,
+		"X-Token": SourceHeader,
+		"name":    SourceBody,
+	}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("expected %v; got %v", expected, sources)
+	}
+}
+
+type defaultedRequest struct {
+	Limit int `json:"limit" form:"limit,default=10"`
+}
+
+func TestDefaultsOnlyFillMissingValues(t *testing.T) {
+	r := problemEngine(false, Json("/", func(c *gin.Context, req defaultedRequest) (res defaultedRequest, status int, err error) {
+		return req, 0, nil
+	}).Post())
+	cases := []struct {
+		path, body string
+		limit      int
+	}{
+		{"/", `{}`, 10},
+		{"/", `{"limit": 3}`, 3},
+		{"/?limit=5", `{"limit": 3}`, 5},
+		{"/", "", 10},
+	}
+	for _, c := range cases {
+		res := serveBinding(r, http.MethodPost, c.path, c.body, nil)
+		var got defaultedRequest
+		if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s %s: %v %s", c.path, c.body, err, res.Body.String())
+		}
+		if got.Limit != c.limit {
+			t.Errorf("%s %s: expected limit %d; got %d", c.path, c.body, c.limit, got.Limit)
+		}
+	}
+}
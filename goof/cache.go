@@ -0,0 +1,127 @@
+package goof
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/wyattis/goof/http/middleware"
+	"github.com/wyattis/goof/migrate"
+	"github.com/wyattis/goof/schema"
+)
+
+// Creates the goof_cache table used by DBCacheStore
+func CacheMigration() migrate.Migration {
+	return migrate.Migration{
+		Up: func(s *schema.Schema) {
+			s.Create("goof_cache", func(t *schema.Table) {
+				t.String("cache_key").Primary()
+				t.String("path").Index("idx_goof_cache_path")
+				t.Integer("status")
+				t.Text("header")
+				t.Blob("body")
+				t.BigInt("stored_at")
+				t.BigInt("expires_at")
+			})
+		},
+		Down: func(s *schema.Schema) {
+			s.Drop("goof_cache")
+		},
+	}
+}
+
+// Stores cached responses in the goof_cache table so that they are shared between instances. See CacheMigration.
+type DBCacheStore struct {
+	db *sqlx.DB
+}
+
+func NewDBCacheStore(db *sqlx.DB) *DBCacheStore {
+	return &DBCacheStore{db: db}
+}
+
+type cacheRow struct {
+	Key       string `db:"cache_key"`
+	Path      string `db:"path"`
+	Status    int    `db:"status"`
+	Header    string `db:"header"`
+	Body      []byte `db:"body"`
+	StoredAt  int64  `db:"stored_at"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+func (s *DBCacheStore) Get(ctx context.Context, key string) (res *middleware.CachedResponse, ok bool, err error) {
+	var row cacheRow
+	q := s.db.Rebind("SELECT * FROM goof_cache WHERE cache_key = ? AND expires_at > ?")
+	err = s.db.GetContext(ctx, &row, q, key, time.Now().Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return
+	}
+	res = &middleware.CachedResponse{
+		Path:     row.Path,
+		Status:   row.Status,
+		Header:   http.Header{},
+		Body:     row.Body,
+		StoredAt: time.Unix(row.StoredAt, 0).UTC(),
+		Expires:  time.Unix(row.ExpiresAt, 0).UTC(),
+	}
+	if err = json.Unmarshal([]byte(row.Header), &res.Header); err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+func (s *DBCacheStore) Set(ctx context.Context, key string, res *middleware.CachedResponse) (err error) {
+	header, err := json.Marshal(res.Header)
+	if err != nil {
+		return
+	}
+	row := cacheRow{
+		Key:       key,
+		Path:      res.Path,
+		Status:    res.Status,
+		Header:    string(header),
+		Body:      res.Body,
+		StoredAt:  res.StoredAt.Unix(),
+		ExpiresAt: res.Expires.Unix(),
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	// expired rows are removed as new ones are written
+	q := s.db.Rebind("DELETE FROM goof_cache WHERE cache_key = ? OR expires_at <= ?")
+	if _, err = tx.ExecContext(ctx, q, key, time.Now().Unix()); err != nil {
+		return
+	}
+	q = "INSERT INTO goof_cache (cache_key, path, status, header, body, stored_at, expires_at) VALUES (:cache_key, :path, :status, :header, :body, :stored_at, :expires_at)"
+	if _, err = tx.NamedExecContext(ctx, q, row); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+func (s *DBCacheStore) Invalidate(ctx context.Context, path string) error {
+	path = strings.TrimSuffix(path, "/")
+	q := s.db.Rebind("DELETE FROM goof_cache WHERE path = ? OR path LIKE ? ESCAPE '!'")
+	_, err := s.db.ExecContext(ctx, q, path, escapeLike(path)+"/%")
+	return err
+}
+
+// Escape the wildcards of a LIKE pattern using ESCAPE '!'. A backslash isn't used since MySQL treats it as an escape
+// inside string literals.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
@@ -0,0 +1,60 @@
+package goof
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/wyattis/goof/http/middleware"
+)
+
+func TestDBCacheStore(t *testing.T) {
+	store := NewDBCacheStore(testDB(t, CacheMigration()))
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	for key, path := range map[string]string{"a": "/users", "b": "/users/1", "c": "/users-archive", "d": "/a_b!/1", "e": "/axb!/1"} {
+		err := store.Set(ctx, key, &middleware.CachedResponse{
+			Path:     path,
+			Status:   http.StatusOK,
+			Header:   http.Header{"Content-Type": {"application/json"}},
+			Body:     []byte(`{"path":"` + path + `"}`),
+			StoredAt: now,
+			Expires:  now.Add(time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, ok, err := store.Get(ctx, "b")
+	if err != nil || !ok {
+		t.Fatalf("expected a stored response; got %v %v", ok, err)
+	}
+	if res.Header.Get("Content-Type") != "application/json" || string(res.Body) != `{"path":"/users/1"}` || !res.StoredAt.Equal(now) {
+		t.Errorf("unexpected response %+v", res)
+	}
+
+	if err = store.Invalidate(ctx, "/users/"); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]bool{"a": false, "b": false, "c": true} {
+		if _, ok, _ := store.Get(ctx, key); ok != expected {
+			t.Errorf("expected %s to be stored: %v", key, expected)
+		}
+	}
+
+	// wildcards and the escape character in the path are matched literally
+	if err = store.Invalidate(ctx, "/a_b!"); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]bool{"d": false, "e": true} {
+		if _, ok, _ := store.Get(ctx, key); ok != expected {
+			t.Errorf("expected %s to be stored: %v", key, expected)
+		}
+	}
+
+	store.Set(ctx, "expired", &middleware.CachedResponse{Path: "/", Header: http.Header{}, Expires: now.Add(-time.Second)})
+	if _, ok, _ := store.Get(ctx, "expired"); ok {
+		t.Errorf("expected expired responses to be ignored")
+	}
+}
@@ -0,0 +1,217 @@
+package goof
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/wyattis/goof/http/middleware"
+)
+
+// An error with enough information to be rendered as an RFC 7807 problem by ErrorHandler. Detail is considered safe to
+// show to clients while Err is only shown outside of production.
+type HTTPError struct {
+	Status int
+	Code   string
+	Title  string
+	Detail string
+	Fields []FieldError
+	Err    error
+}
+
+// A validation failure for a single field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Create an HTTPError with the given status and public detail message
+func NewHTTPError(status int, detail string) *HTTPError {
+	return &HTTPError{Status: status, Detail: detail}
+}
+
+// Wrap err with the given status. The error text is hidden from clients in production.
+func WrapHTTPError(status int, err error) *HTTPError {
+	return &HTTPError{Status: status, Err: err}
+}
+
+func (e *HTTPError) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = e.title()
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// Set the machine readable error code
+func (e *HTTPError) WithCode(code string) *HTTPError {
+	e.Code = code
+	return e
+}
+
+// Set the per field errors
+func (e *HTTPError) WithFields(fields ...FieldError) *HTTPError {
+	e.Fields = append(e.Fields, fields...)
+	return e
+}
+
+func (e *HTTPError) title() string {
+	if e.Title != "" {
+		return e.Title
+	}
+	return http.StatusText(e.Status)
+}
+
+// Convert any error returned by a handler into an HTTPError. If err doesn't carry a status the given status is used
+// and validation errors are converted into field errors.
+func toHTTPError(status int, err error) *HTTPError {
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.Status == 0 {
+			// copy the error since it may be shared, like a package level error
+			res := *httpErr
+			res.Status = status
+			return &res
+		}
+		return httpErr
+	}
+	if _, ok := asFieldErrors(err); ok {
+		return validationError(err)
+	}
+	if middleware.IsBodyTooLarge(err) {
+		return WrapHTTPError(http.StatusRequestEntityTooLarge, err)
+	}
+	return WrapHTTPError(status, err)
+}
+
+// Convert validation failures into field errors. The other fields named by cross-field rules are resolved to their JSON
+// names through root, the type of the struct which was validated, when it is known.
+func fieldErrors(errs validator.ValidationErrors, root reflect.Type) (fields []FieldError) {
+	for _, fe := range errs {
+		// the namespace includes the name of the root struct which isn't meaningful to clients
+		name := fe.Namespace()
+		if _, path, found := strings.Cut(name, "."); found {
+			name = path
+		}
+		fields = append(fields, FieldError{
+			Field:   name,
+			Message: fieldErrorMessage(fe, root),
+		})
+	}
+	return
+}
+
+// Get a human readable message for a single validation failure
+func fieldErrorMessage(fe validator.FieldError, root reflect.Type) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid URL"
+	case "uuid", "uuid4":
+		return "must be a valid UUID"
+	case "oneof":
+		return fmt.Sprintf("must be one of [%s]", fe.Param())
+	case "min":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "len":
+		return fmt.Sprintf("must have a length of %s", fe.Param())
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("must be greater than or equal to %s", fe.Param())
+	case "lt":
+		return fmt.Sprintf("must be less than %s", fe.Param())
+	case "lte":
+		return fmt.Sprintf("must be less than or equal to %s", fe.Param())
+	case "eqfield":
+		return fmt.Sprintf("must be equal to %s", otherFieldName(fe, root))
+	case "nefield":
+		return fmt.Sprintf("must not be equal to %s", otherFieldName(fe, root))
+	case "gtfield":
+		return fmt.Sprintf("must be greater than %s", otherFieldName(fe, root))
+	case "gtefield":
+		return fmt.Sprintf("must be greater than or equal to %s", otherFieldName(fe, root))
+	case "ltfield":
+		return fmt.Sprintf("must be less than %s", otherFieldName(fe, root))
+	case "ltefield":
+		return fmt.Sprintf("must be less than or equal to %s", otherFieldName(fe, root))
+	case "required_with", "required_without", "required_if", "required_unless":
+		return "is required"
+	default:
+		if fe.Param() != "" {
+			return fmt.Sprintf("failed the '%s=%s' validation", fe.Tag(), fe.Param())
+		}
+		return fmt.Sprintf("failed the '%s' validation", fe.Tag())
+	}
+}
+
+// Get the name a client uses for the other field of a cross-field validation. The param is the Go name of a field in
+// the same struct as the field which failed. Falls back to the param when the struct can't be found.
+func otherFieldName(fe validator.FieldError, root reflect.Type) string {
+	if root == nil {
+		return fe.Param()
+	}
+	// walk the struct namespace, minus the root and the failed field, to the struct containing the field
+	segments := strings.Split(fe.StructNamespace(), ".")
+	if len(segments) < 2 {
+		return fe.Param()
+	}
+	t := root
+	for _, segment := range segments[1 : len(segments)-1] {
+		name, _, _ := strings.Cut(segment, "[")
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return fe.Param()
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			return fe.Param()
+		}
+		t = f.Type
+		// each index steps into an element of a slice, array or map
+		for i := strings.Count(segment, "["); i > 0; i-- {
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			switch t.Kind() {
+			case reflect.Slice, reflect.Array, reflect.Map:
+				t = t.Elem()
+			default:
+				return fe.Param()
+			}
+		}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fe.Param()
+	}
+	if f, ok := t.FieldByName(fe.Param()); ok {
+		if name := fieldName(f); name != "" {
+			return name
+		}
+	}
+	return fe.Param()
+}
@@ -0,0 +1,125 @@
+package goof
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/wyattis/goof/gtime"
+	"github.com/wyattis/goof/migrate"
+	"github.com/wyattis/goof/schema"
+)
+
+// Metadata of a stored file
+type File struct {
+	Id          string             `json:"id" db:"id"`
+	Name        string             `json:"name" db:"name"`
+	ContentType string             `json:"contentType" db:"content_type"`
+	Size        int64              `json:"size" db:"size"`
+	Checksum    string             `json:"checksum" db:"checksum"`
+	CreatedAt   gtime.TimeDateTime `json:"createdAt" db:"created_at"`
+}
+
+// Creates the goof_files table used to record file metadata and the goof_file_chunks table used by DBStorage. Add it
+// to a module's migrations to use a FileStore.
+func FilesMigration() migrate.Migration {
+	return migrate.Migration{
+		Up: func(s *schema.Schema) {
+			s.Create("goof_files", func(t *schema.Table) {
+				t.String("id").Primary()
+				t.String("name")
+				t.String("content_type")
+				t.BigInt("size")
+				t.String("checksum")
+				t.Timestamp("created_at").Default(schema.NOW{})
+			})
+			s.Create("goof_file_chunks", func(t *schema.Table) {
+				t.String("file_key")
+				t.BigInt("position")
+				t.Blob("data")
+				t.Unique("file_key", "position")
+			})
+		},
+		Down: func(s *schema.Schema) {
+			s.Drop("goof_file_chunks")
+			s.Drop("goof_files")
+		},
+	}
+}
+
+// Records file metadata in the goof_files table and stores the contents using a Storage backend
+type FileStore struct {
+	db      *sqlx.DB
+	storage Storage
+}
+
+func NewFileStore(db *sqlx.DB, storage Storage) *FileStore {
+	return &FileStore{db: db, storage: storage}
+}
+
+// Stream the contents of r to storage and record its metadata
+func (s *FileStore) Save(ctx context.Context, name, contentType string, r io.Reader) (f File, err error) {
+	f = File{
+		Id:          uuid.NewString(),
+		Name:        cleanFileName(name),
+		ContentType: contentType,
+		CreatedAt:   gtime.TimeDateTime{Time: time.Now().UTC().Truncate(time.Second)},
+	}
+	hash := sha256.New()
+	if f.Size, err = s.storage.Put(ctx, f.Id, io.TeeReader(r, hash)); err != nil {
+		s.storage.Delete(ctx, f.Id)
+		return
+	}
+	f.Checksum = hex.EncodeToString(hash.Sum(nil))
+	q := "INSERT INTO goof_files (id, name, content_type, size, checksum, created_at) VALUES (:id, :name, :content_type, :size, :checksum, :created_at)"
+	if _, err = s.db.NamedExecContext(ctx, q, f); err != nil {
+		s.storage.Delete(ctx, f.Id)
+	}
+	return
+}
+
+// Get the metadata of a file. Returns ErrFileNotFound if it does not exist.
+func (s *FileStore) Get(ctx context.Context, id string) (f File, err error) {
+	err = s.db.GetContext(ctx, &f, s.db.Rebind("SELECT * FROM goof_files WHERE id = ?"), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrFileNotFound
+	}
+	return
+}
+
+// Get the metadata of a file and open its contents. The caller must close the returned reader.
+func (s *FileStore) Open(ctx context.Context, id string) (f File, r io.ReadSeekCloser, err error) {
+	if f, err = s.Get(ctx, id); err != nil {
+		return
+	}
+	r, err = s.storage.Open(ctx, id)
+	return
+}
+
+// Remove the contents and metadata of a file
+func (s *FileStore) Delete(ctx context.Context, id string) (err error) {
+	if err = s.storage.Delete(ctx, id); err != nil {
+		return
+	}
+	_, err = s.db.ExecContext(ctx, s.db.Rebind("DELETE FROM goof_files WHERE id = ?"), id)
+	return
+}
+
+// Strip any directories a client included in the file name
+func cleanFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" || name == "." || name == ".." {
+		return "upload"
+	}
+	return name
+}
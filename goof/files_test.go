@@ -0,0 +1,139 @@
+package goof
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/wyattis/goof/migrate"
+	"github.com/wyattis/goof/schema"
+	"github.com/wyattis/goof/sql/driver"
+)
+
+// Open a sqlite database and run the given migrations
+func testDB(t *testing.T, migrations ...migrate.Migration) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for i := range migrations {
+		migrations[i].Version = uint(i + 1)
+	}
+	if err = migrate.MigrateUpTo(migrations, db.DB, driver.TypeSqlite3, "test", uint(len(migrations))); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestStorage(t *testing.T) {
+	db := testDB(t, FilesMigration())
+	dbStorage := NewDBStorage(db)
+	dbStorage.ChunkSize = 7
+	local, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	ctx := context.Background()
+	for name, s := range map[string]Storage{"local": local, "db": dbStorage} {
+		t.Run(name, func(t *testing.T) {
+			n, err := s.Put(ctx, "fox", bytes.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(len(content)) {
+				t.Errorf("expected %d bytes to be written; got %d", len(content), n)
+			}
+			r, err := s.Open(ctx, "fox")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			all, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(all, content) {
+				t.Errorf("expected %q; got %q", content, all)
+			}
+			if _, err = r.Seek(-8, io.SeekEnd); err != nil {
+				t.Fatal(err)
+			}
+			tail, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(tail) != "lazy dog" {
+				t.Errorf("expected to read the tail after seeking; got %q", tail)
+			}
+			if err = s.Delete(ctx, "fox"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = s.Open(ctx, "fox"); err != ErrFileNotFound {
+				t.Errorf("expected ErrFileNotFound after delete; got %v", err)
+			}
+
+			if n, err = s.Put(ctx, "empty", bytes.NewReader(nil)); err != nil || n != 0 {
+				t.Fatalf("expected an empty file to be written; got %d %v", n, err)
+			}
+			r, err = s.Open(ctx, "empty")
+			if err != nil {
+				t.Fatalf("expected an empty file to exist; got %v", err)
+			}
+			defer r.Close()
+			if all, err = io.ReadAll(r); err != nil || len(all) != 0 {
+				t.Errorf("expected no contents; got %q %v", all, err)
+			}
+		})
+	}
+	if _, err = local.Put(ctx, "../escape", bytes.NewReader(content)); err == nil {
+		t.Errorf("expected keys containing paths to be rejected")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	db := testDB(t, FilesMigration())
+	files := NewFileStore(db, NewDBStorage(db))
+	ctx := context.Background()
+	f, err := files.Save(ctx, `C:\Users\me\notes.txt`, "text/plain", bytes.NewBufferString("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "notes.txt" || f.Size != 5 || f.Checksum == "" {
+		t.Errorf("unexpected file metadata %+v", f)
+	}
+	got, err := files.Get(ctx, f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != f.Name || got.Checksum != f.Checksum || !got.CreatedAt.Equal(f.CreatedAt) {
+		t.Errorf("expected %+v; got %+v", f, got)
+	}
+	if err = files.Delete(ctx, f.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = files.Get(ctx, f.Id); err != ErrFileNotFound {
+		t.Errorf("expected ErrFileNotFound after delete; got %v", err)
+	}
+}
+
+func TestFilesMigrationPostgres(t *testing.T) {
+	s := schema.New(driver.TypePostgres, "test")
+	FilesMigration().Up(s)
+	statements := strings.Join(s.Schema.Statements(), "\n")
+	for _, expected := range []string{`"data" BYTEA NOT NULL`, `"id" VARCHAR(255) PRIMARY KEY`, `"created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP`} {
+		if !strings.Contains(statements, expected) {
+			t.Errorf("expected %s in %s", expected, statements)
+		}
+	}
+	if strings.Contains(statements, "BLOB") || strings.Contains(statements, "`") {
+		t.Errorf("expected postgres types and quoting; got %s", statements)
+	}
+}
@@ -0,0 +1,339 @@
+package goof
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v3"
+)
+
+// An encoding which can be negotiated for request and response bodies. Render is used for responses and Decode for
+// requests. Either can be nil if the format only works in one direction.
+type Format struct {
+	Name      string
+	MimeTypes []string
+	Render    func(c *gin.Context, status int, obj any) error
+	Decode    func(r io.Reader, obj any) error
+	// Optionally restrict the response types which can be rendered using this format
+	CanRender func(t reflect.Type) bool
+}
+
+func (f *Format) canRender(t reflect.Type) bool {
+	return f.Render != nil && (f.CanRender == nil || f.CanRender(t))
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]*Format{}
+)
+
+// The formats used by routes that don't specify their own
+var (
+	DefaultResponseFormats = []string{"json", "xml", "yaml", "msgpack", "csv"}
+	DefaultRequestFormats  = []string{"json", "xml", "yaml", "msgpack"}
+)
+
+// Register a format so that it can be used by routes. Registering a format with an existing name replaces it.
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[f.Name] = &f
+}
+
+func getFormat(name string) *Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return formats[name]
+}
+
+func init() {
+	RegisterFormat(Format{
+		Name:      "json",
+		MimeTypes: []string{"application/json"},
+		Render: func(c *gin.Context, status int, obj any) error {
+			c.JSON(status, obj)
+			return nil
+		},
+		Decode: func(r io.Reader, obj any) error {
+			return json.NewDecoder(r).Decode(obj)
+		},
+	})
+	RegisterFormat(Format{
+		Name:      "xml",
+		MimeTypes: []string{"application/xml", "text/xml"},
+		Render: func(c *gin.Context, status int, obj any) error {
+			c.XML(status, obj)
+			return nil
+		},
+		Decode: func(r io.Reader, obj any) error {
+			return xml.NewDecoder(r).Decode(obj)
+		},
+	})
+	RegisterFormat(Format{
+		Name:      "yaml",
+		MimeTypes: []string{"application/yaml", "application/x-yaml", "text/yaml"},
+		Render: func(c *gin.Context, status int, obj any) error {
+			c.YAML(status, obj)
+			return nil
+		},
+		Decode: func(r io.Reader, obj any) error {
+			return yaml.NewDecoder(r).Decode(obj)
+		},
+	})
+	RegisterFormat(Format{
+		Name:      "msgpack",
+		MimeTypes: []string{"application/msgpack", "application/x-msgpack"},
+		Render: func(c *gin.Context, status int, obj any) error {
+			c.Render(status, render.MsgPack{Data: obj})
+			return nil
+		},
+		Decode: func(r io.Reader, obj any) error {
+			return codec.NewDecoder(r, new(codec.MsgpackHandle)).Decode(obj)
+		},
+	})
+	RegisterFormat(Format{
+		Name:      "csv",
+		MimeTypes: []string{"text/csv"},
+		Render: func(c *gin.Context, status int, obj any) error {
+			c.Status(status)
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			return writeCSV(c.Writer, obj)
+		},
+		CanRender: func(t reflect.Type) bool {
+			return csvRowType(t) != nil
+		},
+	})
+}
+
+// Get the formats with the given names which can render t
+func responseFormats(names []string, t reflect.Type) (res []*Format) {
+	for _, name := range names {
+		if f := getFormat(name); f != nil && f.canRender(t) {
+			res = append(res, f)
+		}
+	}
+	return
+}
+
+// Get the formats with the given names which can decode request bodies
+func requestFormats(names []string) (res []*Format) {
+	for _, name := range names {
+		if f := getFormat(name); f != nil && f.Decode != nil {
+			res = append(res, f)
+		}
+	}
+	return
+}
+
+func mimeTypes(formats []*Format) (res []string) {
+	for _, f := range formats {
+		res = append(res, f.MimeTypes...)
+	}
+	return
+}
+
+type acceptRange struct {
+	mime string
+	q    float64
+}
+
+// Parse an Accept header into media ranges ordered by preference
+func parseAccept(header string) (ranges []acceptRange) {
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mime: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return
+}
+
+func (a acceptRange) matches(mimeType string) bool {
+	if a.mime == "*/*" || a.mime == mimeType {
+		return true
+	}
+	if !strings.HasSuffix(a.mime, "/*") {
+		return false
+	}
+	return strings.HasPrefix(mimeType, strings.TrimSuffix(a.mime, "*"))
+}
+
+// Pick the response format based on the Accept header. The first format is used if the client accepts anything.
+func negotiateFormat(accept string, formats []*Format) *Format {
+	if len(formats) == 0 {
+		return nil
+	}
+	if strings.TrimSpace(accept) == "" {
+		return formats[0]
+	}
+	for _, r := range parseAccept(accept) {
+		for _, f := range formats {
+			for _, m := range f.MimeTypes {
+				if r.matches(m) {
+					return f
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Pick the request format based on the Content-Type header. The first format is used if the header is missing.
+func requestFormat(contentType string, formats []*Format) (*Format, error) {
+	if len(formats) == 0 {
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, "this route doesn't accept a request body")
+	}
+	if contentType == "" {
+		return formats[0], nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, WrapHTTPError(http.StatusBadRequest, err)
+	}
+	for _, f := range formats {
+		for _, m := range f.MimeTypes {
+			if m == mediaType {
+				return f, nil
+			}
+		}
+	}
+	return nil, NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type '%s'", mediaType))
+}
+
+// Get the struct type of the rows of t if t can be written as CSV
+func csvRowType(t reflect.Type) reflect.Type {
+	if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+		return nil
+	}
+	row := t.Elem()
+	if row.Kind() == reflect.Ptr {
+		row = row.Elem()
+	}
+	if row.Kind() != reflect.Struct {
+		return nil
+	}
+	return row
+}
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+func csvColumns(t reflect.Type) (cols []csvColumn) {
+	for _, f := range reflect.VisibleFields(t) {
+		if f.PkgPath != "" || f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		cols = append(cols, csvColumn{name: name, index: f.Index})
+	}
+	return
+}
+
+// Write a slice of structs as CSV. The header uses the json names of the fields.
+func writeCSV(w io.Writer, obj any) (err error) {
+	v := reflect.ValueOf(obj)
+	row := csvRowType(v.Type())
+	if row == nil {
+		return errors.New("csv responses must be a slice of structs")
+	}
+	cols := csvColumns(row)
+	cw := csv.NewWriter(w)
+	header := make([]string, len(cols))
+	for i, c := range cols {
+		header[i] = c.name
+	}
+	if err = cw.Write(header); err != nil {
+		return
+	}
+	record := make([]string, len(cols))
+	for i := 0; i < v.Len(); i++ {
+		item := reflect.Indirect(v.Index(i))
+		if !item.IsValid() {
+			continue
+		}
+		if err = writeCSVRecord(cw, item, cols, record); err != nil {
+			return
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Write the columns of a struct as a CSV record. record is reused between calls.
+func writeCSVRecord(cw *csv.Writer, item reflect.Value, cols []csvColumn, record []string) (err error) {
+	for i, c := range cols {
+		if record[i], err = csvValue(item.FieldByIndex(c.index)); err != nil {
+			return
+		}
+	}
+	return cw.Write(record)
+}
+
+func csvValue(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	switch val := v.Interface().(type) {
+	case time.Time:
+		return val.Format(time.RFC3339), nil
+	case fmt.Stringer:
+		return val.String(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	default:
+		b, err := json.Marshal(v.Interface())
+		return string(b), err
+	}
+}
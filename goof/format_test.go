@@ -0,0 +1,86 @@
+package goof
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type formatItem struct {
+	Id   int    `json:"id" xml:"id" yaml:"id"`
+	Name string `json:"name" xml:"name" yaml:"name"`
+}
+
+func serveFormat(r *gin.Engine, method, path, contentType, accept, body string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func formatEngine() *gin.Engine {
+	return problemEngine(false,
+		ToJson("/items", func(c *gin.Context) (res []formatItem, status int, err error) {
+			return []formatItem{{1, "a"}, {2, "b,c"}}, 0, nil
+		}).Get(),
+		Json("/item", func(c *gin.Context, req formatItem) (res formatItem, status int, err error) {
+			return req, 0, nil
+		}).Post(),
+		Json("/json-only", func(c *gin.Context, req formatItem) (res formatItem, status int, err error) {
+			return req, 0, nil
+		}).Post().ResponseFormats("json").RequestFormats("json"),
+	)
+}
+
+func TestNegotiateResponse(t *testing.T) {
+	r := formatEngine()
+	res := serveFormat(r, http.MethodGet, "/items", "", "text/csv", "")
+	expected := "id,name\n1,a\n2,\"b,c\"\n"
+	if res.Body.String() != expected || !strings.HasPrefix(res.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("expected csv %q; got %q", expected, res.Body.String())
+	}
+
+	res = serveFormat(r, http.MethodGet, "/items", "", "application/xml;q=0.5, application/yaml", "")
+	if !strings.Contains(res.Header().Get("Content-Type"), "yaml") {
+		t.Errorf("expected the yaml response to be preferred; got %s", res.Header().Get("Content-Type"))
+	}
+
+	res = serveFormat(r, http.MethodGet, "/items", "", "*/*", "")
+	if !strings.HasPrefix(res.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("expected json by default; got %s", res.Header().Get("Content-Type"))
+	}
+}
+
+func TestNegotiateNotAcceptable(t *testing.T) {
+	r := formatEngine()
+	res := serveFormat(r, http.MethodPost, "/item", "application/json", "text/csv", `{"id": 1}`)
+	if res.Code != http.StatusNotAcceptable {
+		t.Errorf("expected csv to be unacceptable for a single item; got %d", res.Code)
+	}
+	res = serveFormat(r, http.MethodPost, "/json-only", "application/json", "application/xml", `{"id": 1}`)
+	if res.Code != http.StatusNotAcceptable {
+		t.Errorf("expected xml to be unacceptable for a json only route; got %d", res.Code)
+	}
+}
+
+func TestNegotiateRequest(t *testing.T) {
+	r := formatEngine()
+	res := serveFormat(r, http.MethodPost, "/item", "application/xml", "application/json", `<formatItem><id>4</id><name>d</name></formatItem>`)
+	if strings.TrimSpace(res.Body.String()) != `{"id":4,"name":"d"}` {
+		t.Errorf("expected xml request to be decoded; got %d %s", res.Code, res.Body.String())
+	}
+	res = serveFormat(r, http.MethodPost, "/json-only", "application/yaml", "", "id: 4")
+	if res.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415; got %d", res.Code)
+	}
+}
@@ -0,0 +1,292 @@
+package goof
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/wyattis/goof/log"
+	"github.com/wyattis/goof/migrate"
+	"github.com/wyattis/goof/schema"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// The response headers which are stored and replayed. Headers about the exchange rather than the response, like
+// Content-Encoding, Content-Length, X-Request-ID and Set-Cookie, are never replayed.
+var idempotentHeaders = []string{"Content-Type", "Content-Language", "Content-Location", "Content-Disposition", "Location", "ETag", "Last-Modified"}
+
+type IdempotencyConfig struct {
+	// How long responses are kept for replaying. Defaults to 24 hours.
+	TTL time.Duration
+	// A request still in progress after this long is assumed to have been abandoned and can be retried. Defaults to
+	// 1 minute. Handlers must finish within it since a retry then runs the handler again and the response of the
+	// slow request is no longer stored.
+	LockTimeout time.Duration
+	// Reject requests without an Idempotency-Key header with 428 Precondition Required
+	Required bool
+	// Methods the header is honoured for. Defaults to POST and PATCH.
+	Methods []string
+	// Separates the keys of different clients, usually by returning the id of the authenticated user. Keys are always
+	// scoped to the route.
+	Scope func(c *gin.Context) string
+}
+
+var DefaultIdempotencyConfig = IdempotencyConfig{
+	TTL:         24 * time.Hour,
+	LockTimeout: time.Minute,
+	Methods:     []string{http.MethodPost, http.MethodPatch},
+}
+
+// Creates the goof_idempotency table used by the Idempotency middleware
+func IdempotencyMigration() migrate.Migration {
+	return migrate.Migration{
+		Up: func(s *schema.Schema) {
+			s.Create("goof_idempotency", func(t *schema.Table) {
+				t.String("idempotency_key").Primary()
+				t.String("fingerprint")
+				t.Integer("status")
+				t.Text("header")
+				t.Blob("body")
+				t.BigInt("locked_at")
+				t.BigInt("expires_at").Index("idx_goof_idempotency_expires_at")
+			})
+		},
+		Down: func(s *schema.Schema) {
+			s.Drop("goof_idempotency")
+		},
+	}
+}
+
+type idempotencyRow struct {
+	Key         string `db:"idempotency_key"`
+	Fingerprint string `db:"fingerprint"`
+	Status      int    `db:"status"`
+	Header      string `db:"header"`
+	Body        []byte `db:"body"`
+	LockedAt    int64  `db:"locked_at"`
+	ExpiresAt   int64  `db:"expires_at"`
+}
+
+// Make retries of a request with the same Idempotency-Key header return the stored response instead of running the
+// handler again. Reusing a key for a different request fails with 422 and retrying while the first request is still
+// in progress fails with 409. Responses rendered from errors and 5xx responses are not stored so those requests can
+// be retried. Add IdempotencyMigration to a module to create the table the responses are stored in.
+func Idempotency(db *sqlx.DB, config IdempotencyConfig) gin.HandlerFunc {
+	if config.TTL == 0 {
+		config.TTL = DefaultIdempotencyConfig.TTL
+	}
+	if config.LockTimeout == 0 {
+		config.LockTimeout = DefaultIdempotencyConfig.LockTimeout
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = DefaultIdempotencyConfig.Methods
+	}
+	purger := &idempotencyPurger{db: db, interval: time.Minute}
+	return func(c *gin.Context) {
+		if !containsString(config.Methods, c.Request.Method) {
+			return
+		}
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			if config.Required {
+				abortWithError(c, http.StatusPreconditionRequired, NewHTTPError(http.StatusPreconditionRequired, "the Idempotency-Key header is required"))
+			}
+			return
+		}
+		if len(key) > 255 {
+			abortWithError(c, http.StatusBadRequest, NewHTTPError(http.StatusBadRequest, "the Idempotency-Key header is too long"))
+			return
+		}
+		purger.maybePurge(c)
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		scope := ""
+		if config.Scope != nil {
+			scope = config.Scope(c)
+		}
+		storageKey := hashStrings(scope, c.Request.Method, c.FullPath(), key)
+		fingerprint := hashStrings(c.Request.Method, c.Request.URL.RequestURI(), string(body))
+
+		stored, lockedAt, err := claimIdempotencyKey(c, db, storageKey, fingerprint, config)
+		if err != nil {
+			abortWithError(c, 0, err)
+			return
+		}
+		if stored != nil {
+			replayResponse(c, stored)
+			c.Abort()
+			return
+		}
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		// use a fresh context so the response is stored even if the client went away
+		ctx := context.Background()
+		release := len(c.Errors) > 0 || w.Status() >= http.StatusInternalServerError
+		if !release {
+			recorded := http.Header{}
+			for _, h := range idempotentHeaders {
+				if v := w.Header().Values(h); len(v) > 0 {
+					recorded[http.CanonicalHeaderKey(h)] = v
+				}
+			}
+			header, _ := json.Marshal(recorded)
+			// the lock is only still ours if locked_at is unchanged
+			q := db.Rebind("UPDATE goof_idempotency SET status = ?, header = ?, body = ? WHERE idempotency_key = ? AND locked_at = ?")
+			res, err := db.ExecContext(ctx, q, w.Status(), string(header), append([]byte{}, w.body.Bytes()...), storageKey, lockedAt)
+			if err != nil {
+				log.Ctx(c).Warn().Err(err).Msg("failed to store idempotent response")
+				release = true
+			} else if n, _ := res.RowsAffected(); n == 0 {
+				log.Ctx(c).Warn().Msg("idempotency key was taken over by a retry before the response was stored")
+			}
+		}
+		if release {
+			q := db.Rebind("DELETE FROM goof_idempotency WHERE idempotency_key = ? AND locked_at = ?")
+			if _, err := db.ExecContext(ctx, q, storageKey, lockedAt); err != nil {
+				log.Ctx(c).Warn().Err(err).Msg("failed to release idempotency key")
+			}
+		}
+	}
+}
+
+// Lock the key for this request. Returns the stored response if the request already completed. Otherwise the lock is
+// identified by lockedAt, in nanoseconds, which changes if another request takes the lock over.
+func claimIdempotencyKey(ctx context.Context, db *sqlx.DB, key, fingerprint string, config IdempotencyConfig) (stored *idempotencyRow, lockedAt int64, err error) {
+	now := time.Now()
+	lockedAt = now.UnixNano()
+	insert := db.Rebind("INSERT INTO goof_idempotency (idempotency_key, fingerprint, status, header, body, locked_at, expires_at) VALUES (?, ?, 0, '{}', ?, ?, ?)")
+	inUse := NewHTTPError(http.StatusConflict, "a request with this Idempotency-Key is still in progress").WithCode("idempotency_key_in_use")
+	// the insert fails if the key exists so look at the existing row and try again if it was removed
+	for attempt := 0; attempt < 2; attempt++ {
+		_, insertErr := db.ExecContext(ctx, insert, key, fingerprint, []byte{}, lockedAt, now.Add(config.TTL).Unix())
+		if insertErr == nil {
+			return nil, lockedAt, nil
+		}
+		var row idempotencyRow
+		err = db.GetContext(ctx, &row, db.Rebind("SELECT * FROM goof_idempotency WHERE idempotency_key = ?"), key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, 0, insertErr
+		}
+		switch {
+		case row.ExpiresAt <= now.Unix():
+			db.ExecContext(ctx, db.Rebind("DELETE FROM goof_idempotency WHERE idempotency_key = ? AND expires_at = ?"), key, row.ExpiresAt)
+			continue
+		case row.Fingerprint != fingerprint:
+			return nil, 0, NewHTTPError(http.StatusUnprocessableEntity, "the Idempotency-Key was already used for a different request").WithCode("idempotency_key_reused")
+		case row.Status != 0:
+			return &row, 0, nil
+		case row.LockedAt > now.Add(-config.LockTimeout).UnixNano():
+			return nil, 0, inUse
+		}
+		// the first request was abandoned so take over its lock
+		q := db.Rebind("UPDATE goof_idempotency SET locked_at = ? WHERE idempotency_key = ? AND locked_at = ? AND status = 0")
+		res, err := db.ExecContext(ctx, q, lockedAt, key, row.LockedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return nil, lockedAt, nil
+		}
+		return nil, 0, inUse
+	}
+	return nil, 0, inUse
+}
+
+func replayResponse(c *gin.Context, row *idempotencyRow) {
+	header := http.Header{}
+	if err := json.Unmarshal([]byte(row.Header), &header); err != nil {
+		log.Ctx(c).Warn().Err(err).Msg("failed to decode stored headers")
+	}
+	for k, v := range header {
+		c.Writer.Header()[k] = v
+	}
+	c.Header("Idempotency-Replayed", "true")
+	c.Status(row.Status)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Write(row.Body)
+}
+
+// Remove expired keys, at most once per interval
+type idempotencyPurger struct {
+	db       *sqlx.DB
+	interval time.Duration
+
+	mu         sync.Mutex
+	lastPurged time.Time
+}
+
+func (p *idempotencyPurger) maybePurge(ctx context.Context) {
+	p.mu.Lock()
+	due := time.Since(p.lastPurged) >= p.interval
+	if due {
+		p.lastPurged = time.Now()
+	}
+	p.mu.Unlock()
+	if due {
+		if err := PurgeIdempotencyKeys(ctx, p.db); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("failed to purge idempotency keys")
+		}
+	}
+}
+
+// Remove every expired idempotency key
+func PurgeIdempotencyKeys(ctx context.Context, db *sqlx.DB) error {
+	_, err := db.ExecContext(ctx, db.Rebind("DELETE FROM goof_idempotency WHERE expires_at <= ?"), time.Now().Unix())
+	return err
+}
+
+// Copies the response as it is written
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func hashStrings(values ...string) string {
+	hash := sha256.New()
+	for _, v := range values {
+		hash.Write([]byte(v))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package goof
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type orderRequest struct {
+	Item string `json:"item" binding:"required"`
+}
+
+type orderResponse struct {
+	Id   int64  `json:"id"`
+	Item string `json:"item"`
+}
+
+func doIdempotent(r *gin.Engine, method, path, key, body string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestIdempotencyReplaysResponses(t *testing.T) {
+	db := testDB(t, IdempotencyMigration())
+	var created int64
+	r := problemEngine(true, Json("/orders", func(c *gin.Context, req orderRequest) (res orderResponse, status int, err error) {
+		id := atomic.AddInt64(&created, 1)
+		c.Header("Location", fmt.Sprintf("/orders/%d", id))
+		c.Header("Set-Cookie", "session=abc")
+		c.Header("X-Request-ID", "first")
+		return orderResponse{Id: id, Item: req.Item}, http.StatusCreated, nil
+	}).Post().Idempotent(db, IdempotencyConfig{}))
+
+	first := doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`)
+	retry := doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`)
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated {
+		t.Fatalf("expected 201 twice; got %d and %d", first.Code, retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("Location") != "/orders/1" {
+		t.Errorf("expected the stored response to be replayed; got %s %v", retry.Body.String(), retry.Header())
+	}
+	for _, h := range []string{"Set-Cookie", "X-Request-ID"} {
+		if retry.Header().Get(h) != "" {
+			t.Errorf("expected %s not to be replayed; got %v", h, retry.Header())
+		}
+	}
+	if retry.Header().Get("Idempotency-Replayed") != "true" || first.Header().Get("Idempotency-Replayed") != "" {
+		t.Errorf("expected only the retry to be marked as replayed")
+	}
+	if created != 1 {
+		t.Errorf("expected the handler to run once; ran %d times", created)
+	}
+
+	doIdempotent(r, http.MethodPost, "/orders", "", `{"item":"book"}`)
+	doIdempotent(r, http.MethodPost, "/orders", "def", `{"item":"book"}`)
+	if created != 3 {
+		t.Errorf("expected requests without the key or with a new key to run; ran %d times", created)
+	}
+
+	res := doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"pen"}`)
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected reusing a key for a different request to fail with 422; got %d", res.Code)
+	}
+}
+
+func TestIdempotencyErrorsAreNotStored(t *testing.T) {
+	db := testDB(t, IdempotencyMigration())
+	var calls int64
+	r := problemEngine(true, Json("/orders", func(c *gin.Context, req orderRequest) (res orderResponse, status int, err error) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			return res, http.StatusServiceUnavailable, NewHTTPError(http.StatusServiceUnavailable, "try again")
+		}
+		return orderResponse{Id: 1, Item: req.Item}, http.StatusCreated, nil
+	}).Post().Idempotent(db, IdempotencyConfig{}))
+
+	if res := doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`); res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503; got %d", res.Code)
+	}
+	if res := doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`); res.Code != http.StatusCreated {
+		t.Errorf("expected the retry to run the handler again; got %d", res.Code)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls; got %d", calls)
+	}
+}
+
+func TestIdempotencyConcurrentRequests(t *testing.T) {
+	db := testDB(t, IdempotencyMigration())
+	started, release := make(chan struct{}), make(chan struct{})
+	r := problemEngine(true, Json("/orders", func(c *gin.Context, req orderRequest) (res orderResponse, status int, err error) {
+		close(started)
+		<-release
+		return orderResponse{Id: 1, Item: req.Item}, http.StatusCreated, nil
+	}).Post().Idempotent(db, IdempotencyConfig{}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`)
+	}()
+	<-started
+	if res := doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`); res.Code != http.StatusConflict {
+		t.Errorf("expected 409 while the first request is in progress; got %d", res.Code)
+	}
+	close(release)
+	if res := <-done; res.Code != http.StatusCreated {
+		t.Errorf("expected the first request to succeed; got %d", res.Code)
+	}
+}
+
+func TestIdempotencyLockTakeover(t *testing.T) {
+	db := testDB(t, IdempotencyMigration())
+	var calls int64
+	started, release := make(chan struct{}), make(chan struct{})
+	r := problemEngine(true, Json("/orders", func(c *gin.Context, req orderRequest) (res orderResponse, status int, err error) {
+		id := atomic.AddInt64(&calls, 1)
+		if id == 1 {
+			close(started)
+			<-release
+		}
+		return orderResponse{Id: id, Item: req.Item}, http.StatusCreated, nil
+	}).Post().Idempotent(db, IdempotencyConfig{LockTimeout: 10 * time.Millisecond}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`)
+	}()
+	<-started
+	time.Sleep(20 * time.Millisecond)
+	retry := doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`)
+	if retry.Code != http.StatusCreated {
+		t.Fatalf("expected the retry to take over the abandoned key; got %d", retry.Code)
+	}
+	close(release)
+	<-done
+	// the slow request no longer holds the lock so it can't replace the stored response
+	replay := doIdempotent(r, http.MethodPost, "/orders", "abc", `{"item":"book"}`)
+	if replay.Header().Get("Idempotency-Replayed") != "true" || replay.Body.String() != retry.Body.String() {
+		t.Errorf("expected the response of the retry to be replayed; got %s", replay.Body.String())
+	}
+}
+
+func TestIdempotencyRequired(t *testing.T) {
+	db := testDB(t, IdempotencyMigration())
+	r := problemEngine(true, Status("/orders", func(c *gin.Context) (int, error) {
+		return http.StatusNoContent, nil
+	}).Post().Idempotent(db, IdempotencyConfig{Required: true}))
+	p, res := doProblem(t, r, http.MethodPost, "/orders", "")
+	if res.Code != http.StatusPreconditionRequired || p.Status != http.StatusPreconditionRequired {
+		t.Errorf("expected 428; got %d", res.Code)
+	}
+	if res := doIdempotent(r, http.MethodPost, "/orders", "abc", ""); res.Code != http.StatusNoContent {
+		t.Errorf("expected 204; got %d", res.Code)
+	}
+	if res := doIdempotent(r, http.MethodPost, "/orders", "abc", ""); res.Code != http.StatusNoContent || res.Header().Get("Idempotency-Replayed") != "true" {
+		t.Errorf("expected the 204 to be replayed; got %d", res.Code)
+	}
+}
+
+func TestIdempotencyExpiredKeys(t *testing.T) {
+	db := testDB(t, IdempotencyMigration())
+	var calls int64
+	r := problemEngine(true, Status("/orders", func(c *gin.Context) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		return http.StatusNoContent, nil
+	}).Post().Idempotent(db, IdempotencyConfig{}))
+	doIdempotent(r, http.MethodPost, "/orders", "abc", "")
+	if _, err := db.Exec("UPDATE goof_idempotency SET expires_at = ?", time.Now().Add(-time.Second).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	doIdempotent(r, http.MethodPost, "/orders", "abc", "")
+	if calls != 2 {
+		t.Errorf("expected an expired key to run the handler again; got %d calls", calls)
+	}
+
+	db.Exec("UPDATE goof_idempotency SET expires_at = ?", time.Now().Add(-time.Second).Unix())
+	if err := PurgeIdempotencyKeys(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM goof_idempotency"); err != nil || count != 0 {
+		t.Errorf("expected expired keys to be purged; got %d %v", count, err)
+	}
+}
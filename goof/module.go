@@ -1,8 +1,14 @@
 package goof
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
 	"sort"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/sessions"
@@ -17,12 +23,36 @@ import (
 
 type HttpConfig struct {
 	Addr string
-	Host string
-	SSL  struct {
-		Enabled  bool
-		CertFile string
-		KeyFile  string
-	}
+	// Additional TCP addresses to listen on
+	Addrs []string
+	// Path of a Unix socket to listen on in addition to the addresses
+	UnixSocket string
+	// File mode of the Unix socket. Defaults to 0660.
+	UnixSocketMode os.FileMode
+	Host           string
+	SSL            SSLConfig
+	// Allowed origins for CORS and websocket connections. Any origin is allowed outside of production if this is
+	// empty.
+	CORS middleware.CORSConfig
+	// Responses are compressed unless this is set
+	DisableCompression bool
+	// Zero values are taken from middleware.DefaultCompressConfig
+	Compression middleware.CompressConfig
+
+	// Defaults to 10s
+	ReadHeaderTimeout time.Duration
+	// Defaults to 60s
+	ReadTimeout time.Duration
+	// Disabled by default since it would cut off streaming and websocket responses
+	WriteTimeout time.Duration
+	// Defaults to 120s
+	IdleTimeout time.Duration
+	// Defaults to http.DefaultMaxHeaderBytes
+	MaxHeaderBytes int
+	// Default limit of request bodies in bytes. Defaults to 10MB. Use a negative value to disable the limit.
+	MaxBodyBytes int64
+	// Serve HTTP/2 without TLS
+	H2C bool
 }
 
 type SessionStoreConfig struct {
@@ -38,8 +68,9 @@ type RootConfig struct {
 }
 
 type ControllerConfig struct {
-	Addr string
-	Host string
+	Addr       string
+	Host       string
+	Production bool
 }
 
 type Controller interface {
@@ -64,6 +95,14 @@ type ModuleApi interface {
 	GetSessionStore() (sessions.Store, error)
 }
 
+// Implemented by module APIs which can handle requests that don't match any route. Check for it with a type assertion.
+type NoRouteApi interface {
+	ModuleApi
+	// Add handlers for requests that don't match any route. Handlers that respond should call c.Abort so that later
+	// handlers and the default 404 response are skipped.
+	AddNoRoute(handlers ...gin.HandlerFunc)
+}
+
 type ControllersModule interface {
 	Controllers(db *sqlx.DB) []Controller
 }
@@ -108,14 +147,21 @@ type moduleDef struct {
 	config       interface{}
 	controllers  []Controller
 	migrations   []migrate.Migration
+	noRoute      []gin.HandlerFunc
 	dependsOn    []string
 	db           *sqlx.DB
 }
 
+var _ NoRouteApi = &moduleDef{}
+
 func (m *moduleDef) AddMigration(migrations ...migrate.Migration) {
 	m.migrations = append(m.migrations, migrations...)
 }
 
+func (m *moduleDef) AddNoRoute(handlers ...gin.HandlerFunc) {
+	m.noRoute = append(m.noRoute, handlers...)
+}
+
 func (m *moduleDef) AddController(controllers ...Controller) {
 	m.controllers = append(m.controllers, controllers...)
 }
@@ -140,6 +186,7 @@ func (m *moduleDef) GetDB() (db *sqlx.DB, err error) {
 type RootModule struct {
 	Config RootConfig
 	engine *gin.Engine
+	server *http.Server
 
 	hasInitialized bool
 	modules        []*moduleDef
@@ -218,12 +265,41 @@ func (r *RootModule) Run() (err error) {
 			return fmt.Errorf("Failed to init server:\n %w", err)
 		}
 	}
-	if r.Config.Http.SSL.Enabled {
-		log.Info().Msgf("Starting TLS server on '%s'", r.Config.Http.Addr)
-		return r.engine.RunTLS(r.Config.Http.Addr, r.Config.Http.SSL.CertFile, r.Config.Http.SSL.KeyFile)
+	listeners, err := r.listeners()
+	if err != nil {
+		return fmt.Errorf("Failed to listen:\n %w", err)
+	}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		log.Info().Bool("tls", r.Config.Http.SSL.Enabled).Msgf("Starting server on '%s'", l.Addr())
+		go func(l net.Listener) {
+			errs <- r.serve(l)
+		}(l)
 	}
-	log.Info().Msgf("Starting server on '%s'", r.Config.Http.Addr)
-	return r.engine.Run(r.Config.Http.Addr)
+	// every listener stops once the server is shutdown so the first error is enough
+	err = <-errs
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	r.server.Close()
+	return
+}
+
+// Gracefully stop the server. Open websocket connections are told the server is going away, in flight requests are
+// given until ctx is done to complete and then every module is closed.
+func (r *RootModule) Shutdown(ctx context.Context) (err error) {
+	if r.server != nil {
+		if err = r.server.Shutdown(ctx); err != nil {
+			return fmt.Errorf("Failed to shutdown server:\n %w", err)
+		}
+	}
+	for i := len(r.modules) - 1; i >= 0; i-- {
+		m := r.modules[i]
+		if err = m.module.Close(); err != nil {
+			return fmt.Errorf("Failed to Close module %s:\n %w", m.module.Id(), err)
+		}
+	}
+	return
 }
 
 // Get the engine instance
@@ -240,15 +316,41 @@ func (r *RootModule) initRoot() (err error) {
 	r.sessionStore = sessions.NewCookieStore(r.Config.SessionStore.KeyPairs...)
 	gin.SetMode(gin.ReleaseMode)
 	r.engine = gin.New()
-	r.engine.NoRoute(middleware.Log(), func(c *gin.Context) {
-		c.AbortWithStatus(404)
-	})
 
-	r.engine.Use(gin.Recovery(), middleware.Log())
+	r.engine.Use(
+		middleware.RequestId(),
+		middleware.Log(),
+	)
+	if !r.Config.Http.DisableCompression {
+		compression := r.Config.Http.Compression
+		if compression.MinSize == 0 {
+			compression.MinSize = middleware.DefaultCompressConfig.MinSize
+		}
+		r.engine.Use(middleware.CompressWithConfig(compression))
+	}
+	r.engine.Use(
+		ErrorHandler(r.Config.Production),
+		gin.CustomRecovery(recoverWithError),
+	)
+	if maxBody := r.Config.Http.MaxBodyBytes; maxBody >= 0 {
+		if maxBody == 0 {
+			maxBody = defaultMaxBodyBytes
+		}
+		r.engine.Use(middleware.BodyLimit(maxBody))
+	}
 	r.engine.Use(r.middleware...)
-	if !r.Config.Production {
+	if cors := r.Config.Http.CORS; len(cors.AllowOrigins) > 0 {
+		r.engine.Use(middleware.CORSWithConfig(cors))
+	} else if !r.Config.Production {
 		r.engine.Use(middleware.CORS())
 	}
+	r.engine.UseH2C = r.Config.Http.H2C
+	r.server = r.newServer()
+	if r.Config.Http.SSL.Enabled {
+		if r.server.TLSConfig, err = r.tlsConfig(); err != nil {
+			return fmt.Errorf("Failed to configure TLS:\n %w", err)
+		}
+	}
 
 	// TODO: reorder the modules based on dependencies
 	if err = r.resolveModuleDependencies(); err != nil {
@@ -301,8 +403,9 @@ func (r *RootModule) initControllers() (err error) {
 		}
 		for _, c := range m.controllers {
 			if err = c.Init(ControllerConfig{
-				Addr: r.Config.Http.Addr,
-				Host: r.Config.Http.Host,
+				Addr:       r.Config.Http.Addr,
+				Host:       r.Config.Http.Host,
+				Production: r.Config.Production,
 			}); err != nil {
 				return fmt.Errorf("Failed to Init controller from module %s:\n %w", m.module.Id(), err)
 			}
@@ -313,6 +416,13 @@ func (r *RootModule) initControllers() (err error) {
 			}
 		}
 	}
+	noRoute := []gin.HandlerFunc{}
+	for _, m := range r.modules {
+		noRoute = append(noRoute, m.noRoute...)
+	}
+	r.engine.NoRoute(append(noRoute, func(c *gin.Context) {
+		abortWithError(c, http.StatusNotFound, errors.New("no route"))
+	})...)
 	return
 }
 
@@ -0,0 +1,97 @@
+package goof
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	ghttp "github.com/wyattis/goof/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+const productionKey = "goof.production"
+
+// Check if error details should be hidden for this request. Assumes production unless ErrorHandler says otherwise.
+func isProduction(c *gin.Context) bool {
+	v, ok := c.Get(productionKey)
+	if !ok {
+		return true
+	}
+	return v.(bool)
+}
+
+// An RFC 7807 problem details response
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code,omitempty"`
+	RequestId string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// Create the problem for an HTTPError. Detail is written for clients so it is always included while the text of
+// wrapped errors is only included for client errors or outside of production.
+func NewProblem(err *HTTPError, production bool) Problem {
+	p := Problem{
+		Type:   "about:blank",
+		Title:  err.title(),
+		Status: err.Status,
+		Detail: err.Detail,
+		Code:   err.Code,
+		Errors: err.Fields,
+	}
+	if err.Err != nil && (!production || err.Status < http.StatusInternalServerError) {
+		if p.Detail == "" {
+			p.Detail = err.Err.Error()
+		} else {
+			p.Detail = fmt.Sprintf("%s: %s", p.Detail, err.Err)
+		}
+	}
+	return p
+}
+
+// Render any errors added to the context as an application/problem+json response. Nothing is rendered if the handler
+// already wrote a response.
+func ErrorHandler(production bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(productionKey, production)
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		status := c.Writer.Status()
+		if status < http.StatusBadRequest {
+			status = 0
+		}
+		writeProblem(c, toHTTPError(status, c.Errors.Last().Err), production)
+	}
+}
+
+func writeProblem(c *gin.Context, err *HTTPError, production bool) {
+	p := NewProblem(err, production)
+	p.Instance = c.Request.URL.Path
+	p.RequestId = ghttp.RequestId(c)
+	body, jsonErr := json.Marshal(p)
+	if jsonErr != nil {
+		c.Status(p.Status)
+		return
+	}
+	c.Data(p.Status, ProblemContentType, body)
+}
+
+// Record err on the context and abort the request. The response itself is rendered by ErrorHandler.
+func abortWithError(c *gin.Context, status int, err error) {
+	c.Error(toHTTPError(status, err))
+	c.Abort()
+}
+
+// Used with gin.CustomRecovery so that panics are rendered by ErrorHandler
+func recoverWithError(c *gin.Context, recovered any) {
+	abortWithError(c, http.StatusInternalServerError, fmt.Errorf("panic: %v", recovered))
+}
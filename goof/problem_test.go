@@ -0,0 +1,118 @@
+package goof
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type problemPayload struct {
+	Name string `json:"name" binding:"required"`
+}
+
+func problemEngine(production bool, routes ...Routable) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorHandler(production), gin.CustomRecovery(recoverWithError))
+	RouteGin(r, routes...)
+	return r
+}
+
+func doProblem(t *testing.T, r *gin.Engine, method, path, body string) (p Problem, res *httptest.ResponseRecorder) {
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(res, req)
+	if ct := res.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Fatalf("expected content type %s; got %s", ProblemContentType, ct)
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestProblemFromHTTPError(t *testing.T) {
+	r := problemEngine(true, ToJson("/", func(c *gin.Context) (res struct{}, status int, err error) {
+		err = NewHTTPError(http.StatusConflict, "already exists").WithCode("duplicate")
+		return
+	}).Get())
+	p, res := doProblem(t, r, http.MethodGet, "/", "")
+	if res.Code != http.StatusConflict || p.Status != http.StatusConflict {
+		t.Errorf("expected status 409; got %d", res.Code)
+	}
+	if p.Detail != "already exists" || p.Code != "duplicate" || p.Title != "Conflict" {
+		t.Errorf("unexpected problem %+v", p)
+	}
+}
+
+func TestProblemHidesInternalErrors(t *testing.T) {
+	route := ToJson("/", func(c *gin.Context) (res struct{}, status int, err error) {
+		err = errors.New("secret connection string")
+		return
+	}).Get()
+	p, res := doProblem(t, problemEngine(true, route), http.MethodGet, "/", "")
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500; got %d", res.Code)
+	}
+	if p.Detail != "" {
+		t.Errorf("expected internal error to be hidden in production; got %q", p.Detail)
+	}
+	p, _ = doProblem(t, problemEngine(false, route), http.MethodGet, "/", "")
+	if p.Detail != "secret connection string" {
+		t.Errorf("expected internal error outside of production; got %q", p.Detail)
+	}
+}
+
+func TestProblemShowsWrappedClientErrors(t *testing.T) {
+	r := problemEngine(true, FromJson("/", func(c *gin.Context, payload problemPayload) (status int, err error) {
+		return
+	}).Post())
+	p, res := doProblem(t, r, http.MethodPost, "/", `{"name": `)
+	if res.Code != http.StatusBadRequest || p.Detail == "" {
+		t.Errorf("expected a 400 with the decoding error in production; got %d %q", res.Code, p.Detail)
+	}
+	route := ToJson("/", func(c *gin.Context) (res struct{}, status int, err error) {
+		err = &HTTPError{Status: http.StatusConflict, Detail: "taken", Err: errors.New("name is taken")}
+		return
+	}).Get()
+	p, _ = doProblem(t, problemEngine(true, route), http.MethodGet, "/", "")
+	if p.Detail != "taken: name is taken" {
+		t.Errorf("expected the detail and wrapped error in production; got %q", p.Detail)
+	}
+}
+
+func TestToHTTPErrorCopiesSharedErrors(t *testing.T) {
+	shared := &HTTPError{Detail: "shared"}
+	if err := toHTTPError(http.StatusConflict, shared); err.Status != http.StatusConflict || shared.Status != 0 {
+		t.Errorf("expected a copy with the status; got %d and shared %d", err.Status, shared.Status)
+	}
+}
+
+func TestProblemValidationFields(t *testing.T) {
+	r := problemEngine(true, FromJson("/", func(c *gin.Context, payload problemPayload) (status int, err error) {
+		return
+	}).Post())
+	p, res := doProblem(t, r, http.MethodPost, "/", "{}")
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400; got %d", res.Code)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Message != "is required" {
+		t.Errorf("expected a single required field error; got %+v", p.Errors)
+	}
+}
+
+func TestProblemFromPanic(t *testing.T) {
+	r := problemEngine(true, Route("/", func(c *gin.Context) {
+		panic("boom")
+	}).Get())
+	p, _ := doProblem(t, r, http.MethodGet, "/", "")
+	if p.Status != http.StatusInternalServerError {
+		t.Errorf("expected status 500; got %d", p.Status)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/wyattis/goof/http/middleware"
 )
 
 type PipelineHandler[Payload any, Response any] func(*gin.Context, Payload) (Response, int, error)
@@ -23,19 +27,45 @@ type IRoute interface {
 	ResponseType() any
 }
 
+// Routes can implement IRouteDoc to describe their requests and responses to doc generators. Check for it with a type
+// assertion since routes from other packages may not implement it.
+type IRouteDoc interface {
+	IRoute
+	// Describes where each field of the request type is bound from
+	RequestFields() []RequestField
+	// The MIME types accepted for request bodies
+	Consumes() []string
+	// The MIME types responses can be encoded as
+	Produces() []string
+}
+
 type Routable interface {
 	Routes() []IRoute
 }
 
+var _ IRouteDoc = &route[struct{}, struct{}]{}
+
 type routeBuilder[Req any, Res any] struct {
 	route *route[Req, Res]
 }
 
 type route[Req any, Res any] struct {
-	method  string
-	pattern string
-	use     []gin.HandlerFunc
-	handler gin.HandlerFunc
+	method   string
+	pattern  string
+	use      []gin.HandlerFunc
+	handler  gin.HandlerFunc
+	produces []string
+	consumes []string
+	// request fields which aren't part of Req, such as the filters of a CRUD list route
+	params []RequestField
+}
+
+func newRoute[Req any, Res any](pattern string, produces, consumes []string) *route[Req, Res] {
+	return &route[Req, Res]{
+		pattern:  pattern,
+		produces: produces,
+		consumes: consumes,
+	}
 }
 
 func (b *route[Req, Res]) Method() string {
@@ -64,6 +94,43 @@ func (b *route[Req, Res]) ResponseType() any {
 	return res
 }
 
+func (b *route[Req, Res]) RequestFields() []RequestField {
+	return append(RequestFields(reflect.TypeOf((*Req)(nil)).Elem()), b.params...)
+}
+
+func (b *route[Req, Res]) Consumes() []string {
+	return mimeTypes(requestFormats(b.consumes))
+}
+
+func (b *route[Req, Res]) Produces() []string {
+	return mimeTypes(b.responseFormats())
+}
+
+func (b *route[Req, Res]) responseFormats() []*Format {
+	return responseFormats(b.produces, reflect.TypeOf((*Res)(nil)).Elem())
+}
+
+// Pick the response format using the Accept header
+func (b *route[Req, Res]) negotiate(c *gin.Context) (*Format, error) {
+	formats := b.responseFormats()
+	f := negotiateFormat(c.GetHeader("Accept"), formats)
+	if f == nil {
+		return nil, NewHTTPError(http.StatusNotAcceptable, fmt.Sprintf("acceptable types are %s", strings.Join(mimeTypes(formats), ", ")))
+	}
+	return f, nil
+}
+
+// Bind the request using the formats this route consumes
+func (b *route[Req, Res]) bind(c *gin.Context, payload *Req) error {
+	return bindRequest(c, payload, requestFormats(b.consumes))
+}
+
+func (b *route[Req, Res]) render(c *gin.Context, format *Format, status int, response Res) {
+	if err := format.Render(c, status, response); err != nil {
+		c.Error(err)
+	}
+}
+
 func (b *routeBuilder[Req, Res]) Routes() []IRoute {
 	return []IRoute{b.route}
 }
@@ -108,98 +175,131 @@ func (b *routeBuilder[Req, Res]) Use(handlers ...gin.HandlerFunc) *routeBuilder[
 	return b
 }
 
-// Takes in a JSON payload and returns a JSON response of the types provided
+// Add ETags and conditional request handling to the route. Full responses are stored for config.TTL if a store is
+// configured.
+func (b *routeBuilder[Req, Res]) Cache(config middleware.CacheConfig) *routeBuilder[Req, Res] {
+	return b.Use(middleware.Cache(config))
+}
+
+// Replay the stored response when a request is retried with the same Idempotency-Key header. See Idempotency.
+func (b *routeBuilder[Req, Res]) Idempotent(db *sqlx.DB, config IdempotencyConfig) *routeBuilder[Req, Res] {
+	return b.Use(Idempotency(db, config))
+}
+
+// Set the formats responses can be encoded as. Formats must be registered using RegisterFormat.
+func (b *routeBuilder[Req, Res]) ResponseFormats(names ...string) *routeBuilder[Req, Res] {
+	b.route.produces = names
+	return b
+}
+
+// Set the formats request bodies can be decoded from. Formats must be registered using RegisterFormat.
+func (b *routeBuilder[Req, Res]) RequestFormats(names ...string) *routeBuilder[Req, Res] {
+	b.route.consumes = names
+	return b
+}
+
+// Takes in a JSON payload and returns a JSON response of the types provided. The payload is also bound from the
+// path, query and headers using the uri, form and header tags. The request and response encodings are negotiated
+// using the Content-Type and Accept headers so other formats can be used as well.
 func Json[Req any, Res any](pattern string, handler PipelineHandler[Req, Res]) *routeBuilder[Req, Res] {
-	return &routeBuilder[Req, Res]{
-		route: &route[Req, Res]{
-			pattern: pattern,
-			handler: func(c *gin.Context) {
-				var payload Req
-				if err := c.BindJSON(&payload); err != nil {
-					c.Error(fmt.Errorf("invalid request: %+v", payload))
-					return
-				}
+	r := newRoute[Req, Res](pattern, DefaultResponseFormats, DefaultRequestFormats)
+	r.handler = func(c *gin.Context) {
+		format, err := r.negotiate(c)
+		if err != nil {
+			abortWithError(c, http.StatusNotAcceptable, err)
+			return
+		}
+		var payload Req
+		if err := r.bind(c, &payload); err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
 
-				response, status, err := handler(c, payload)
-				if err != nil {
-					if status == 0 {
-						status = http.StatusInternalServerError
-					}
-					c.Error(fmt.Errorf("handler failure: %+v", payload))
-					c.AbortWithError(status, err)
-					return
-				}
-				if status == 0 {
-					status = http.StatusOK
-				}
-				c.JSON(status, response)
-			},
-		},
+		response, status, err := handler(c, payload)
+		if err != nil {
+			abortWithError(c, status, err)
+			return
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		r.render(c, format, status, response)
+	}
+	return &routeBuilder[Req, Res]{route: r}
+}
+
+// Binds the request from the path, query and headers without reading the body and returns a JSON response of the
+// types provided. Useful for GET routes with typed parameters.
+func Handler[Req any, Res any](pattern string, handler PipelineHandler[Req, Res]) *routeBuilder[Req, Res] {
+	r := newRoute[Req, Res](pattern, DefaultResponseFormats, nil)
+	r.handler = func(c *gin.Context) {
+		format, err := r.negotiate(c)
+		if err != nil {
+			abortWithError(c, http.StatusNotAcceptable, err)
+			return
+		}
+		var payload Req
+		if err := r.bind(c, &payload); err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		response, status, err := handler(c, payload)
+		if err != nil {
+			abortWithError(c, status, err)
+			return
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		r.render(c, format, status, response)
 	}
+	return &routeBuilder[Req, Res]{route: r}
 }
 
 // Takes in any type of request and responds with a JSON response of the type provided
 func ToJson[Res any](pattern string, handler ResponseHandler[Res]) *routeBuilder[any, Res] {
-	return &routeBuilder[any, Res]{
-		route: &route[any, Res]{
-			pattern: pattern,
-			handler: func(c *gin.Context) {
-				response, status, err := handler(c)
-				if err != nil {
-					if status == 0 {
-						status = http.StatusInternalServerError
-					}
-					c.Error(fmt.Errorf("handler failure"))
-					c.AbortWithError(status, err)
-					return
-				}
-				if status == 0 {
-					status = http.StatusOK
-				}
-				c.JSON(status, response)
-			},
-		},
+	r := newRoute[any, Res](pattern, DefaultResponseFormats, nil)
+	r.handler = func(c *gin.Context) {
+		format, err := r.negotiate(c)
+		if err != nil {
+			abortWithError(c, http.StatusNotAcceptable, err)
+			return
+		}
+		response, status, err := handler(c)
+		if err != nil {
+			abortWithError(c, status, err)
+			return
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		r.render(c, format, status, response)
 	}
+	return &routeBuilder[any, Res]{route: r}
 }
 
-// Takes in a JSON payload and returns any response
+// Takes in a JSON payload and returns any response. The payload is also bound from the path, query and headers.
 func FromJson[Req any](pattern string, handler RequestHandler[Req]) *routeBuilder[Req, any] {
-	return &routeBuilder[Req, any]{
-		route: &route[Req, any]{
-			pattern: pattern,
-			handler: func(c *gin.Context) {
-				var payload Req
-				if err := c.BindJSON(&payload); err != nil {
-					c.Error(fmt.Errorf("invalid request: %+v", payload))
-					return
-				}
+	r := newRoute[Req, any](pattern, nil, DefaultRequestFormats)
+	r.handler = func(c *gin.Context) {
+		var payload Req
+		if err := r.bind(c, &payload); err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
 
-				// Struct level validation
-				// errs := validate.Struct(payload)
-				// if len(errs) > 0 {
-				// 	c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid request: %+v", payload))
-				// 	for _, err := range errs {
-				// 		c.Error(err)
-				// 	}
-				// 	return
-				// }
-
-				status, err := handler(c, payload)
-				if err != nil {
-					if status == 0 {
-						status = http.StatusInternalServerError
-					}
-					c.Error(fmt.Errorf("handler failure: %+v", payload))
-					c.AbortWithError(status, err)
-					return
-				}
-				if status == 0 {
-					status = http.StatusOK
-				}
-				c.Status(status)
-			},
-		},
+		status, err := handler(c, payload)
+		if err != nil {
+			abortWithError(c, status, err)
+			return
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		c.Status(status)
 	}
+	return &routeBuilder[Req, any]{route: r}
 }
 
 // Takes in any type of request and returns any response
@@ -220,10 +320,7 @@ func Status(pattern string, handler StatusHandler) *routeBuilder[struct{}, struc
 			handler: func(c *gin.Context) {
 				status, err := handler(c)
 				if err != nil {
-					if status == 0 {
-						status = http.StatusInternalServerError
-					}
-					c.AbortWithError(status, err)
+					abortWithError(c, status, err)
 					return
 				}
 				if status == 0 {
@@ -240,7 +337,8 @@ func RouteGin(router gin.IRouter, routes ...Routable) {
 	for _, r := range routes {
 		routes := r.Routes()
 		for _, r := range routes {
-			router.Handle(r.Method(), r.Pattern(), r.Handler()).Use(r.Uses()...)
+			handlers := append(append([]gin.HandlerFunc{}, r.Uses()...), r.Handler())
+			router.Handle(r.Method(), r.Pattern(), handlers...)
 		}
 	}
 }
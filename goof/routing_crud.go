@@ -1,24 +1,54 @@
 package goof
 
 import (
+	"bytes"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"path"
 	"reflect"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/jmoiron/sqlx"
+	"github.com/wyattis/goof/http/middleware"
 	"github.com/wyattis/goof/log"
+	"github.com/wyattis/goof/sql/driver"
 	"github.com/wyattis/z/zslice/zstrings"
 	"github.com/wyattis/z/zstring"
 )
 
+// The column CRUD controllers use to identify rows
+const crudKeyColumn = "id"
+
 type field struct {
 	name      string
+	index     []int
+	typ       reflect.Type
 	visible   bool
 	updatable bool
 	dbName    string
 	jsonName  string
+	// the quoted column name used in queries
+	column string
+}
+
+// Check if the field can be set to NULL
+func (f field) nullable() bool {
+	switch f.typ.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	}
+	// types like sql.NullString write NULL when they are zero
+	return f.typ.Implements(reflect.TypeOf((*sqldriver.Valuer)(nil)).Elem())
+}
+
+type crudIdRequest struct {
+	Id string `uri:"id" binding:"required"`
 }
 
 type CrudOpts struct {
@@ -30,12 +60,85 @@ type CrudOpts struct {
 	Update bool
 	Delete bool
 
-	PathName        string
-	VisibleFields   []string
+	PathName string
+	// JSON names of the fields included in responses
+	VisibleFields []string
+	// Column names of the fields clients can write. Writing any other field is rejected.
 	UpdatableFields []string
+	// JSON names of the fields the list route can be filtered by
+	FilterFields []string
+	// JSON names of the fields the list route can be sorted by. Lists are always sorted by id last. Fields which can
+	// be null, like pointers and sql.NullString, can't be used since cursors can't page past null values.
+	SortFields []string
+	// The number of items listed when the limit isn't given and the largest limit allowed. Default to
+	// DefaultPageSize and DefaultMaxPageSize.
+	PageSize    int
+	MaxPageSize int
+
+	// The database the SQL is written for. Defaults to the driver of the db.
+	Driver driver.Type
+
+	// Authorize each operation and limit the rows it can access
+	Policies CrudPolicies
+
+	// Columns set to the current time when a row is inserted and whenever it is updated, unless a hook sets them.
+	// Default to created_at and updated_at if the model has them. Use "-" to disable them. Clients can't write them.
+	CreatedAtColumn string
+	UpdatedAtColumn string
+	// Soft delete rows by setting this nullable column to the current time instead of removing them. Deleted rows are
+	// treated as missing. Defaults to deleted_at when SoftDelete is set.
+	SoftDelete      bool
+	DeletedAtColumn string
+	// Let clients include soft deleted rows in GET and LIST responses using ?include_deleted=true
+	IncludeDeleted bool
+	// Add POST /:id/restore which restores a soft deleted row. It is authorized by the Update policy.
+	Restore bool
+
+	// Add POST, PATCH and DELETE routes at /bulk which write arrays of items. PATCH items include their id and DELETE
+	// takes an array of ids. MaxBatchSize limits the number of items and defaults to DefaultMaxBatchSize.
+	Bulk         bool
+	MaxBatchSize int
+
+	// Detect conflicting writes using this column, such as version or updated_at. The field must be an integer, which
+	// is incremented by every write, or a time.Time, which every write sets to the current time in milliseconds and
+	// at least a millisecond after its previous value. On MySQL such a column needs fractional seconds, like
+	// DATETIME(3). GET responses include an ETag of the column which PUT, PATCH and DELETE require as If-Match, or as
+	// the column in the payload when the header is missing. Stale writes respond with 412 and writes without a version
+	// with 428.
+	VersionColumn string
+
+	// JSON names of the text fields the list route can search using ?q=, in the order of the columns given to the
+	// Search of the table's migration. SearchLanguage is the text search configuration used on Postgres and defaults
+	// to DefaultSearchLanguage. The snippets returned with ?highlight=true are safe HTML: the field text is escaped
+	// and only the <mark> tags around matches are markup.
+	SearchFields   []string
+	SearchLanguage string
+
+	// Add GET /export, which streams the rows matching the list filters as NDJSON or CSV, and POST /import, which
+	// creates the rows of an NDJSON or CSV body. Imports run in a single transaction unless ImportChunkSize is set, in
+	// which case each chunk of that many rows is committed separately. MaxImportSize is the largest import body in
+	// bytes, which replaces the server's body limit, and defaults to DefaultMaxImportSize.
+	Export          bool
+	Import          bool
+	ImportChunkSize int
+	MaxImportSize   int64
+
+	// Foreign keys to other tables, which can be included in responses and nest routes below the referenced rows
+	Relations []CrudRelation
+
+	// Limit who can read fields, by JSON name
+	FieldPermissions map[string]CrudFieldPermission
+
+	// Cache the GET routes. Cached responses are invalidated by writes when a store is configured. Caching skips
+	// relations: nested lists like /authors/:id/posts are never cached since writes through the other routes can't
+	// tell which of them changed, and rows embedded with include are only refreshed when this controller's rows are
+	// written, so keep the TTL short when includes are used.
+	Cache *middleware.CacheConfig
 }
 
-// Create a CRUD controller. DO NOT USE
+// Create a CRUD controller for the table of model. Rows are identified by their id column. Update adds PUT, which
+// replaces every updatable field, and PATCH, which only writes the fields present in the payload. Each request runs in
+// a transaction along with the hooks model implements, such as BeforeCreateHook.
 func CRUD[T any](db *sqlx.DB, model T, opts *CrudOpts) *crud[T] {
 	if opts == nil {
 		opts = &CrudOpts{
@@ -63,6 +166,37 @@ func CRUD[T any](db *sqlx.DB, model T, opts *CrudOpts) *crud[T] {
 		opts.UpdatableFields = getDbColumns(model)
 	}
 
+	opts.CreatedAtColumn = timestampColumn(model, opts.CreatedAtColumn, "created_at")
+	opts.UpdatedAtColumn = timestampColumn(model, opts.UpdatedAtColumn, "updated_at")
+	if opts.SoftDelete && opts.DeletedAtColumn == "" {
+		opts.DeletedAtColumn = "deleted_at"
+	} else if !opts.SoftDelete {
+		opts.DeletedAtColumn = ""
+	}
+	if opts.VersionColumn != "" && !zstrings.Contains(getDbColumns(model), opts.VersionColumn) {
+		panic(fmt.Errorf("table %s has no %s column", opts.Table, opts.VersionColumn))
+	}
+	automatic := []string{opts.CreatedAtColumn, opts.UpdatedAtColumn, opts.DeletedAtColumn, opts.VersionColumn}
+
+	for _, name := range opts.SearchFields {
+		if !zstrings.Contains(getJsonColumns(model), name) {
+			panic(fmt.Errorf("%s has no %s field to search", opts.Table, name))
+		}
+	}
+
+	for i, rel := range opts.Relations {
+		if !zstrings.Contains(getDbColumns(model), rel.ForeignKey.Column) {
+			panic(fmt.Errorf("table %s has no %s column", opts.Table, rel.ForeignKey.Column))
+		}
+		if rel.Name == "" {
+			opts.Relations[i].Name = strings.TrimSuffix(rel.ForeignKey.Column, "_id")
+		}
+	}
+
+	if opts.Driver == "" && db != nil {
+		opts.Driver = driver.Type(db.DriverName())
+	}
+
 	var fields []field
 
 	t := reflect.TypeOf(model)
@@ -70,14 +204,32 @@ func CRUD[T any](db *sqlx.DB, model T, opts *CrudOpts) *crud[T] {
 		f := t.Field(i)
 		ff := field{
 			name:     f.Name,
+			index:    f.Index,
+			typ:      f.Type,
 			dbName:   f.Tag.Get("db"),
-			jsonName: f.Tag.Get("json"),
+			jsonName: jsonName(f),
+			column:   opts.Driver.Quote(f.Tag.Get("db")),
+		}
+		// fields without a column aren't managed by the controller
+		if f.PkgPath != "" || ff.dbName == "" || ff.dbName == "-" {
+			continue
+		}
+		if ff.jsonName != "-" {
+			ff.visible = zstrings.Contains(opts.VisibleFields, ff.jsonName)
+			ff.updatable = zstrings.Contains(opts.UpdatableFields, ff.dbName) && !zstrings.Contains(automatic, ff.dbName)
 		}
-		ff.visible = zstrings.Contains(opts.VisibleFields, ff.jsonName)
-		ff.updatable = zstrings.Contains(opts.UpdatableFields, ff.dbName)
 		fields = append(fields, ff)
 	}
 
+	for _, f := range fields {
+		if zstrings.Contains(opts.SortFields, f.jsonName) && holdsNull(f.typ) {
+			panic(fmt.Errorf("%s can't be sorted by %s since it can be null", opts.Table, f.jsonName))
+		}
+		if f.dbName == opts.VersionColumn && !isInteger(f.typ) && f.typ != timeType {
+			panic(fmt.Errorf("the %s version column of %s must be an integer or a time.Time", opts.VersionColumn, opts.Table))
+		}
+	}
+
 	return &crud[T]{
 		opts:   *opts,
 		model:  model,
@@ -98,75 +250,618 @@ func (c *crud[T]) Routes() (routes []IRoute) {
 		routes = append(routes, c.getRoute().Routes()...)
 	}
 	if c.opts.List {
-		routes = append(routes, c.listRoute().Routes()...)
+		routes = append(routes, c.listRoute(nil).Routes()...)
 	}
 	if c.opts.Create {
-		routes = append(routes, c.createRoute().Routes()...)
+		routes = append(routes, c.createRoute(nil).Routes()...)
+	}
+	for i := range c.opts.Relations {
+		rel := &c.opts.Relations[i]
+		if !rel.Nested {
+			continue
+		}
+		if c.opts.List {
+			routes = append(routes, c.listRoute(rel).Routes()...)
+		}
+		if c.opts.Create {
+			routes = append(routes, c.createRoute(rel).Routes()...)
+		}
+	}
+	if c.opts.Update {
+		routes = append(routes, c.updateRoute().Routes()...)
+		routes = append(routes, c.patchRoute().Routes()...)
+	}
+	if c.opts.Delete {
+		routes = append(routes, c.deleteRoute().Routes()...)
+	}
+	if c.opts.Bulk {
+		if c.opts.Create {
+			routes = append(routes, c.bulkCreateRoute().Routes()...)
+		}
+		if c.opts.Update {
+			routes = append(routes, c.bulkPatchRoute().Routes()...)
+		}
+		if c.opts.Delete {
+			routes = append(routes, c.bulkDeleteRoute().Routes()...)
+		}
+	}
+	if c.opts.Restore && c.opts.DeletedAtColumn != "" {
+		routes = append(routes, c.restoreRoute().Routes()...)
+	}
+	if c.opts.Export {
+		routes = append(routes, c.exportRoute().Routes()...)
+	}
+	if c.opts.Import {
+		routes = append(routes, c.importRoute().Routes()...)
 	}
-	// TODO: update and delete
 	return
 }
 
-// Standard GET route for a CRUD controller
+// Standard GET route for a CRUD controller. Responses can be limited to some of the visible fields using
+// fields=id,name, in which case only the columns of those fields are selected.
 func (c *crud[T]) getRoute() Routable {
 	pattern := fmt.Sprintf("/%s/:id", c.opts.PathName)
-	cols := strings.Join(c.visibleColumns(), ",")
-	q := fmt.Sprintf("SELECT %s FROM `%s` WHERE id = ?", cols, c.opts.Table)
-	log.Debug().Str("sql", q).Msg("get route sql")
-	getStmt, err := c.db.Preparex(q)
-	if err != nil {
-		panic(err)
+	route := crudRoute[crudIdRequest, T](http.MethodGet, pattern, nil, func(ctx *gin.Context, req crudIdRequest) (res any, status int, err error) {
+		scope, err := authorize(ctx, c.opts.Policies.Get)
+		if err != nil {
+			return
+		}
+		if scope, err = c.readScope(ctx, scope); err != nil {
+			return
+		}
+		includes, err := c.parseIncludes(ctx.Query(includeParam))
+		if err != nil {
+			return
+		}
+		p, err := c.projection(ctx, ctx.Query(fieldsParam))
+		if err != nil {
+			return
+		}
+		id, err := c.parseId(req.Id)
+		if err != nil {
+			return
+		}
+		err = transaction(ctx, c.db, func(tx *sqlx.Tx) error {
+			item, err := c.findColumns(ctx, tx, id, scope, c.selectColumns(p, foreignKeys(c, includes)...))
+			if err != nil {
+				return err
+			}
+			presented, err := c.presentIncluding(ctx, tx, p, []T{item}, includes)
+			if err == nil {
+				c.setETag(ctx.Writer, item)
+				res = presented[0]
+			}
+			return err
+		})
+		return res, http.StatusOK, err
+	})
+	route.route.params = c.readParams()
+	if c.opts.Cache != nil {
+		route.Cache(*c.opts.Cache)
 	}
-	return Json(pattern, func(ctx *gin.Context, payload T) (res T, status int, err error) {
-		id := ctx.Param("id")
-		if id == "" {
-			err = fmt.Errorf("id is required")
-			status = http.StatusBadRequest
+	return route
+}
+
+// Standard CREATE route for a CRUD controller. The id is generated by the database unless the payload includes it. The
+// route is nested below the parent row if parent isn't nil.
+func (c *crud[T]) createRoute(parent *CrudRelation) Routable {
+	pattern := c.collectionPattern(parent)
+	return crudRoute[T, T](http.MethodPost, pattern, []string{"json"}, func(ctx *gin.Context, _ T) (res any, status int, err error) {
+		scope, err := authorize(ctx, c.opts.Policies.Create)
+		if err != nil {
 			return
 		}
-		err = getStmt.Get(&res, id)
-		return
+		var item T
+		var fk any
+		setParentKey := func() error {
+			if parent == nil {
+				return nil
+			}
+			f, _ := c.fieldByColumn(parent.ForeignKey.Column)
+			return setFieldValue(reflect.ValueOf(&item).Elem().FieldByIndex(f.index), fk)
+		}
+		if parent != nil {
+			if fk, err = c.parentKey(ctx, parent); err != nil {
+				return
+			}
+		}
+		// the key is set before decoding so it is validated and after so the path takes precedence over the body
+		if err = setParentKey(); err != nil {
+			return
+		}
+		present, err := c.decode(ctx, &item, false)
+		if err != nil {
+			return
+		}
+		if err = setParentKey(); err != nil {
+			return
+		}
+		var created T
+		err = transaction(ctx, c.db, func(tx *sqlx.Tx) (err error) {
+			if parent != nil {
+				if err = c.checkParent(ctx, tx, parent, fk); err != nil {
+					return
+				}
+			}
+			created, err = c.create(ctx, tx, item, present, parent, scope)
+			return
+		})
+		if err != nil {
+			return
+		}
+		c.setETag(ctx.Writer, created)
+		res = c.present(ctx, created)
+		c.invalidate(ctx, ctx.Request.URL.Path)
+		if parent != nil {
+			c.invalidate(ctx, path.Join(path.Dir(path.Dir(path.Dir(ctx.Request.URL.Path))), c.opts.PathName))
+		}
+		return res, http.StatusCreated, nil
+	})
+}
+
+// Standard PUT route for a CRUD controller. Every updatable field is replaced, using the zero value of fields missing
+// from the payload.
+func (c *crud[T]) updateRoute() Routable {
+	pattern := fmt.Sprintf("/%s/:id", c.opts.PathName)
+	return crudRoute[T, T](http.MethodPut, pattern, []string{"json"}, func(ctx *gin.Context, _ T) (res any, status int, err error) {
+		return c.update(ctx, func(existing T) (item T, present map[string]bool, include func(field) bool, err error) {
+			// fields clients can't write keep their values
+			item = existing
+			v := reflect.ValueOf(&item).Elem()
+			for _, f := range c.fields {
+				if f.updatable {
+					v.FieldByIndex(f.index).Set(reflect.Zero(f.typ))
+				}
+			}
+			present, err = c.decode(ctx, &item, true)
+			return item, present, func(f field) bool { return f.updatable }, err
+		})
+	})
+}
+
+// Standard PATCH route for a CRUD controller. Only the fields present in the payload are written, including fields
+// explicitly set to null.
+func (c *crud[T]) patchRoute() Routable {
+	pattern := fmt.Sprintf("/%s/:id", c.opts.PathName)
+	return crudRoute[T, T](http.MethodPatch, pattern, []string{"json"}, func(ctx *gin.Context, _ T) (res any, status int, err error) {
+		return c.update(ctx, func(existing T) (item T, present map[string]bool, include func(field) bool, err error) {
+			item = existing
+			present, err = c.decode(ctx, &item, true)
+			return item, present, func(f field) bool { return f.updatable && present[f.jsonName] }, err
+		})
+	})
+}
+
+// Standard DELETE route for a CRUD controller
+func (c *crud[T]) deleteRoute() Routable {
+	pattern := fmt.Sprintf("/%s/:id", c.opts.PathName)
+	return crudRoute[crudIdRequest, any](http.MethodDelete, pattern, nil, func(ctx *gin.Context, req crudIdRequest) (res any, status int, err error) {
+		scope, err := authorize(ctx, c.opts.Policies.Delete)
+		if err != nil {
+			return
+		}
+		scope = c.live(scope)
+		id, err := c.parseId(req.Id)
+		if err != nil {
+			return
+		}
+		err = transaction(ctx, c.db, func(tx *sqlx.Tx) error {
+			return c.delete(ctx, tx, id, scope, ctx.GetHeader("If-Match"), reflect.Value{})
+		})
+		if err != nil {
+			return
+		}
+		c.invalidate(ctx, path.Dir(ctx.Request.URL.Path))
+		return nil, http.StatusNoContent, nil
 	})
 }
 
-// Standard LIST route for a CRUD controller
-// TODO: this should be deprecated in favor of pagination
-func (c *crud[T]) listRoute() Routable {
-	pattern := fmt.Sprintf("/%s", c.opts.PathName)
-	cols := strings.Join(c.visibleColumns(), ",")
-	q := fmt.Sprintf("SELECT %s FROM `%s`", cols, c.opts.Table)
-	log.Debug().Str("sql", q).Msg("list route sql")
-	listStmt, err := c.db.Preparex(q)
+// Update the row with the id of the path. decode gets the new values of the row from the existing row along with the
+// fields which should be written.
+func (c *crud[T]) update(ctx *gin.Context, decode crudDecoder[T]) (res any, status int, err error) {
+	scope, err := authorize(ctx, c.opts.Policies.Update)
+	if err != nil {
+		return
+	}
+	scope = c.live(scope)
+	id, err := c.parseId(ctx.Param("id"))
 	if err != nil {
-		panic(err)
+		return
 	}
-	return Json(pattern, func(ctx *gin.Context, payload T) (res []T, status int, err error) {
-		err = listStmt.Select(&res)
+	var updated T
+	err = transaction(ctx, c.db, func(tx *sqlx.Tx) (err error) {
+		updated, err = c.updateRow(ctx, tx, id, scope, ctx.GetHeader("If-Match"), decode)
 		return
 	})
+	if err != nil {
+		return
+	}
+	c.setETag(ctx.Writer, updated)
+	res = c.present(ctx, updated)
+	c.invalidate(ctx, path.Dir(ctx.Request.URL.Path))
+	return res, http.StatusOK, nil
 }
 
-// Standard CREATE route for a CRUD controller
-func (c *crud[T]) createRoute() Routable {
-	pattern := fmt.Sprintf("/%s", c.opts.PathName)
-	cols := strings.Join(c.updatableColumns(), ",")
-	namedPlaceholder := strings.Join(c.updatableColumns(), ",")
-	q := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)", c.opts.Table, cols, namedPlaceholder)
-	createStmt, err := c.db.PrepareNamed(q)
-	log.Debug().Str("sql", q).Msg("create route sql")
+// Update a row within the scope and return it as it was stored. Fields changed by a BeforeUpdate hook are written
+// along with the fields selected by decode. ifMatch is the version the client expects the row to have.
+func (c *crud[T]) updateRow(ctx *gin.Context, tx *sqlx.Tx, id any, scope *CrudScope, ifMatch string, decode crudDecoder[T]) (updated T, err error) {
+	key := c.key()
+	existing, err := c.find(ctx, tx, id, scope)
 	if err != nil {
-		panic(err)
+		return
 	}
-	return Json(pattern, func(ctx *gin.Context, payload T) (res T, status int, err error) {
-		err = createStmt.Get(&res, payload)
+	item, present, include, err := decode(existing)
+	if err != nil {
 		return
-	})
+	}
+	v := reflect.ValueOf(&item).Elem()
+	v.FieldByIndex(key.index).Set(reflect.ValueOf(existing).FieldByIndex(key.index))
+	cond, condArgs := c.idCondition(id, scope)
+	guarded := false
+	if version, ok := c.versionField(); ok {
+		var expected reflect.Value
+		if present[version.jsonName] {
+			expected = v.FieldByIndex(version.index)
+		}
+		if err = c.checkVersion(ifMatch, existing, expected); err != nil {
+			return
+		}
+		// the version in the payload is only a precondition
+		current := reflect.ValueOf(existing).FieldByIndex(version.index)
+		v.FieldByIndex(version.index).Set(current)
+		// guards against writes between reading and updating the row
+		versionCond, arg := c.versionCondition(version, current.Interface())
+		cond += " AND " + versionCond
+		condArgs = append(condArgs, arg)
+		guarded = true
+	}
+	decoded := item
+	if err = beforeUpdate(ctx, tx, &item); err != nil {
+		return
+	}
+
+	var sets []string
+	var args []any
+	for _, f := range c.fields {
+		if f.dbName == key.dbName {
+			continue
+		}
+		value := v.FieldByIndex(f.index).Interface()
+		changed := !reflect.DeepEqual(value, reflect.ValueOf(decoded).FieldByIndex(f.index).Interface())
+		if !changed && f.dbName == c.opts.VersionColumn {
+			set, setArgs := c.nextVersion(f, value)
+			sets = append(sets, set)
+			args = append(args, setArgs...)
+		} else if !changed && f.dbName == c.opts.UpdatedAtColumn {
+			sets = append(sets, f.column+" = "+c.now())
+		} else if include(f) || changed {
+			sets = append(sets, f.column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if len(sets) > 0 {
+		q := c.rebind(fmt.Sprintf("UPDATE %s SET %s WHERE %s", c.table(), strings.Join(sets, ", "), cond))
+		result, err := tx.ExecContext(ctx, q, append(args, condArgs...)...)
+		if err != nil {
+			return updated, err
+		}
+		// MySQL only counts rows which changed so without the version condition no rows means nothing changed or the
+		// row is gone, which reading it back tells apart
+		if n, err := result.RowsAffected(); err == nil && n == 0 && guarded {
+			return updated, c.staleVersion()
+		}
+	}
+	updated, err = c.find(ctx, tx, id, scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return updated, c.outOfScope()
+	}
+	return
+}
+
+// Delete a row within the scope after running its BeforeDelete hook. Rows are only marked as deleted when soft
+// deletes are enabled. ifMatch or expected, which is invalid when missing, is the version the client expects the row
+// to have.
+func (c *crud[T]) delete(ctx *gin.Context, tx *sqlx.Tx, id any, scope *CrudScope, ifMatch string, expected reflect.Value) error {
+	existing, err := c.find(ctx, tx, id, scope)
+	if err != nil {
+		return err
+	}
+	if err = c.checkVersion(ifMatch, existing, expected); err != nil {
+		return err
+	}
+	if err = beforeDelete(ctx, tx, &existing); err != nil {
+		return err
+	}
+	cond, args := c.idCondition(id, scope)
+	version, guarded := c.versionField()
+	if guarded {
+		versionCond, arg := c.versionCondition(version, reflect.ValueOf(existing).FieldByIndex(version.index).Interface())
+		cond += " AND " + versionCond
+		args = append(args, arg)
+	}
+	q := fmt.Sprintf("DELETE FROM %s WHERE %s", c.table(), cond)
+	if c.opts.DeletedAtColumn != "" {
+		q = fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s", c.table(), c.opts.Driver.Quote(c.opts.DeletedAtColumn), c.now(), cond)
+	}
+	result, err := tx.ExecContext(ctx, c.rebind(q), args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 && guarded {
+		return c.staleVersion()
+	}
+	return nil
+}
+
+// Insert a decoded item and return the row as it was stored. present holds the JSON names of the fields in the
+// payload.
+func (c *crud[T]) create(ctx *gin.Context, tx *sqlx.Tx, item T, present map[string]bool, parent *CrudRelation, scope *CrudScope) (created T, err error) {
+	row, err := c.prepareInsert(ctx, tx, &item, present, parent)
+	if err != nil {
+		return
+	}
+	ids, err := c.insertRows(ctx, tx, []insertRow{row})
+	if err != nil {
+		return
+	}
+	return c.created(ctx, tx, ids[0], scope)
 }
 
+// Run the BeforeCreate hook of an item and get the values to insert. Fields set by the hook are written even if
+// clients can't set them.
+func (c *crud[T]) prepareInsert(ctx *gin.Context, tx *sqlx.Tx, item *T, present map[string]bool, parent *CrudRelation) (row insertRow, err error) {
+	key := c.key()
+	decoded := reflect.ValueOf(*item)
+	if err = beforeCreate(ctx, tx, item); err != nil {
+		return
+	}
+	v := reflect.ValueOf(*item)
+	for _, f := range c.fields {
+		changed := !reflect.DeepEqual(v.FieldByIndex(f.index).Interface(), decoded.FieldByIndex(f.index).Interface())
+		if !changed && c.timestamped(f) {
+			row.cols = append(row.cols, f.column)
+			row.values = append(row.values, c.now())
+			continue
+		}
+		if !changed && c.counted(f) {
+			row.cols = append(row.cols, f.column)
+			row.values = append(row.values, "1")
+			continue
+		}
+		fromPath := parent != nil && f.dbName == parent.ForeignKey.Column
+		if !changed && !fromPath && (!f.updatable || (f.dbName == key.dbName && !present[f.jsonName])) {
+			continue
+		}
+		row.cols = append(row.cols, f.column)
+		row.values = append(row.values, "?")
+		row.args = append(row.args, v.FieldByIndex(f.index).Interface())
+	}
+	if !v.FieldByIndex(key.index).IsZero() {
+		row.key = v.FieldByIndex(key.index).Interface()
+	}
+	return
+}
+
+// Read a row after it was inserted and run its AfterCreate hook. The row must be within the scope.
+func (c *crud[T]) created(ctx *gin.Context, tx *sqlx.Tx, id any, scope *CrudScope) (item T, err error) {
+	if item, err = c.inserted(ctx, tx, id, scope); err != nil {
+		return
+	}
+	err = afterCreate(ctx, tx, &item)
+	return
+}
+
+// Read a row after it was inserted without running any hooks. The row must be within the scope.
+func (c *crud[T]) inserted(ctx *gin.Context, tx *sqlx.Tx, id any, scope *CrudScope) (item T, err error) {
+	item, err = c.find(ctx, tx, id, scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return item, c.outOfScope()
+	}
+	return
+}
+
+// Decode the JSON body into item and validate it. Fields which aren't updatable are rejected and fields set to null
+// are cleared. Only the fields present in the body change, so patches are decoded into the existing row. The id is
+// ignored when updating an existing row. Returns the JSON names of the fields present in the body.
+func (c *crud[T]) decode(ctx *gin.Context, item *T, update bool) (present map[string]bool, err error) {
+	body, err := readJSONBody(ctx)
+	if err != nil {
+		return
+	}
+	return c.decodeJSON(body, item, update)
+}
+
+// Decode a JSON object into item like decode
+func (c *crud[T]) decodeJSON(body []byte, item *T, update bool) (present map[string]bool, err error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		body = []byte("{}")
+	}
+	var raw map[string]json.RawMessage
+	if err = json.Unmarshal(body, &raw); err != nil {
+		return nil, NewHTTPError(http.StatusBadRequest, "the body must be a JSON object")
+	}
+
+	present = map[string]bool{}
+	var fieldErrs []FieldError
+	v := reflect.ValueOf(item).Elem()
+	for name, value := range raw {
+		f, ok := c.fieldByJson(name)
+		switch {
+		case !ok:
+			fieldErrs = append(fieldErrs, FieldError{Field: name, Message: "is not a known field"})
+			continue
+		case update && f.dbName == c.key().dbName:
+			continue
+		case update && f.dbName == c.opts.VersionColumn && string(bytes.TrimSpace(value)) != "null":
+			// the version is the precondition of the update
+			present[name] = true
+			continue
+		case !f.updatable:
+			fieldErrs = append(fieldErrs, FieldError{Field: name, Message: "can't be written"})
+			continue
+		case string(bytes.TrimSpace(value)) == "null":
+			if !f.nullable() {
+				fieldErrs = append(fieldErrs, FieldError{Field: name, Message: "can't be null"})
+				continue
+			}
+			// decoding null leaves most values unchanged so they are cleared first
+			v.FieldByIndex(f.index).Set(reflect.Zero(f.typ))
+		}
+		present[name] = true
+	}
+	if len(fieldErrs) > 0 {
+		return nil, NewHTTPError(http.StatusBadRequest, "the request contains fields which can't be written").
+			WithCode("invalid_fields").WithFields(fieldErrs...)
+	}
+	if err = json.Unmarshal(body, item); err != nil {
+		return nil, WrapHTTPError(http.StatusBadRequest, err)
+	}
+	if binding.Validator != nil {
+		if err = binding.Validator.ValidateStruct(item); err != nil {
+			return nil, toHTTPError(http.StatusBadRequest, err)
+		}
+	}
+	return
+}
+
+// Insert n rows and return their generated ids, using RETURNING if the database supports it. Otherwise the ids of a
+// multi-row insert are consecutive, which they are for a single statement in SQLite and MySQL.
+func (c *crud[T]) insert(ctx *gin.Context, tx *sqlx.Tx, q string, args []any, n int) (ids []any, err error) {
+	if c.opts.Driver.Returning() {
+		rows, err := tx.QueryxContext(ctx, c.rebind(q+" RETURNING "+c.key().column), args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id any
+			if err = rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, rows.Err()
+	}
+	result, err := tx.ExecContext(ctx, c.rebind(q), args...)
+	if err != nil {
+		return
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return
+	}
+	if !c.opts.Driver.FirstInsertId() {
+		id -= int64(n - 1)
+	}
+	for i := 0; i < n; i++ {
+		ids = append(ids, id+int64(i))
+	}
+	return
+}
+
+// Get a single row by id within the scope and run its AfterFind hook. The error wraps sql.ErrNoRows if the row
+// doesn't exist.
+func (c *crud[T]) find(ctx *gin.Context, tx *sqlx.Tx, id any, scope *CrudScope) (item T, err error) {
+	return c.findColumns(ctx, tx, id, scope, c.columns())
+}
+
+// Get a single row like find, only selecting cols
+func (c *crud[T]) findColumns(ctx *gin.Context, tx *sqlx.Tx, id any, scope *CrudScope, cols []string) (item T, err error) {
+	cond, args := c.idCondition(id, scope)
+	q := c.rebind(fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(cols, ","), c.table(), cond))
+	if err = tx.GetContext(ctx, &item, q, args...); errors.Is(err, sql.ErrNoRows) {
+		return item, c.notFound(fmt.Sprint(id))
+	} else if err != nil {
+		return
+	}
+	err = afterFind(ctx, tx, &item)
+	return
+}
+
+// The condition selecting a row by id within the scope
+func (c *crud[T]) idCondition(id any, scope *CrudScope) (string, []any) {
+	conds, args := []string{c.key().column + " = ?"}, []any{id}
+	conds, args = scope.apply(conds, args)
+	return strings.Join(conds, " AND "), args
+}
+
+// Convert an id from the path to the type of the id column. An id which can't be converted doesn't match any row.
+func (c *crud[T]) parseId(id string) (any, error) {
+	v, err := filterValue(c.key(), id)
+	if err != nil {
+		return nil, c.notFound(id)
+	}
+	return v, nil
+}
+
+func (c *crud[T]) table() string {
+	return c.opts.Driver.Quote(c.opts.Table)
+}
+
+func (c *crud[T]) rebind(q string) string {
+	return c.opts.Driver.Rebind(q)
+}
+
+func (c *crud[T]) notFound(id string) error {
+	return &HTTPError{Status: http.StatusNotFound, Detail: fmt.Sprintf("%s %s was not found", c.opts.PathName, id), Err: sql.ErrNoRows}
+}
+
+// Written rows must stay within the scope of the policy
+func (c *crud[T]) outOfScope() error {
+	return NewHTTPError(http.StatusForbidden, fmt.Sprintf("the %s is outside of the rows you can write", c.opts.PathName))
+}
+
+// Remove the cached responses of a collection after it has been written to
+func (c *crud[T]) invalidate(ctx *gin.Context, collection string) {
+	if c.opts.Cache == nil || c.opts.Cache.Store == nil {
+		return
+	}
+	if err := c.opts.Cache.Store.Invalidate(ctx, collection); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("failed to invalidate cached responses")
+	}
+}
+
+// The field of the id column. Panics if the model doesn't have one.
+func (c *crud[T]) key() field {
+	for _, f := range c.fields {
+		if f.dbName == crudKeyColumn {
+			return f
+		}
+	}
+	panic(fmt.Errorf("table %s has no %s column", c.opts.Table, crudKeyColumn))
+}
+
+func (c *crud[T]) fieldByColumn(name string) (field, bool) {
+	for _, f := range c.fields {
+		if f.dbName == name {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func (c *crud[T]) fieldByJson(name string) (field, bool) {
+	for _, f := range c.fields {
+		if f.jsonName == name {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// The quoted names of every column
+func (c *crud[T]) columns() (cols []string) {
+	for _, f := range c.fields {
+		cols = append(cols, f.column)
+	}
+	return
+}
+
+// The quoted names of the visible columns
 func (c *crud[T]) visibleColumns() (cols []string) {
 	for _, f := range c.fields {
 		if f.visible {
-			cols = append(cols, f.dbName)
+			cols = append(cols, f.column)
 		}
 	}
 	return
@@ -181,6 +876,65 @@ func (c *crud[T]) updatableColumns() (cols []string) {
 	return
 }
 
+// Build a route for a CRUD controller. The response returned by handle is rendered as is, which lets the controller
+// render its view type in place of Res. Nothing is rendered if the response is nil.
+func crudRoute[Req any, Res any](method, pattern string, consumes []string, handle func(*gin.Context, Req) (any, int, error)) *routeBuilder[Req, Res] {
+	r := newRoute[Req, Res](pattern, DefaultResponseFormats, nil)
+	r.method = method
+	r.consumes = consumes
+	r.handler = func(ctx *gin.Context) {
+		format, err := r.negotiate(ctx)
+		if err != nil {
+			abortWithError(ctx, http.StatusNotAcceptable, err)
+			return
+		}
+		var req Req
+		// the body is decoded by the handler
+		if err := bindSources(ctx, &req, RequestFields(reflect.TypeOf(&req))); err != nil {
+			abortWithError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		res, status, err := handle(ctx, req)
+		if err != nil {
+			// the status is only meaningful for successful responses
+			if status < http.StatusBadRequest {
+				status = 0
+			}
+			abortWithError(ctx, status, err)
+			return
+		}
+		if res == nil {
+			ctx.Status(status)
+			return
+		}
+		if err := format.Render(ctx, status, res); err != nil {
+			ctx.Error(err)
+		}
+	}
+	return &routeBuilder[Req, Res]{route: r}
+}
+
+// The name encoding/json uses for a struct field
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// The column of an automatic timestamp. An empty name defaults to def if the model has that column and "-" disables
+// the timestamp.
+func timestampColumn[T any](m T, name, def string) string {
+	switch {
+	case name == "-":
+		return ""
+	case name == "" && zstrings.Contains(getDbColumns(m), def):
+		return def
+	}
+	return name
+}
+
 // Get the name of a type formatted for a path
 func getRouteName[T any](m T) string {
 	t := reflect.TypeOf(m)
@@ -213,7 +967,11 @@ func getDbColumns[T any](m T) (columns []string) {
 
 // Get all json columns
 func getJsonColumns[T any](m T) (columns []string) {
-	return getStructTags[T](m, "json")
+	t := reflect.TypeOf(m)
+	for i := 0; i < t.NumField(); i++ {
+		columns = append(columns, jsonName(t.Field(i)))
+	}
+	return
 }
 
 // Format column names as sqlx named placeholders
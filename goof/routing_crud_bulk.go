@@ -0,0 +1,317 @@
+package goof
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// The number of items a bulk request can contain when MaxBatchSize isn't set
+const DefaultMaxBatchSize = 1000
+
+// The query parameter which writes the items of a bulk request separately when it is false
+const atomicParam = "atomic"
+
+// The result of one item of a bulk request
+type BulkResult struct {
+	Index  int      `json:"index"`
+	Status int      `json:"status"`
+	Item   any      `json:"item,omitempty"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+// The response of a bulk request, with a result for each item in the order of the request
+type BulkResponse struct {
+	Results []BulkResult `json:"results"`
+}
+
+// The values of a row to insert. values holds the SQL of each value, which is a placeholder or an expression like the
+// current time.
+type insertRow struct {
+	cols   []string
+	values []string
+	args   []any
+	// the id of the row if the database doesn't generate it
+	key any
+}
+
+func (r insertRow) statement() string {
+	return strings.Join(r.cols, ",") + " VALUES " + strings.Join(r.values, ",")
+}
+
+// Insert rows using as few statements as the parameter limit of the database allows. Consecutive rows with the same
+// columns share a statement. Returns the id of each row.
+func (c *crud[T]) insertRows(ctx *gin.Context, tx *sqlx.Tx, rows []insertRow) (ids []any, err error) {
+	for start := 0; start < len(rows); {
+		maxRows := len(rows)
+		if n := len(rows[start].args); n > 0 {
+			maxRows = c.opts.Driver.MaxParams() / n
+		}
+		end := start + 1
+		for end < len(rows) && end-start < maxRows && rows[end].statement() == rows[start].statement() {
+			end++
+		}
+		batch := rows[start:end]
+		values := make([]string, len(batch))
+		var args []any
+		for i, row := range batch {
+			values[i] = "(" + strings.Join(row.values, ",") + ")"
+			args = append(args, row.args...)
+		}
+		q := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", c.table(), strings.Join(batch[0].cols, ","), strings.Join(values, ","))
+		generated, err := c.insert(ctx, tx, q, args, len(batch))
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range batch {
+			if row.key != nil {
+				ids = append(ids, row.key)
+			} else {
+				ids = append(ids, generated[i])
+			}
+		}
+		start = end
+	}
+	return
+}
+
+// Standard bulk CREATE route for a CRUD controller. Atomic requests insert the items using multi-row INSERT statements.
+func (c *crud[T]) bulkCreateRoute() Routable {
+	pattern := fmt.Sprintf("/%s/bulk", c.opts.PathName)
+	return c.bulkRoute(http.MethodPost, pattern, http.StatusCreated, c.opts.Policies.Create, func(ctx *gin.Context, items []json.RawMessage, scope *CrudScope, atomic bool) (results []BulkResult, err error) {
+		decode := func(raw json.RawMessage) (item T, present map[string]bool, err error) {
+			present, err = c.decodeJSON(raw, &item, false)
+			return
+		}
+		if !atomic {
+			return c.eachItem(ctx, items, http.StatusCreated, func(tx *sqlx.Tx, raw json.RawMessage) (any, error) {
+				item, present, err := decode(raw)
+				if err != nil {
+					return nil, err
+				}
+				created, err := c.create(ctx, tx, item, present, nil, scope)
+				return c.present(ctx, created), err
+			})
+		}
+		err = transaction(ctx, c.db, func(tx *sqlx.Tx) error {
+			rows := make([]insertRow, len(items))
+			for i, raw := range items {
+				item, present, err := decode(raw)
+				if err != nil {
+					return bulkItemError(i, err)
+				}
+				if rows[i], err = c.prepareInsert(ctx, tx, &item, present, nil); err != nil {
+					return bulkItemError(i, err)
+				}
+			}
+			ids, err := c.insertRows(ctx, tx, rows)
+			if err != nil {
+				return err
+			}
+			for i, id := range ids {
+				created, err := c.created(ctx, tx, id, scope)
+				if err != nil {
+					return bulkItemError(i, err)
+				}
+				results = append(results, BulkResult{Index: i, Status: http.StatusCreated, Item: c.present(ctx, created)})
+			}
+			return nil
+		})
+		return
+	})
+}
+
+// Standard bulk PATCH route for a CRUD controller. Each item is a patch which includes the id of the row it updates.
+func (c *crud[T]) bulkPatchRoute() Routable {
+	pattern := fmt.Sprintf("/%s/bulk", c.opts.PathName)
+	return c.bulkRoute(http.MethodPatch, pattern, http.StatusOK, c.opts.Policies.Update, func(ctx *gin.Context, items []json.RawMessage, scope *CrudScope, atomic bool) ([]BulkResult, error) {
+		scope = c.live(scope)
+		return c.forItems(ctx, items, atomic, http.StatusOK, func(tx *sqlx.Tx, raw json.RawMessage) (any, error) {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &fields); err != nil {
+				return nil, NewHTTPError(http.StatusBadRequest, "each item must be a JSON object")
+			}
+			id, err := c.parseJSONId(fields[c.key().jsonName])
+			if err != nil {
+				return nil, err
+			}
+			// the id may be a string which can't be decoded into the row
+			delete(fields, c.key().jsonName)
+			if raw, err = json.Marshal(fields); err != nil {
+				return nil, err
+			}
+			updated, err := c.updateRow(ctx, tx, id, scope, "", func(existing T) (item T, present map[string]bool, include func(field) bool, err error) {
+				item = existing
+				present, err = c.decodeJSON(raw, &item, true)
+				return item, present, func(f field) bool { return f.updatable && present[f.jsonName] }, err
+			})
+			return c.present(ctx, updated), err
+		})
+	})
+}
+
+// Standard bulk DELETE route for a CRUD controller. The body is an array of ids, or of objects with the id and version
+// of each row when the controller has a version column.
+func (c *crud[T]) bulkDeleteRoute() Routable {
+	pattern := fmt.Sprintf("/%s/bulk", c.opts.PathName)
+	return c.bulkRoute(http.MethodDelete, pattern, http.StatusOK, c.opts.Policies.Delete, func(ctx *gin.Context, items []json.RawMessage, scope *CrudScope, atomic bool) ([]BulkResult, error) {
+		scope = c.live(scope)
+		return c.forItems(ctx, items, atomic, http.StatusNoContent, func(tx *sqlx.Tx, raw json.RawMessage) (any, error) {
+			id, expected, err := c.parseDeleteItem(raw)
+			if err != nil {
+				return nil, err
+			}
+			return nil, c.delete(ctx, tx, id, scope, "", expected)
+		})
+	})
+}
+
+// Build a bulk route. The body must be a JSON array of at most MaxBatchSize items. Requests are all-or-nothing unless
+// ?atomic=false is given, which writes each item in its own transaction and responds with 207 and the result of each
+// item. Atomic requests which fail respond with the error of the first item which failed.
+func (c *crud[T]) bulkRoute(method, pattern string, status int, policy CrudPolicy, handle func(ctx *gin.Context, items []json.RawMessage, scope *CrudScope, atomic bool) ([]BulkResult, error)) Routable {
+	route := crudRoute[struct{}, BulkResponse](method, pattern, []string{"json"}, func(ctx *gin.Context, _ struct{}) (res any, _ int, err error) {
+		scope, err := authorize(ctx, policy)
+		if err != nil {
+			return
+		}
+		atomic := true
+		if raw, ok := ctx.GetQuery(atomicParam); ok {
+			if atomic, err = strconv.ParseBool(raw); err != nil {
+				return nil, 0, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be true or false", atomicParam))
+			}
+		}
+		body, err := readJSONBody(ctx)
+		if err != nil {
+			return
+		}
+		var items []json.RawMessage
+		if err = json.Unmarshal(body, &items); err != nil {
+			return nil, 0, NewHTTPError(http.StatusBadRequest, "the body must be a JSON array")
+		}
+		maxItems := c.opts.MaxBatchSize
+		if maxItems <= 0 {
+			maxItems = DefaultMaxBatchSize
+		}
+		if len(items) > maxItems {
+			return nil, 0, NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("bulk requests can contain at most %d items", maxItems))
+		}
+		results, err := handle(ctx, items, scope, atomic)
+		if err != nil {
+			return
+		}
+		c.invalidate(ctx, path.Dir(ctx.Request.URL.Path))
+		if !atomic {
+			status = http.StatusMultiStatus
+		}
+		if results == nil {
+			results = []BulkResult{}
+		}
+		return BulkResponse{Results: results}, status, nil
+	})
+	route.route.params = []RequestField{{Name: "Atomic", Key: atomicParam, Source: SourceQuery, Type: reflect.TypeOf(false),
+		Description: "Set to false to write each item separately and get the result of each"}}
+	return route
+}
+
+// Write each item using fn, either in one transaction or each in its own transaction
+func (c *crud[T]) forItems(ctx *gin.Context, items []json.RawMessage, atomic bool, status int, fn func(tx *sqlx.Tx, raw json.RawMessage) (any, error)) (results []BulkResult, err error) {
+	if !atomic {
+		return c.eachItem(ctx, items, status, fn)
+	}
+	err = transaction(ctx, c.db, func(tx *sqlx.Tx) error {
+		for i, raw := range items {
+			res, err := fn(tx, raw)
+			if err != nil {
+				return bulkItemError(i, err)
+			}
+			results = append(results, BulkResult{Index: i, Status: status, Item: res})
+		}
+		return nil
+	})
+	return
+}
+
+// Write each item in its own transaction and report the result of each
+func (c *crud[T]) eachItem(ctx *gin.Context, items []json.RawMessage, status int, fn func(tx *sqlx.Tx, raw json.RawMessage) (any, error)) (results []BulkResult, err error) {
+	results = make([]BulkResult, len(items))
+	for i, raw := range items {
+		results[i] = BulkResult{Index: i, Status: status}
+		err := transaction(ctx, c.db, func(tx *sqlx.Tx) (err error) {
+			results[i].Item, err = fn(tx, raw)
+			return
+		})
+		if err != nil {
+			httpErr := toHTTPError(http.StatusInternalServerError, err)
+			problem := NewProblem(httpErr, isProduction(ctx))
+			results[i] = BulkResult{Index: i, Status: httpErr.Status, Error: &problem}
+		}
+	}
+	return
+}
+
+// Parse an item of a bulk delete, which is an id or an object with the id and version of the row
+func (c *crud[T]) parseDeleteItem(raw json.RawMessage) (id any, expected reflect.Value, err error) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		id, err = c.parseJSONId(raw)
+		return
+	}
+	if id, err = c.parseJSONId(fields[c.key().jsonName]); err != nil {
+		return
+	}
+	if version, ok := c.versionField(); ok && fields[version.jsonName] != nil {
+		expected = reflect.New(version.typ)
+		if err = json.Unmarshal(fields[version.jsonName], expected.Interface()); err != nil {
+			return nil, expected, WrapHTTPError(http.StatusBadRequest, err)
+		}
+		expected = expected.Elem()
+	}
+	return
+}
+
+// Convert an id in a JSON body, which may be a string or a number
+func (c *crud[T]) parseJSONId(raw json.RawMessage) (any, error) {
+	var id string
+	if err := json.Unmarshal(raw, &id); err != nil {
+		id = string(raw)
+	}
+	if strings.TrimSpace(id) == "" {
+		return nil, NewHTTPError(http.StatusBadRequest, "each item must include an id")
+	}
+	return c.parseId(id)
+}
+
+// Add the index of an item to its error so clients can tell which item failed
+func bulkItemError(i int, err error) error {
+	httpErr := toHTTPError(http.StatusInternalServerError, err)
+	res := &HTTPError{Status: httpErr.Status, Code: httpErr.Code, Title: httpErr.Title, Err: httpErr.Err}
+	res.Detail = fmt.Sprintf("item %d", i)
+	if httpErr.Detail != "" {
+		res.Detail += ": " + httpErr.Detail
+	}
+	for _, f := range httpErr.Fields {
+		res.Fields = append(res.Fields, FieldError{Field: fmt.Sprintf("[%d].%s", i, f.Field), Message: f.Message})
+	}
+	return res
+}
+
+// Read a JSON request body
+func readJSONBody(ctx *gin.Context) ([]byte, error) {
+	if _, err := requestFormat(ctx.ContentType(), requestFormats([]string{"json"})); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return nil, WrapHTTPError(http.StatusBadRequest, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,167 @@
+package goof
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// The number of rows written to an export between flushes
+const exportFlushRows = 100
+
+// Standard export route for a CRUD controller at /export. Streams every row the list route would match as NDJSON or,
+// when text/csv is accepted, as CSV with a header of JSON names. Exports take the same filter, sort, q, fields and
+// include_deleted parameters as the list route but aren't paginated. Authorized by the List policy.
+func (c *crud[T]) exportRoute() Routable {
+	r := newRoute[struct{}, []T](fmt.Sprintf("/%s/export", c.opts.PathName), []string{"ndjson", "csv"}, nil)
+	r.method = http.MethodGet
+	r.handler = func(ctx *gin.Context) {
+		format, err := r.negotiate(ctx)
+		if err != nil {
+			abortWithError(ctx, http.StatusNotAcceptable, err)
+			return
+		}
+		q, p, scope, err := c.parseExport(ctx)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		q.where, q.args = scope.apply(q.where, q.args)
+
+		contentType := "application/x-ndjson"
+		if format.Name == "csv" {
+			contentType = "text/csv; charset=utf-8"
+		}
+		s := &stream{c: ctx, contentType: contentType}
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, c.opts.PathName, format.Name))
+		err = transaction(ctx, c.db, func(tx *sqlx.Tx) error {
+			return c.export(ctx, tx, q, p, format.Name, func(data []byte) error {
+				return s.write(func(w io.Writer) error {
+					_, err := w.Write(data)
+					return err
+				})
+			})
+		})
+		if err == nil {
+			if !s.started {
+				// nothing matched so only the headers are sent
+				s.write(func(io.Writer) error { return nil })
+			}
+			return
+		}
+		if !s.started {
+			abortWithError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		// the status has already been sent so the export is cut short
+		ctx.Error(err)
+	}
+	params := c.listParams()
+	for i := 0; i < len(params); i++ {
+		switch params[i].Key {
+		case "limit", "offset", "cursor", includeParam, highlightParam:
+			params = append(params[:i], params[i+1:]...)
+			i--
+		}
+	}
+	r.params = params
+	return &routeBuilder[struct{}, []T]{route: r}
+}
+
+// Parse an export request like a list request without pagination
+func (c *crud[T]) parseExport(ctx *gin.Context) (q listQuery, p *projection[T], scope *CrudScope, err error) {
+	if scope, err = authorize(ctx, c.opts.Policies.List); err != nil {
+		return
+	}
+	if scope, err = c.readScope(ctx, scope); err != nil {
+		return
+	}
+	values := ctx.Request.URL.Query()
+	for _, param := range []string{includeParam, highlightParam} {
+		if values.Has(param) {
+			return q, p, scope, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s can't be used with exports", param))
+		}
+	}
+	for _, param := range []string{"limit", "offset", "cursor"} {
+		values.Del(param)
+	}
+	if q, err = c.parseListQuery(values); err != nil {
+		return
+	}
+	if p, err = c.projection(ctx, values.Get(fieldsParam)); err != nil {
+		return
+	}
+	err = c.checkListPermissions(ctx, q)
+	return
+}
+
+// Write the rows matching q in the given format. Rows are buffered and passed to write in groups.
+func (c *crud[T]) export(ctx *gin.Context, tx *sqlx.Tx, q listQuery, p *projection[T], format string, write func([]byte) error) error {
+	query, args := c.selectQuery(q, p)
+	rows, err := tx.QueryxContext(ctx, c.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	buf := bytes.Buffer{}
+	var cw *csv.Writer
+	var cols []csvColumn
+	var record []string
+	if format == "csv" {
+		cw = csv.NewWriter(&buf)
+		cols = csvColumns(p.view)
+		record = make([]string, len(cols))
+		for i, col := range cols {
+			record[i] = col.name
+		}
+		if err = cw.Write(record); err != nil {
+			return err
+		}
+	}
+	flush := func() error {
+		if cw != nil {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+		}
+		if buf.Len() == 0 {
+			return nil
+		}
+		defer buf.Reset()
+		return write(buf.Bytes())
+	}
+	for n := 1; rows.Next(); n++ {
+		var item T
+		if err = rows.StructScan(&item); err != nil {
+			return err
+		}
+		if err = afterFind(ctx, tx, &item); err != nil {
+			return err
+		}
+		view := p.present(ctx, item)
+		if cw == nil {
+			err = writeNDJSON(&buf, view.Interface())
+		} else {
+			err = writeCSVRecord(cw, view, cols, record)
+		}
+		if err != nil {
+			return err
+		}
+		if n%exportFlushRows == 0 {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
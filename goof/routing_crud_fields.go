@@ -0,0 +1,125 @@
+package goof
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The query parameter selecting the fields of GET and LIST responses
+const fieldsParam = "fields"
+
+// Limits who can read a field. The field is visible to a request if Request allows it and otherwise only on the rows
+// Row allows. A permission without either hides the field.
+type CrudFieldPermission struct {
+	// Allows the field on every row of a request, such as for admins. Filtering and sorting by the field requires it.
+	Request func(c *gin.Context) bool
+	// Allows the field on a single row, such as for its owner. The field is omitted from the rows it doesn't allow.
+	Row func(c *gin.Context, row any) bool
+}
+
+// The fields a request can see and the type they are rendered as
+type projection[T any] struct {
+	fields []field
+	// the permissions of fields which are checked for each row, by field name
+	rowChecks map[string]func(*gin.Context, any) bool
+	view      reflect.Type
+}
+
+// Get the fields a request can see. fields limits them to a comma separated list of JSON names when it isn't empty.
+func (c *crud[T]) projection(ctx *gin.Context, fields string) (*projection[T], error) {
+	var requested map[string]bool
+	if fields != "" {
+		requested = map[string]bool{}
+		for _, name := range strings.Split(fields, ",") {
+			requested[strings.TrimSpace(name)] = true
+		}
+	}
+	p := &projection[T]{rowChecks: map[string]func(*gin.Context, any) bool{}}
+	var viewFields []reflect.StructField
+	model := reflect.TypeOf(c.model)
+	for _, f := range c.fields {
+		if !f.visible || (requested != nil && !requested[f.jsonName]) {
+			continue
+		}
+		sf := model.FieldByIndex(f.index)
+		sf = reflect.StructField{Name: sf.Name, Type: sf.Type, Tag: sf.Tag}
+		if !c.readable(ctx, f) {
+			perm := c.opts.FieldPermissions[f.jsonName]
+			if perm.Row == nil {
+				continue
+			}
+			// fields which are allowed per row are omitted from the other rows
+			p.rowChecks[f.name] = perm.Row
+			sf.Type = reflect.TypeOf((*any)(nil)).Elem()
+			sf.Tag = omitEmpty(sf.Tag)
+		}
+		p.fields = append(p.fields, f)
+		viewFields = append(viewFields, sf)
+		delete(requested, f.jsonName)
+	}
+	for name := range requested {
+		if name != "" {
+			return nil, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s isn't a visible field", name))
+		}
+	}
+	p.view = reflect.StructOf(viewFields)
+	return p, nil
+}
+
+// Check if the request can read a field on every row
+func (c *crud[T]) readable(ctx *gin.Context, f field) bool {
+	perm, ok := c.opts.FieldPermissions[f.jsonName]
+	return !ok || (perm.Request != nil && perm.Request(ctx))
+}
+
+// Render the fields of item the request can see
+func (c *crud[T]) present(ctx *gin.Context, item T) any {
+	p, _ := c.projection(ctx, "")
+	return p.present(ctx, item).Interface()
+}
+
+func (p *projection[T]) present(ctx *gin.Context, item T) reflect.Value {
+	view := reflect.New(p.view).Elem()
+	v := reflect.ValueOf(item)
+	for i, f := range p.fields {
+		if check, ok := p.rowChecks[f.name]; ok && !check(ctx, item) {
+			continue
+		}
+		view.Field(i).Set(v.FieldByIndex(f.index))
+	}
+	return view
+}
+
+// The quoted columns to select for the projection, which always include the id, the version and the columns of
+// extra. Every column is selected when fields are checked per row since the checks can depend on any column.
+func (c *crud[T]) selectColumns(p *projection[T], extra ...field) (cols []string) {
+	if len(p.rowChecks) > 0 {
+		return c.columns()
+	}
+	selected := map[string]bool{c.key().dbName: true, c.opts.VersionColumn: true}
+	for _, f := range append(p.fields, extra...) {
+		selected[f.dbName] = true
+	}
+	for _, f := range c.fields {
+		if selected[f.dbName] {
+			cols = append(cols, f.column)
+		}
+	}
+	return
+}
+
+// Add omitempty to the json tag so nil values are left out
+func omitEmpty(tag reflect.StructTag) reflect.StructTag {
+	json, ok := tag.Lookup("json")
+	if !ok {
+		return reflect.StructTag(strings.TrimSpace(fmt.Sprintf(`%s json:",omitempty"`, tag)))
+	}
+	if strings.Contains(json, ",omitempty") {
+		return tag
+	}
+	return reflect.StructTag(strings.Replace(string(tag), fmt.Sprintf(`json:"%s"`, json), fmt.Sprintf(`json:"%s,omitempty"`, json), 1))
+}
@@ -0,0 +1,124 @@
+package goof
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Called before a row is inserted. This and the following hooks can be implemented by a model used with CRUD, usually
+// with a pointer receiver so the hook can change the row. Hooks run inside the transaction of the request and
+// returning an error rolls it back. Errors respond with 500 unless they are an HTTPError with their own status.
+type BeforeCreateHook interface {
+	BeforeCreate(c *gin.Context, tx *sqlx.Tx) error
+}
+
+// Called after a row is inserted with the row as it was stored
+type AfterCreateHook interface {
+	AfterCreate(c *gin.Context, tx *sqlx.Tx) error
+}
+
+// Called with the new values of a row before it is updated. Fields changed by the hook are written as well, even if
+// they aren't updatable by clients.
+type BeforeUpdateHook interface {
+	BeforeUpdate(c *gin.Context, tx *sqlx.Tx) error
+}
+
+// Called with the row which is about to be deleted
+type BeforeDeleteHook interface {
+	BeforeDelete(c *gin.Context, tx *sqlx.Tx) error
+}
+
+// Called for every row read from the database
+type AfterFindHook interface {
+	AfterFind(c *gin.Context, tx *sqlx.Tx) error
+}
+
+// Authorizes a CRUD operation. Returning an error denies the request with 403 unless the error is an HTTPError with
+// its own status. A scope limits the rows the operation can read or write.
+type CrudPolicy func(c *gin.Context) (*CrudScope, error)
+
+// An SQL condition rows must match, like owner_id = ?, with ? placeholders for Args. Rows outside of the scope are
+// treated as missing. Created and updated rows must still match the scope or the request is denied.
+type CrudScope struct {
+	Where string
+	Args  []any
+}
+
+// The policy of each CRUD operation. Operations without a policy are allowed. Update applies to PUT and PATCH.
+type CrudPolicies struct {
+	Get    CrudPolicy
+	List   CrudPolicy
+	Create CrudPolicy
+	Update CrudPolicy
+	Delete CrudPolicy
+}
+
+// Add the scope to the conditions of a query
+func (s *CrudScope) apply(conds []string, args []any) ([]string, []any) {
+	if s == nil || s.Where == "" {
+		return conds, args
+	}
+	return append(conds, "("+s.Where+")"), append(args, s.Args...)
+}
+
+// Check the policy of an operation
+func authorize(c *gin.Context, policy CrudPolicy) (*CrudScope, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	scope, err := policy(c)
+	if err != nil {
+		return nil, toHTTPError(http.StatusForbidden, err)
+	}
+	return scope, nil
+}
+
+// Run fn in a transaction which is committed if fn succeeds
+func transaction(c *gin.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(c, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func beforeCreate(c *gin.Context, tx *sqlx.Tx, item any) error {
+	if hook, ok := item.(BeforeCreateHook); ok {
+		return hook.BeforeCreate(c, tx)
+	}
+	return nil
+}
+
+func afterCreate(c *gin.Context, tx *sqlx.Tx, item any) error {
+	if hook, ok := item.(AfterCreateHook); ok {
+		return hook.AfterCreate(c, tx)
+	}
+	return nil
+}
+
+func beforeUpdate(c *gin.Context, tx *sqlx.Tx, item any) error {
+	if hook, ok := item.(BeforeUpdateHook); ok {
+		return hook.BeforeUpdate(c, tx)
+	}
+	return nil
+}
+
+func beforeDelete(c *gin.Context, tx *sqlx.Tx, item any) error {
+	if hook, ok := item.(BeforeDeleteHook); ok {
+		return hook.BeforeDelete(c, tx)
+	}
+	return nil
+}
+
+func afterFind(c *gin.Context, tx *sqlx.Tx, item any) error {
+	if hook, ok := item.(AfterFindHook); ok {
+		return hook.AfterFind(c, tx)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package goof
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/wyattis/goof/http/middleware"
+)
+
+// The query parameters of imports
+const (
+	dryRunParam    = "dry_run"
+	chunkSizeParam = "chunk_size"
+)
+
+// The number of rows inserted by each statement of an import
+const importBatchSize = 500
+
+// The largest import body in bytes unless MaxImportSize is set
+const DefaultMaxImportSize = 256 << 20
+
+// The longest line of an NDJSON import
+const maxImportLine = 1 << 20
+
+// Returned to roll back a transaction which didn't fail
+var errRollback = errors.New("rollback")
+
+// The response of an import
+type ImportResponse struct {
+	// The number of rows read from the file
+	Rows int `json:"rows"`
+	// The number of rows created, or which would have been created by a dry run
+	Created int `json:"created"`
+	// The number of rows which weren't created, including the valid rows of chunks which failed
+	Failed int  `json:"failed"`
+	DryRun bool `json:"dryRun"`
+	// The rows which failed, indexed from 0 for the first row after any header
+	Errors []BulkResult `json:"errors"`
+}
+
+// Standard import route for a CRUD controller at /import. The body is NDJSON or CSV with a header of JSON names,
+// depending on the Content-Type. Each row is validated and created like a POST. Imports run in a single transaction
+// unless chunk_size or ImportChunkSize is set, which commits each chunk of rows separately. A chunk with any invalid
+// row isn't written. Each chunk is read and validated before its transaction starts so a slow upload doesn't hold the
+// database's write lock, which keeps a whole import without chunks in memory. dry_run=true validates and inserts the
+// rows without committing them. BeforeCreate hooks run on dry runs since they can set fields the insert needs while
+// AfterCreate hooks are skipped. Responds with 201 when every row was created, 422 when no rows were created because
+// of errors and 207 when some chunks failed. A malformed file fails the request, although the chunks before the
+// malformed row stay committed.
+func (c *crud[T]) importRoute() Routable {
+	pattern := fmt.Sprintf("/%s/import", c.opts.PathName)
+	maxSize := c.opts.MaxImportSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxImportSize
+	}
+	route := crudRoute[struct{}, ImportResponse](http.MethodPost, pattern, []string{"ndjson", "csv"}, func(ctx *gin.Context, _ struct{}) (res any, status int, err error) {
+		middleware.SetBodyLimit(ctx, maxSize)
+		scope, err := authorize(ctx, c.opts.Policies.Create)
+		if err != nil {
+			return
+		}
+		dryRun := false
+		if raw, ok := ctx.GetQuery(dryRunParam); ok {
+			if dryRun, err = strconv.ParseBool(raw); err != nil {
+				return nil, 0, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be true or false", dryRunParam))
+			}
+		}
+		chunkSize := c.opts.ImportChunkSize
+		if raw, ok := ctx.GetQuery(chunkSizeParam); ok {
+			if chunkSize, err = strconv.Atoi(raw); err != nil || chunkSize < 0 {
+				return nil, 0, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be a non-negative integer", chunkSizeParam))
+			}
+		}
+		next, err := c.importReader(ctx)
+		if err != nil {
+			return
+		}
+		result, err := c.importRows(ctx, scope, next, chunkSize, dryRun)
+		if err != nil {
+			return
+		}
+		if result.Created > 0 && !dryRun {
+			c.invalidate(ctx, path.Dir(ctx.Request.URL.Path))
+		}
+		switch {
+		case result.Failed == 0 && dryRun:
+			status = http.StatusOK
+		case result.Failed == 0:
+			status = http.StatusCreated
+		case result.Created == 0:
+			status = http.StatusUnprocessableEntity
+		default:
+			status = http.StatusMultiStatus
+		}
+		return result, status, nil
+	})
+	route.route.params = []RequestField{
+		{Name: "DryRun", Key: dryRunParam, Source: SourceQuery, Type: reflect.TypeOf(false),
+			Description: "Validate and insert the rows without committing them"},
+		{Name: "ChunkSize", Key: chunkSizeParam, Source: SourceQuery, Type: reflect.TypeOf(0),
+			Description: "Commit every chunk of this many rows separately instead of using a single transaction"},
+	}
+	return route
+}
+
+// Import the rows returned by next in chunks of chunkSize rows, or all at once if it is 0. Each chunk runs in its own
+// transaction which is rolled back if any of its rows fail.
+func (c *crud[T]) importRows(ctx *gin.Context, scope *CrudScope, next func() (json.RawMessage, error), chunkSize int, dryRun bool) (res ImportResponse, err error) {
+	res = ImportResponse{DryRun: dryRun, Errors: []BulkResult{}}
+	fail := func(i int, err error) {
+		httpErr := toHTTPError(http.StatusInternalServerError, err)
+		problem := NewProblem(httpErr, isProduction(ctx))
+		res.Errors = append(res.Errors, BulkResult{Index: i, Status: httpErr.Status, Error: &problem})
+	}
+	for done := false; !done; {
+		chunk := importChunk[T]{}
+		rows := 0
+		failed := false
+		for chunkSize <= 0 || rows < chunkSize {
+			raw, err := next()
+			if err == io.EOF {
+				done = true
+				break
+			} else if err != nil {
+				return res, err
+			}
+			i := res.Rows
+			res.Rows++
+			rows++
+			var item T
+			present, err := c.decodeJSON(raw, &item, false)
+			if err != nil {
+				fail(i, err)
+				failed = true
+				continue
+			}
+			// once the chunk has failed the rest of its rows are only validated
+			if !failed {
+				chunk.items = append(chunk.items, item)
+				chunk.present = append(chunk.present, present)
+				chunk.indices = append(chunk.indices, i)
+			}
+		}
+		if !failed && len(chunk.items) > 0 {
+			if failed, err = c.insertChunk(ctx, scope, chunk, dryRun, fail); err != nil {
+				return
+			}
+		}
+		if failed {
+			res.Failed += rows
+		} else {
+			res.Created += rows
+		}
+	}
+	return
+}
+
+// The valid rows of an import chunk and their indices in the file
+type importChunk[T any] struct {
+	items   []T
+	present []map[string]bool
+	indices []int
+}
+
+// Insert a chunk in its own transaction. Returns if any row failed, which rolls back the whole chunk.
+func (c *crud[T]) insertChunk(ctx *gin.Context, scope *CrudScope, chunk importChunk[T], dryRun bool, fail func(int, error)) (failed bool, err error) {
+	err = transaction(ctx, c.db, func(tx *sqlx.Tx) error {
+		var rows []insertRow
+		var indices []int
+		insert := func() bool {
+			if len(rows) == 0 {
+				return true
+			}
+			ids, err := c.insertRows(ctx, tx, rows)
+			if err != nil {
+				// the database doesn't say which row of the statement failed
+				for _, i := range indices {
+					fail(i, err)
+				}
+				return false
+			}
+			for i, id := range ids {
+				// AfterCreate hooks can have side effects outside of the transaction so dry runs skip them
+				if dryRun {
+					_, err = c.inserted(ctx, tx, id, scope)
+				} else {
+					_, err = c.created(ctx, tx, id, scope)
+				}
+				if err != nil {
+					fail(indices[i], err)
+					return false
+				}
+			}
+			rows, indices = rows[:0], indices[:0]
+			return true
+		}
+		for j := range chunk.items {
+			row, err := c.prepareInsert(ctx, tx, &chunk.items[j], chunk.present[j], nil)
+			if err != nil {
+				fail(chunk.indices[j], err)
+				failed = true
+				return errRollback
+			}
+			rows, indices = append(rows, row), append(indices, chunk.indices[j])
+			if len(rows) >= importBatchSize && !insert() {
+				failed = true
+				return errRollback
+			}
+		}
+		if !insert() {
+			failed = true
+			return errRollback
+		}
+		if dryRun {
+			return errRollback
+		}
+		return nil
+	})
+	if errors.Is(err, errRollback) {
+		err = nil
+	}
+	return
+}
+
+// Get a function which returns each row of the request body as a JSON object, depending on the Content-Type. Returns
+// io.EOF after the last row.
+func (c *crud[T]) importReader(ctx *gin.Context) (func() (json.RawMessage, error), error) {
+	mediaType, _, err := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+	if err != nil {
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, "imports must be application/x-ndjson or text/csv")
+	}
+	switch mediaType {
+	case "application/x-ndjson":
+		scanner := bufio.NewScanner(ctx.Request.Body)
+		scanner.Buffer(nil, maxImportLine)
+		line := 0
+		return func() (json.RawMessage, error) {
+			for scanner.Scan() {
+				line++
+				raw := bytes.TrimSpace(scanner.Bytes())
+				if len(raw) == 0 {
+					continue
+				}
+				if !json.Valid(raw) {
+					return nil, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("line %d isn't valid JSON", line))
+				}
+				return append(json.RawMessage{}, raw...), nil
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, toHTTPError(http.StatusBadRequest, err)
+			}
+			return nil, io.EOF
+		}, nil
+	case "text/csv":
+		r := csv.NewReader(ctx.Request.Body)
+		header, err := r.Read()
+		if err == io.EOF {
+			return func() (json.RawMessage, error) { return nil, io.EOF }, nil
+		} else if err != nil {
+			return nil, toHTTPError(http.StatusBadRequest, err)
+		}
+		fields := make([]field, len(header))
+		var unknown []FieldError
+		for i, name := range header {
+			var ok bool
+			if fields[i], ok = c.fieldByJson(name); !ok {
+				unknown = append(unknown, FieldError{Field: name, Message: "is not a known field"})
+			}
+		}
+		if len(unknown) > 0 {
+			return nil, NewHTTPError(http.StatusBadRequest, "the header has unknown columns").WithFields(unknown...)
+		}
+		return func() (json.RawMessage, error) {
+			record, err := r.Read()
+			if err == io.EOF {
+				return nil, err
+			} else if err != nil {
+				return nil, toHTTPError(http.StatusBadRequest, err)
+			}
+			obj := make(map[string]json.RawMessage, len(record))
+			for i, cell := range record {
+				if value, ok := csvCell(fields[i], cell); ok {
+					obj[fields[i].jsonName] = value
+				}
+			}
+			return json.Marshal(obj)
+		}, nil
+	default:
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, "imports must be application/x-ndjson or text/csv")
+	}
+}
+
+// Convert a CSV cell to the JSON of a field. Empty cells are null for nullable fields, empty for strings and otherwise
+// left out so the field keeps its zero value.
+func csvCell(f field, cell string) (json.RawMessage, bool) {
+	t := f.typ
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if cell == "" {
+		switch {
+		case f.nullable():
+			return json.RawMessage("null"), true
+		case t.Kind() != reflect.String:
+			return nil, false
+		}
+	}
+	// cells which are valid JSON, like numbers, are used as is and anything else is a string, like times
+	if t.Kind() != reflect.String && json.Valid([]byte(cell)) {
+		return json.RawMessage(cell), true
+	}
+	value, _ := json.Marshal(cell)
+	return value, true
+}
@@ -0,0 +1,544 @@
+package goof
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/wyattis/z/zslice/zstrings"
+)
+
+const (
+	DefaultPageSize    = 50
+	DefaultMaxPageSize = 100
+)
+
+// A page of a CRUD list. Pass NextCursor as the cursor query parameter to get the following page.
+type Page[T any] struct {
+	Items []T `json:"items" xml:"items"`
+	// The number of items matching the filters. Only counted for offset pagination.
+	Total *int64 `json:"total,omitempty" xml:"total,omitempty"`
+	// Empty on the last page
+	NextCursor string `json:"nextCursor,omitempty" xml:"nextCursor,omitempty"`
+}
+
+// Comparison operators which can be appended to the name of a filter, like count.gte=2
+var filterOperators = map[string]string{
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"in":   "IN",
+	"like": "LIKE",
+}
+
+// Query parameters used by the list route which aren't filters
+var listQueryParams = []string{"limit", "offset", "cursor", "sort", includeDeletedParam, includeParam, fieldsParam,
+	searchParam, highlightParam}
+
+type sortColumn struct {
+	field field
+	desc  bool
+}
+
+// A parsed list request
+type listQuery struct {
+	where  []string
+	args   []any
+	order  []sortColumn
+	limit  int
+	offset int
+	// conditions selecting the rows after the cursor
+	after     string
+	afterArgs []any
+	sort      string
+	// cursor pagination skips counting the total
+	keyset bool
+	// relations to include with each item
+	include []CrudRelation
+	// the fields which are filtered by
+	filters []field
+	// the full-text search, the order by relevance and if snippets are included
+	search    string
+	rank      string
+	rankArgs  []any
+	highlight bool
+}
+
+// Standard LIST route for a CRUD controller. Lists are always paginated using limit and either offset or cursor. Offset
+// pages include the total number of items, which isn't counted when a cursor is given, even an empty one. The
+// fields in FilterFields can be filtered by equality (name=x), range (count.gte=2), membership (id.in=1,2) and LIKE
+// patterns (name.like=a%). The fields in SortFields can be sorted by using sort=name,-count, where - sorts in
+// descending order. q=words searches the SearchFields and ranks the matches by relevance unless a sort is given, and
+// highlight=true adds snippets of the matching fields. Soft deleted items are excluded unless IncludeDeleted is set
+// and include_deleted=true is given. The route lists the rows of the parent row if parent isn't nil. fields=id,name
+// limits the fields of each item.
+func (c *crud[T]) listRoute(parent *CrudRelation) Routable {
+	pattern := c.collectionPattern(parent)
+	route := crudRoute[struct{}, Page[T]](http.MethodGet, pattern, nil, func(ctx *gin.Context, req struct{}) (res any, status int, err error) {
+		scope, err := authorize(ctx, c.opts.Policies.List)
+		if err != nil {
+			return
+		}
+		if scope, err = c.readScope(ctx, scope); err != nil {
+			return
+		}
+		q, err := c.parseListQuery(ctx.Request.URL.Query())
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		p, err := c.projection(ctx, ctx.Query(fieldsParam))
+		if err != nil {
+			return
+		}
+		if err = c.checkListPermissions(ctx, q); err != nil {
+			return
+		}
+		q.where, q.args = scope.apply(q.where, q.args)
+		var fk any
+		if parent != nil {
+			if fk, err = c.parentKey(ctx, parent); err != nil {
+				return
+			}
+			f, _ := c.fieldByColumn(parent.ForeignKey.Column)
+			q.where, q.args = append(q.where, f.column+" = ?"), append(q.args, fk)
+		}
+		err = transaction(ctx, c.db, func(tx *sqlx.Tx) (err error) {
+			if parent != nil {
+				if err = c.checkParent(ctx, tx, parent, fk); err != nil {
+					return
+				}
+			}
+			res, err = c.list(ctx, tx, q, p)
+			return
+		})
+		return res, http.StatusOK, err
+	})
+	route.route.params = c.listParams()
+	if parent != nil {
+		f, _ := c.fieldByColumn(parent.ForeignKey.Column)
+		route.route.params = append(route.route.params, RequestField{Name: "Id", Key: "id", Source: SourcePath, Type: f.typ,
+			Description: fmt.Sprintf("The %s the items belong to", parent.parentPath())})
+	}
+	// nested lists aren't cached since writes through the top level routes don't know which of them to invalidate
+	if c.opts.Cache != nil && parent == nil {
+		route.Cache(*c.opts.Cache)
+	}
+	return route
+}
+
+func (c *crud[T]) list(ctx *gin.Context, tx *sqlx.Tx, q listQuery, p *projection[T]) (page Page[any], err error) {
+	sql, args := c.selectQuery(q, p)
+	// one extra row is read to know if there is a next page
+	sql += fmt.Sprintf(" LIMIT %d OFFSET %d", q.limit+1, q.offset)
+	var items []T
+	if err = tx.SelectContext(ctx, &items, c.rebind(sql), args...); err != nil {
+		return
+	}
+	if len(items) > q.limit {
+		items = items[:q.limit]
+		if page.NextCursor, err = c.cursor(q, items[len(items)-1]); err != nil {
+			return
+		}
+	}
+	for i := range items {
+		if err = afterFind(ctx, tx, &items[i]); err != nil {
+			return
+		}
+	}
+	if page.Items, err = c.presentIncluding(ctx, tx, p, items, q.include); err != nil {
+		return
+	}
+	if q.highlight {
+		var snippets map[string]map[string]string
+		if snippets, err = c.snippets(ctx, tx, q, items); err != nil {
+			return
+		}
+		page.Items = c.withSnippets(page.Items, items, snippets)
+	}
+	if !q.keyset {
+		var total int64
+		count := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", c.table(), whereClause(q.where))
+		if err = tx.GetContext(ctx, &total, c.rebind(count), q.args...); err != nil {
+			return
+		}
+		page.Total = &total
+	}
+	return
+}
+
+// Build the SELECT of the rows a list request matches, in order and without pagination
+func (c *crud[T]) selectQuery(q listQuery, p *projection[T]) (string, []any) {
+	where := q.where
+	args := q.args
+	if q.after != "" {
+		where = append(append([]string{}, where...), q.after)
+		args = append(append([]any{}, args...), q.afterArgs...)
+	}
+	var order []string
+	if q.rank != "" {
+		order = append(order, q.rank)
+		args = append(append([]any{}, args...), q.rankArgs...)
+	}
+	for _, s := range q.order {
+		if s.desc {
+			order = append(order, s.field.column+" DESC")
+		} else {
+			order = append(order, s.field.column+" ASC")
+		}
+	}
+	// the cursor is made of the sorted fields
+	extra := foreignKeys(c, q.include)
+	for _, s := range q.order {
+		extra = append(extra, s.field)
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s", strings.Join(c.selectColumns(p, extra...), ","), c.table(),
+		whereClause(where), strings.Join(order, ", "))
+	return sql, args
+}
+
+// Parse the pagination, sort and filter parameters of a list request
+func (c *crud[T]) parseListQuery(values url.Values) (q listQuery, err error) {
+	q.limit = c.opts.PageSize
+	if q.limit <= 0 {
+		q.limit = DefaultPageSize
+	}
+	maxLimit := c.opts.MaxPageSize
+	if maxLimit <= 0 {
+		maxLimit = DefaultMaxPageSize
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit < 1 {
+			return q, NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		if q.limit > maxLimit {
+			q.limit = maxLimit
+		}
+	}
+	if v := values.Get("offset"); v != "" {
+		if q.offset, err = strconv.Atoi(v); err != nil || q.offset < 0 {
+			return q, NewHTTPError(http.StatusBadRequest, "offset must be a non-negative integer")
+		}
+	}
+	if err = c.parseSort(&q, values.Get("sort")); err != nil {
+		return
+	}
+	if err = c.parseFilters(&q, values); err != nil {
+		return
+	}
+	if q.include, err = c.parseIncludes(values.Get(includeParam)); err != nil {
+		return
+	}
+	if err = c.parseSearch(&q, values); err != nil {
+		return
+	}
+	// an empty cursor requests the first page without counting the total
+	if q.keyset = values.Has("cursor"); q.keyset {
+		if q.offset > 0 {
+			return q, NewHTTPError(http.StatusBadRequest, "offset can't be used with a cursor")
+		}
+		// the relevance of a row isn't part of the cursor
+		if q.rank != "" {
+			return q, NewHTTPError(http.StatusBadRequest, "a cursor can't be used with q unless a sort is given")
+		}
+		if cursor := values.Get("cursor"); cursor != "" {
+			err = c.parseCursor(&q, cursor)
+		}
+	}
+	return
+}
+
+// Only fields the request can read on every row can be filtered, sorted by and searched
+func (c *crud[T]) checkListPermissions(ctx *gin.Context, q listQuery) error {
+	for _, f := range q.filters {
+		if !c.readable(ctx, f) {
+			return NewHTTPError(http.StatusBadRequest, fmt.Sprintf("can't filter by %s", f.jsonName))
+		}
+	}
+	for _, s := range q.order {
+		if !c.readable(ctx, s.field) {
+			return NewHTTPError(http.StatusBadRequest, fmt.Sprintf("can't sort by %s", s.field.jsonName))
+		}
+	}
+	if q.search != "" {
+		for _, name := range c.opts.SearchFields {
+			if f, _ := c.fieldByJson(name); !c.readable(ctx, f) {
+				return NewHTTPError(http.StatusBadRequest, fmt.Sprintf("can't search %s", name))
+			}
+		}
+	}
+	return nil
+}
+
+// Parse a sort like name,-count. The id is always the last sort column so the order is stable.
+func (c *crud[T]) parseSort(q *listQuery, spec string) error {
+	key := c.key()
+	seen := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		f, ok := c.fieldByJson(name)
+		if !ok || !(zstrings.Contains(c.opts.SortFields, name) || f.dbName == key.dbName) {
+			return NewHTTPError(http.StatusBadRequest, fmt.Sprintf("can't sort by %s", name))
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		q.order = append(q.order, sortColumn{field: f, desc: desc})
+	}
+	if !seen[key.jsonName] {
+		q.order = append(q.order, sortColumn{field: key})
+	}
+	parts := make([]string, len(q.order))
+	for i, s := range q.order {
+		parts[i] = s.field.jsonName
+		if s.desc {
+			parts[i] = "-" + parts[i]
+		}
+	}
+	q.sort = strings.Join(parts, ",")
+	return nil
+}
+
+// Parameters which are neither fields nor use an operator are ignored so cache busting and tracking parameters, like
+// _=123, can be added to any list request.
+func (c *crud[T]) parseFilters(q *listQuery, values url.Values) error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if !zstrings.Contains(listQueryParams, k) {
+			keys = append(keys, k)
+		}
+	}
+	// keep the order of the conditions stable so the same request always produces the same SQL
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, op := key, "="
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			if sqlOp, ok := filterOperators[key[i+1:]]; ok {
+				name, op = key[:i], sqlOp
+			}
+		}
+		f, ok := c.fieldByJson(name)
+		if !ok && op == "=" {
+			continue
+		}
+		if !ok || !zstrings.Contains(c.opts.FilterFields, name) {
+			return NewHTTPError(http.StatusBadRequest, fmt.Sprintf("can't filter by %s", name))
+		}
+		q.filters = append(q.filters, f)
+		for _, raw := range values[key] {
+			if op == "IN" {
+				parts := strings.Split(raw, ",")
+				placeholders := make([]string, len(parts))
+				for i, part := range parts {
+					v, err := filterValue(f, part)
+					if err != nil {
+						return err
+					}
+					placeholders[i] = "?"
+					q.args = append(q.args, v)
+				}
+				q.where = append(q.where, fmt.Sprintf("%s IN (%s)", f.column, strings.Join(placeholders, ",")))
+				continue
+			}
+			var v any = raw
+			if op != "LIKE" {
+				var err error
+				if v, err = filterValue(f, raw); err != nil {
+					return err
+				}
+			}
+			q.where = append(q.where, fmt.Sprintf("%s %s ?", f.column, op))
+			q.args = append(q.args, v)
+		}
+	}
+	return nil
+}
+
+// Check if a column read into the type can hold NULL, like pointers and sql.NullString. Unlike field.nullable, other
+// types implementing driver.Valuer aren't included since they usually can't be scanned from NULL.
+func holdsNull(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		return true
+	}
+	if t.Kind() == reflect.Struct {
+		valid, ok := t.FieldByName("Valid")
+		return ok && valid.Type.Kind() == reflect.Bool
+	}
+	return false
+}
+
+// Convert a query parameter to the type of the field it filters
+func filterValue(f field, raw string) (v any, err error) {
+	t := f.typ
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err = strconv.ParseInt(raw, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err = strconv.ParseUint(raw, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		v, err = strconv.ParseFloat(raw, 64)
+	case reflect.Bool:
+		v, err = strconv.ParseBool(raw)
+	default:
+		v = raw
+	}
+	if err != nil {
+		return nil, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid value %q for %s", raw, f.jsonName))
+	}
+	return
+}
+
+// The values of the sort columns of the last row of a page. The sort is included so a cursor can't be used with a
+// different order.
+type listCursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+func (c *crud[T]) cursor(q listQuery, last T) (string, error) {
+	cursor := listCursor{Sort: q.sort}
+	v := reflect.ValueOf(last)
+	for _, s := range q.order {
+		value, err := json.Marshal(v.FieldByIndex(s.field.index).Interface())
+		if err != nil {
+			return "", err
+		}
+		cursor.Values = append(cursor.Values, value)
+	}
+	data, err := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data), err
+}
+
+// Select the rows after the cursor. Each sort column can have its own direction so the condition expands to
+// (a > ?) OR (a = ? AND b < ?) OR ...
+func (c *crud[T]) parseCursor(q *listQuery, raw string) error {
+	invalid := NewHTTPError(http.StatusBadRequest, "invalid cursor")
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return invalid
+	}
+	var cursor listCursor
+	if err = json.Unmarshal(data, &cursor); err != nil || len(cursor.Values) != len(q.order) {
+		return invalid
+	}
+	if cursor.Sort != q.sort {
+		return NewHTTPError(http.StatusBadRequest, "the cursor was created with a different sort")
+	}
+	values := make([]any, len(q.order))
+	for i, s := range q.order {
+		v := reflect.New(s.field.typ)
+		if err = json.Unmarshal(cursor.Values[i], v.Interface()); err != nil {
+			return invalid
+		}
+		values[i] = v.Elem().Interface()
+	}
+	var or []string
+	for i, s := range q.order {
+		var and []string
+		for j := 0; j < i; j++ {
+			and = append(and, q.order[j].field.column+" = ?")
+			q.afterArgs = append(q.afterArgs, values[j])
+		}
+		op := ">"
+		if s.desc {
+			op = "<"
+		}
+		and = append(and, fmt.Sprintf("%s %s ?", s.field.column, op))
+		q.afterArgs = append(q.afterArgs, values[i])
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+	q.after = "(" + strings.Join(or, " OR ") + ")"
+	return nil
+}
+
+// Describe the query parameters shared by the GET and LIST routes for generated docs
+func (c *crud[T]) readParams() []RequestField {
+	stringType := reflect.TypeOf("")
+	var visible []string
+	for _, f := range c.fields {
+		if f.visible {
+			visible = append(visible, f.jsonName)
+		}
+	}
+	params := []RequestField{{Name: "Fields", Key: fieldsParam, Source: SourceQuery, Type: stringType,
+		Description: fmt.Sprintf("Comma separated fields to include in the response. Any of %s", strings.Join(visible, ", "))}}
+	if c.opts.IncludeDeleted && c.opts.DeletedAtColumn != "" {
+		params = append(params, RequestField{Name: "IncludeDeleted", Key: includeDeletedParam, Source: SourceQuery,
+			Type: reflect.TypeOf(false), Description: "Include soft deleted items"})
+	}
+	var includes []string
+	for _, rel := range c.opts.Relations {
+		if rel.Parent != nil {
+			includes = append(includes, rel.Name)
+		}
+	}
+	if len(includes) > 0 {
+		params = append(params, RequestField{Name: "Include", Key: includeParam, Source: SourceQuery, Type: stringType,
+			Description: fmt.Sprintf("Comma separated relations to include with each item. One of %s", strings.Join(includes, ", "))})
+	}
+	return params
+}
+
+// Describe the query parameters of the list route for generated docs
+func (c *crud[T]) listParams() []RequestField {
+	intType := reflect.TypeOf(0)
+	stringType := reflect.TypeOf("")
+	params := []RequestField{
+		{Name: "Limit", Key: "limit", Source: SourceQuery, Type: intType, Description: "The maximum number of items to return"},
+		{Name: "Offset", Key: "offset", Source: SourceQuery, Type: intType, Description: "The number of items to skip"},
+		{Name: "Cursor", Key: "cursor", Source: SourceQuery, Type: stringType, Description: "The nextCursor of the previous page"},
+	}
+	params = append(params, c.readParams()...)
+	params = append(params, c.searchParams()...)
+	if len(c.opts.SortFields) > 0 {
+		params = append(params, RequestField{Name: "Sort", Key: "sort", Source: SourceQuery, Type: stringType,
+			Description: fmt.Sprintf("Comma separated fields to sort by, prefixed with - for descending order. One of %s", strings.Join(c.opts.SortFields, ", "))})
+	}
+	for _, name := range c.opts.FilterFields {
+		f, ok := c.fieldByJson(name)
+		if !ok {
+			continue
+		}
+		params = append(params, RequestField{Name: f.name, Key: name, Source: SourceQuery, Type: f.typ, Description: "Equal to"})
+		ops := make([]string, 0, len(filterOperators))
+		for op := range filterOperators {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for _, op := range ops {
+			t, description := f.typ, fmt.Sprintf("Compared using %s", filterOperators[op])
+			switch op {
+			case "in":
+				t, description = stringType, "Comma separated values to match"
+			case "like":
+				t, description = stringType, "Pattern to match using % and _ as wildcards"
+			}
+			params = append(params, RequestField{Name: f.name, Key: name + "." + op, Source: SourceQuery, Type: t, Description: description})
+		}
+	}
+	return params
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
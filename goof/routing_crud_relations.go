@@ -0,0 +1,246 @@
+package goof
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/wyattis/goof/schema"
+)
+
+// The query parameter listing the relations to include in GET and LIST responses
+const includeParam = "include"
+
+// A foreign key from the table of a CRUD controller to another table
+type CrudRelation struct {
+	// The name clients use with ?include=. Defaults to the column without its _id suffix, like user for user_id.
+	Name string
+	// The foreign key, usually read from the migrations using migrate.ForeignKeys
+	ForeignKey schema.ForeignKey
+	// Add the LIST and CREATE routes below the referenced row, like /user/:id/comment. Creates fill in the foreign key
+	// from the path. Nested lists aren't cached.
+	Nested bool
+	// The controller of the referenced table. Included rows only contain its visible fields and, like the parents of
+	// nested routes, must be allowed by its Get policy. Relations without a parent can't be included.
+	Parent CrudResource
+}
+
+// A CRUD controller which can be the parent of a relation
+type CrudResource interface {
+	Routable
+	// Load the rows where column is one of values, keyed by the formatted value of column
+	related(ctx *gin.Context, tx *sqlx.Tx, column string, values []any) (map[string]any, error)
+	pathName() string
+}
+
+func (c *crud[T]) pathName() string {
+	return c.opts.PathName
+}
+
+// Load the visible fields of the rows where column is one of values within the Get policy
+func (c *crud[T]) related(ctx *gin.Context, tx *sqlx.Tx, column string, values []any) (rows map[string]any, err error) {
+	rows = map[string]any{}
+	if len(values) == 0 {
+		return
+	}
+	scope, err := authorize(ctx, c.opts.Policies.Get)
+	if err != nil {
+		return
+	}
+	f, ok := c.fieldByColumn(column)
+	if !ok {
+		return nil, fmt.Errorf("table %s has no %s column", c.opts.Table, column)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+	conds, args := c.live(scope).apply([]string{fmt.Sprintf("%s IN (%s)", f.column, placeholders)}, values)
+	q := fmt.Sprintf("SELECT %s FROM %s%s", strings.Join(c.columns(), ","), c.table(), whereClause(conds))
+	var items []T
+	if err = tx.SelectContext(ctx, &items, c.rebind(q), args...); err != nil {
+		return
+	}
+	p, err := c.projection(ctx, "")
+	if err != nil {
+		return
+	}
+	for i := range items {
+		if err = afterFind(ctx, tx, &items[i]); err != nil {
+			return
+		}
+		rows[fmt.Sprint(reflect.Indirect(reflect.ValueOf(items[i]).FieldByIndex(f.index)).Interface())] = p.present(ctx, items[i]).Interface()
+	}
+	return
+}
+
+// The path of the referenced table, which nested routes are added below
+func (r CrudRelation) parentPath() string {
+	if r.Parent != nil {
+		return r.Parent.pathName()
+	}
+	return strings.ReplaceAll(r.ForeignKey.RefTable, "_", "-")
+}
+
+// The pattern of the collection routes, which are nested below the parent row if parent isn't nil
+func (c *crud[T]) collectionPattern(parent *CrudRelation) string {
+	if parent == nil {
+		return "/" + c.opts.PathName
+	}
+	return fmt.Sprintf("/%s/:id/%s", parent.parentPath(), c.opts.PathName)
+}
+
+// Get the value of the foreign key from the path of a nested route
+func (c *crud[T]) parentKey(ctx *gin.Context, rel *CrudRelation) (any, error) {
+	f, _ := c.fieldByColumn(rel.ForeignKey.Column)
+	v, err := filterValue(f, ctx.Param("id"))
+	if err != nil {
+		return nil, parentNotFound(ctx, rel)
+	}
+	return v, nil
+}
+
+// Check the parent row of a nested route exists
+func (c *crud[T]) checkParent(ctx *gin.Context, tx *sqlx.Tx, rel *CrudRelation, key any) error {
+	if rel.Parent != nil {
+		rows, err := rel.Parent.related(ctx, tx, rel.ForeignKey.RefColumn, []any{key})
+		if err != nil {
+			return err
+		} else if len(rows) == 0 {
+			return parentNotFound(ctx, rel)
+		}
+		return nil
+	}
+	var exists int
+	q := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ?", c.opts.Driver.Quote(rel.ForeignKey.RefTable), c.opts.Driver.Quote(rel.ForeignKey.RefColumn))
+	err := tx.GetContext(ctx, &exists, c.rebind(q), key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return parentNotFound(ctx, rel)
+	}
+	return err
+}
+
+func parentNotFound(ctx *gin.Context, rel *CrudRelation) error {
+	return &HTTPError{Status: http.StatusNotFound, Detail: fmt.Sprintf("%s %s was not found", rel.parentPath(), ctx.Param("id")), Err: sql.ErrNoRows}
+}
+
+// Parse the comma separated names of the relations to include
+func (c *crud[T]) parseIncludes(raw string) (rels []CrudRelation, err error) {
+	if raw == "" {
+		return
+	}
+	for _, name := range strings.Split(raw, ",") {
+		rel, ok := c.relation(name)
+		if !ok || rel.Parent == nil {
+			return nil, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s can't be included", name))
+		}
+		rels = append(rels, rel)
+	}
+	return
+}
+
+func (c *crud[T]) relation(name string) (CrudRelation, bool) {
+	for _, rel := range c.opts.Relations {
+		if rel.Name == name {
+			return rel, true
+		}
+	}
+	return CrudRelation{}, false
+}
+
+// The foreign key fields of relations
+func foreignKeys[T any](c *crud[T], rels []CrudRelation) (fields []field) {
+	for _, rel := range rels {
+		f, _ := c.fieldByColumn(rel.ForeignKey.Column)
+		fields = append(fields, f)
+	}
+	return
+}
+
+// Render the fields of items in the projection along with their included rows. The rows of each relation are loaded
+// using one query.
+func (c *crud[T]) presentIncluding(ctx *gin.Context, tx *sqlx.Tx, p *projection[T], items []T, rels []CrudRelation) ([]any, error) {
+	res := make([]any, len(items))
+	if len(rels) == 0 {
+		for i, item := range items {
+			res[i] = p.present(ctx, item).Interface()
+		}
+		return res, nil
+	}
+
+	viewFields := make([]reflect.StructField, 0, p.view.NumField()+len(rels))
+	for i := 0; i < p.view.NumField(); i++ {
+		viewFields = append(viewFields, p.view.Field(i))
+	}
+	related := make([]map[string]any, len(rels))
+	keys := make([]field, len(rels))
+	for i, rel := range rels {
+		tag := fmt.Sprintf(`json:"%[1]s" xml:"%[1]s" yaml:"%[1]s" msgpack:"%[1]s"`, rel.Name)
+		viewFields = append(viewFields, reflect.StructField{Name: fmt.Sprintf("Include%d", i), Type: reflect.TypeOf((*any)(nil)).Elem(), Tag: reflect.StructTag(tag)})
+
+		keys[i], _ = c.fieldByColumn(rel.ForeignKey.Column)
+		var values []any
+		seen := map[string]bool{}
+		for _, item := range items {
+			v := reflect.ValueOf(item).FieldByIndex(keys[i].index)
+			// rows without a related row are rendered with null
+			if v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					continue
+				}
+				v = v.Elem()
+			}
+			if k := fmt.Sprint(v.Interface()); !seen[k] {
+				seen[k] = true
+				values = append(values, v.Interface())
+			}
+		}
+		rows, err := rel.Parent.related(ctx, tx, rel.ForeignKey.RefColumn, values)
+		if err != nil {
+			return nil, err
+		}
+		related[i] = rows
+	}
+
+	view := reflect.StructOf(viewFields)
+	for i, item := range items {
+		v := reflect.New(view).Elem()
+		presented := p.present(ctx, item)
+		for j := 0; j < presented.NumField(); j++ {
+			v.Field(j).Set(presented.Field(j))
+		}
+		for j := range rels {
+			key := reflect.ValueOf(item).FieldByIndex(keys[j].index)
+			if key.Kind() == reflect.Ptr && key.IsNil() {
+				continue
+			}
+			if row, ok := related[j][fmt.Sprint(reflect.Indirect(key).Interface())]; ok {
+				v.Field(presented.NumField() + j).Set(reflect.ValueOf(row))
+			}
+		}
+		res[i] = v.Interface()
+	}
+	return res, nil
+}
+
+// Set a field to a value parsed by filterValue
+func setFieldValue(v reflect.Value, value any) error {
+	t := v.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	rv := reflect.ValueOf(value)
+	if !rv.CanConvert(t) {
+		return fmt.Errorf("can't set a %s field to %v", v.Type(), value)
+	}
+	rv = rv.Convert(t)
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(t)
+		p.Elem().Set(rv)
+		rv = p
+	}
+	v.Set(rv)
+	return nil
+}
@@ -0,0 +1,210 @@
+package goof
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/wyattis/goof/schema"
+	"github.com/wyattis/goof/sql/driver"
+)
+
+// The query parameters of full-text search
+const (
+	searchParam    = "q"
+	highlightParam = "highlight"
+)
+
+// The tags around matches in snippets
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+)
+
+// Marks matches in the snippets returned by the database so the text can be escaped before the tags are added
+const (
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+// Replaces the markers of a snippet with tags once the text has been escaped
+var snippetTags = strings.NewReplacer(snippetStart, highlightStart, snippetStop, highlightStop)
+
+// The text search configuration used on Postgres when SearchLanguage isn't set
+const DefaultSearchLanguage = "english"
+
+// Parse the full-text search of a list request. Matches are ranked by relevance unless a sort is given.
+func (c *crud[T]) parseSearch(q *listQuery, values url.Values) (err error) {
+	terms := strings.TrimSpace(values.Get(searchParam))
+	if terms == "" {
+		return
+	}
+	if len(c.opts.SearchFields) == 0 {
+		return NewHTTPError(http.StatusBadRequest, "this list can't be searched")
+	}
+	if raw := values.Get(highlightParam); raw != "" {
+		if q.highlight, err = strconv.ParseBool(raw); err != nil {
+			return NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be true or false", highlightParam))
+		}
+	}
+	q.search = terms
+	key := c.key()
+	switch c.opts.Driver {
+	case driver.TypePostgres:
+		language := c.searchLanguage()
+		query := fmt.Sprintf("%s @@ plainto_tsquery(?, ?)", c.opts.Driver.Quote(schema.SearchColumn))
+		q.where, q.args = append(q.where, query), append(q.args, language, terms)
+		if values.Get("sort") == "" {
+			q.rank = fmt.Sprintf("ts_rank(%s, plainto_tsquery(?, ?)) DESC", c.opts.Driver.Quote(schema.SearchColumn))
+			q.rankArgs = []any{language, terms}
+		}
+	case driver.TypeSqlite3:
+		index := c.searchTable()
+		match := matchQuery(terms)
+		q.where = append(q.where, fmt.Sprintf("%s IN (SELECT rowid FROM %s WHERE %[2]s MATCH ?)", key.column, index))
+		q.args = append(q.args, match)
+		if values.Get("sort") == "" {
+			// lower bm25 scores are better matches
+			q.rank = fmt.Sprintf("(SELECT bm25(%[1]s) FROM %[1]s WHERE %[1]s MATCH ? AND rowid = %s.%s) ASC", index, c.table(), key.column)
+			q.rankArgs = []any{match}
+		}
+	default:
+		return WrapHTTPError(http.StatusInternalServerError, fmt.Errorf("full-text search isn't supported by %s", c.opts.Driver))
+	}
+	return
+}
+
+// Quote each term so that FTS5 operators in the search are matched as text. Every term must match.
+func matchQuery(terms string) string {
+	words := strings.Fields(terms)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// Get highlighted snippets of the search fields which match, by id and then JSON name. Snippets are safe HTML since
+// the text is escaped and only the tags around matches are added.
+func (c *crud[T]) snippets(ctx *gin.Context, tx *sqlx.Tx, q listQuery, items []T) (map[string]map[string]string, error) {
+	res := map[string]map[string]string{}
+	if len(items) == 0 {
+		return res, nil
+	}
+	key := c.key()
+	ids := make([]any, len(items))
+	placeholders := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = reflect.ValueOf(item).FieldByIndex(key.index).Interface()
+		placeholders[i] = "?"
+	}
+	exprs := make([]string, len(c.opts.SearchFields))
+	var query string
+	var args []any
+	switch c.opts.Driver {
+	case driver.TypePostgres:
+		options := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=16, MinWords=8", snippetStart, snippetStop)
+		for i, name := range c.opts.SearchFields {
+			f, _ := c.fieldByJson(name)
+			exprs[i] = fmt.Sprintf("ts_headline(?, coalesce(%s, ''), plainto_tsquery(?, ?), ?)", f.column)
+			args = append(args, c.searchLanguage(), c.searchLanguage(), q.search, options)
+		}
+		query = fmt.Sprintf("SELECT %s, %s FROM %s WHERE %[1]s IN (%s)", key.column, strings.Join(exprs, ", "), c.table(),
+			strings.Join(placeholders, ","))
+		args = append(args, ids...)
+	default:
+		index := c.searchTable()
+		for i := range c.opts.SearchFields {
+			exprs[i] = fmt.Sprintf("snippet(%s, %d, '%s', '%s', '…', 16)", index, i, snippetStart, snippetStop)
+		}
+		query = fmt.Sprintf("SELECT rowid, %s FROM %s WHERE %[2]s MATCH ? AND rowid IN (%s)", strings.Join(exprs, ", "), index,
+			strings.Join(placeholders, ","))
+		args = append([]any{matchQuery(q.search)}, ids...)
+	}
+	rows, err := tx.QueryContext(ctx, c.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id any
+		values := make([]sql.NullString, len(exprs))
+		dest := []any{&id}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		// fields without a match are rendered from their start so they are left out
+		matched := map[string]string{}
+		for i, v := range values {
+			if strings.Contains(v.String, snippetStart) {
+				matched[c.opts.SearchFields[i]] = snippetTags.Replace(html.EscapeString(v.String))
+			}
+		}
+		res[fmt.Sprint(id)] = matched
+	}
+	return res, rows.Err()
+}
+
+// Add the snippets of each item to the presented items as "snippets"
+func (c *crud[T]) withSnippets(presented []any, items []T, snippets map[string]map[string]string) []any {
+	if len(presented) == 0 {
+		return presented
+	}
+	view := reflect.TypeOf(presented[0])
+	viewFields := make([]reflect.StructField, 0, view.NumField()+1)
+	for i := 0; i < view.NumField(); i++ {
+		viewFields = append(viewFields, view.Field(i))
+	}
+	tag := `json:"snippets,omitempty" xml:"snippets,omitempty" yaml:"snippets,omitempty" msgpack:"snippets,omitempty"`
+	viewFields = append(viewFields, reflect.StructField{Name: "Snippets", Type: reflect.TypeOf(map[string]string{}), Tag: reflect.StructTag(tag)})
+	withSnippets := reflect.StructOf(viewFields)
+	key := c.key()
+	res := make([]any, len(presented))
+	for i, p := range presented {
+		v := reflect.New(withSnippets).Elem()
+		pv := reflect.ValueOf(p)
+		for j := 0; j < pv.NumField(); j++ {
+			v.Field(j).Set(pv.Field(j))
+		}
+		id := fmt.Sprint(reflect.ValueOf(items[i]).FieldByIndex(key.index).Interface())
+		if s := snippets[id]; len(s) > 0 {
+			v.Field(pv.NumField()).Set(reflect.ValueOf(s))
+		}
+		res[i] = v.Interface()
+	}
+	return res
+}
+
+func (c *crud[T]) searchTable() string {
+	return c.opts.Driver.Quote(schema.SearchTable(c.opts.Table))
+}
+
+func (c *crud[T]) searchLanguage() string {
+	if c.opts.SearchLanguage == "" {
+		return DefaultSearchLanguage
+	}
+	return c.opts.SearchLanguage
+}
+
+// Describe the search query parameters for generated docs
+func (c *crud[T]) searchParams() []RequestField {
+	if len(c.opts.SearchFields) == 0 {
+		return nil
+	}
+	return []RequestField{
+		{Name: "Q", Key: searchParam, Source: SourceQuery, Type: reflect.TypeOf(""),
+			Description: fmt.Sprintf("Words to search %s for. Matches are ranked by relevance unless sort is given", strings.Join(c.opts.SearchFields, ", "))},
+		{Name: "Highlight", Key: highlightParam, Source: SourceQuery, Type: reflect.TypeOf(false),
+			Description: fmt.Sprintf("Include snippets of the matching fields with matches wrapped in %s", highlightStart)},
+	}
+}
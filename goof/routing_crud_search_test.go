@@ -0,0 +1,105 @@
+//go:build sqlite_fts5
+
+package goof
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/wyattis/goof/schema"
+	"github.com/wyattis/goof/sql/driver"
+)
+
+// SQLite only includes FTS5 when built with the sqlite_fts5 tag so these tests run with go test -tags sqlite_fts5
+
+type crudArticle struct {
+	Id    int64  `json:"id" db:"id"`
+	Title string `json:"title" db:"title"`
+	Body  string `json:"body" db:"body"`
+}
+
+func articleEngine(t *testing.T) (*gin.Engine, *sqlx.DB) {
+	db := crudDB(t)
+	db.MustExec("CREATE TABLE crud_article (id INTEGER PRIMARY KEY, title TEXT NOT NULL, body TEXT NOT NULL)")
+	// rows written before the index exists are indexed too
+	db.MustExec("INSERT INTO crud_article (title, body) VALUES ('Gardening', 'Growing tomatoes in pots'), ('Cooking', 'A tomato soup recipe')")
+	s := schema.New(driver.TypeSqlite3, "")
+	s.Table("crud_article", func(t *schema.Table) {
+		t.Search("title", "body")
+	})
+	for _, statement := range s.Schema.Statements() {
+		db.MustExec(statement)
+	}
+	c := CRUD(db, crudArticle{}, &CrudOpts{
+		Get: true, List: true, Create: true, Update: true, Delete: true,
+		SortFields:   []string{"title"},
+		SearchFields: []string{"title", "body"},
+	})
+	return problemEngine(false, c), db
+}
+
+type articlePage struct {
+	Items []struct {
+		crudArticle
+		Snippets map[string]string `json:"snippets"`
+	} `json:"items"`
+	Total int `json:"total"`
+}
+
+func searchArticles(t *testing.T, r *gin.Engine, query string) (page articlePage, code int) {
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/crud-article?"+query, nil))
+	json.Unmarshal(res.Body.Bytes(), &page)
+	return page, res.Code
+}
+
+func TestCrudSearch(t *testing.T) {
+	r, _ := articleEngine(t)
+	doCrud(r, http.MethodPost, "/crud-article", `{"title":"Tomato tips","body":"Tomatoes need <b>sun</b>"}`)
+
+	page, code := searchArticles(t, r, "q=tomatoes")
+	if code != http.StatusOK || len(page.Items) != 3 || page.Total != 3 {
+		t.Fatalf("expected 3 matches; got %d %+v", code, page)
+	}
+	if page.Items[0].Title != "Tomato tips" {
+		t.Errorf("expected the article matching the title and body first; got %+v", page.Items)
+	}
+	if page, _ = searchArticles(t, r, "q=tomato+soup"); len(page.Items) != 1 || page.Items[0].Title != "Cooking" {
+		t.Errorf("expected every word to match; got %+v", page.Items)
+	}
+	if page, _ = searchArticles(t, r, "q=tomato&sort=title"); len(page.Items) != 3 || page.Items[0].Title != "Cooking" {
+		t.Errorf("expected the sort to replace the ranking; got %+v", page.Items)
+	}
+
+	doCrud(r, http.MethodPatch, "/crud-article/1", `{"body":"Growing peppers"}`)
+	doCrud(r, http.MethodDelete, "/crud-article/2", "")
+	if page, _ = searchArticles(t, r, "q=tomato"); len(page.Items) != 1 || page.Items[0].Id != 3 {
+		t.Errorf("expected the index to follow updates and deletes; got %+v", page.Items)
+	}
+	if page, _ = searchArticles(t, r, "q=peppers"); len(page.Items) != 1 || page.Items[0].Id != 1 {
+		t.Errorf("expected the updated article; got %+v", page.Items)
+	}
+
+	page, _ = searchArticles(t, r, "q=sun&highlight=true")
+	if len(page.Items) != 1 || page.Items[0].Snippets["body"] != "Tomatoes need &lt;b&gt;<mark>sun</mark>&lt;/b&gt;" {
+		t.Errorf("expected an escaped snippet of the body; got %+v", page.Items)
+	}
+	if _, ok := page.Items[0].Snippets["title"]; ok {
+		t.Errorf("expected fields without a match to be left out; got %+v", page.Items[0].Snippets)
+	}
+
+	// FTS5 syntax is matched as text
+	if _, code = searchArticles(t, r, `q="tomato+OR+NEAR(`); code != http.StatusOK {
+		t.Errorf("expected operators to be quoted; got %d", code)
+	}
+	for _, query := range []string{"q=tomato&cursor=", "q=tomato&highlight=maybe"} {
+		if _, code = searchArticles(t, r, query); code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected; got %d", query, code)
+		}
+	}
+}
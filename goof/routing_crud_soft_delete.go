@@ -0,0 +1,122 @@
+package goof
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/wyattis/goof/schema"
+)
+
+// The query parameter which includes soft deleted rows when IncludeDeleted is set
+const includeDeletedParam = "include_deleted"
+
+// Standard restore route for a CRUD controller with soft deletes. Restoring a row which isn't deleted leaves it as is.
+// With a VersionColumn the row's version is required as If-Match, like a delete, and restoring changes it.
+func (c *crud[T]) restoreRoute() Routable {
+	pattern := fmt.Sprintf("/%s/:id/restore", c.opts.PathName)
+	return crudRoute[crudIdRequest, T](http.MethodPost, pattern, nil, func(ctx *gin.Context, req crudIdRequest) (res any, status int, err error) {
+		scope, err := authorize(ctx, c.opts.Policies.Update)
+		if err != nil {
+			return
+		}
+		id, err := c.parseId(req.Id)
+		if err != nil {
+			return
+		}
+		err = transaction(ctx, c.db, func(tx *sqlx.Tx) error {
+			existing, err := c.find(ctx, tx, id, scope)
+			if err != nil {
+				return err
+			}
+			if err = c.checkVersion(ctx.GetHeader("If-Match"), existing, reflect.Value{}); err != nil {
+				return err
+			}
+			sets := c.opts.Driver.Quote(c.opts.DeletedAtColumn) + " = NULL"
+			if c.opts.UpdatedAtColumn != "" && c.opts.UpdatedAtColumn != c.opts.VersionColumn {
+				sets += ", " + c.opts.Driver.Quote(c.opts.UpdatedAtColumn) + " = " + c.now()
+			}
+			cond, args := c.idCondition(id, scope)
+			// live rows aren't written so their timestamps and version stay the same
+			cond += " AND " + c.opts.Driver.Quote(c.opts.DeletedAtColumn) + " IS NOT NULL"
+			var setArgs []any
+			version, guarded := c.versionField()
+			if guarded {
+				// restoring is a write so it changes the version like an update
+				current := reflect.ValueOf(existing).FieldByIndex(version.index).Interface()
+				set, next := c.nextVersion(version, current)
+				sets += ", " + set
+				setArgs = next
+				versionCond, arg := c.versionCondition(version, current)
+				cond += " AND " + versionCond
+				args = append(args, arg)
+			}
+			q := c.rebind(fmt.Sprintf("UPDATE %s SET %s WHERE %s", c.table(), sets, cond))
+			result, err := tx.ExecContext(ctx, q, append(setArgs, args...)...)
+			if err != nil {
+				return err
+			}
+			restored, err := c.find(ctx, tx, id, scope)
+			if err != nil {
+				return err
+			}
+			// nothing is updated for a live row as well as a stale version so compare the versions to tell them apart
+			if n, err := result.RowsAffected(); err == nil && n == 0 && guarded {
+				before := reflect.ValueOf(existing).FieldByIndex(version.index).Interface()
+				if !reflect.DeepEqual(reflect.ValueOf(restored).FieldByIndex(version.index).Interface(), before) {
+					return c.staleVersion()
+				}
+			}
+			c.setETag(ctx.Writer, restored)
+			res = c.present(ctx, restored)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		c.invalidate(ctx, path.Dir(path.Dir(ctx.Request.URL.Path)))
+		return res, http.StatusOK, nil
+	})
+}
+
+// Limit the scope to rows which haven't been soft deleted
+func (c *crud[T]) live(scope *CrudScope) *CrudScope {
+	if c.opts.DeletedAtColumn == "" {
+		return scope
+	}
+	conds, args := scope.apply(nil, nil)
+	conds = append(conds, c.opts.Driver.Quote(c.opts.DeletedAtColumn)+" IS NULL")
+	return &CrudScope{Where: strings.Join(conds, " AND "), Args: args}
+}
+
+// The scope of GET and LIST requests, which include soft deleted rows if the client asks for them and
+// IncludeDeleted is set
+func (c *crud[T]) readScope(ctx *gin.Context, scope *CrudScope) (*CrudScope, error) {
+	raw, ok := ctx.GetQuery(includeDeletedParam)
+	if !ok || !c.opts.IncludeDeleted {
+		return c.live(scope), nil
+	}
+	include, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be true or false", includeDeletedParam))
+	}
+	if include {
+		return scope, nil
+	}
+	return c.live(scope), nil
+}
+
+// The SQL expression of the current time
+func (c *crud[T]) now() string {
+	return schema.NOW{}.Constant(c.opts.Driver)
+}
+
+// Check if the field is an automatic timestamp which is set to the current time when written
+func (c *crud[T]) timestamped(f field) bool {
+	return f.dbName == c.opts.CreatedAtColumn || f.dbName == c.opts.UpdatedAtColumn
+}
@@ -1,8 +1,28 @@
 package goof
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/wyattis/goof/gtime"
+	"github.com/wyattis/goof/http/middleware"
+	"github.com/wyattis/goof/migrate"
+	"github.com/wyattis/goof/schema"
+	"github.com/wyattis/goof/sql/driver"
 )
 
 type testMode struct {
@@ -40,3 +60,1064 @@ func TestGetTableName(t *testing.T) {
 		t.Errorf("expected %s, got %s", expected, name)
 	}
 }
+
+type crudWidget struct {
+	Id     int64   `json:"id" db:"id"`
+	Name   string  `json:"name" db:"name" binding:"required"`
+	Note   *string `json:"note" db:"note"`
+	Count  int     `json:"count" db:"count"`
+	Secret string  `json:"secret" db:"secret"`
+}
+
+// Run a CRUD test against each database. Postgres is used when GOOF_TEST_POSTGRES is set to a connection string.
+func forEachCrudDB(t *testing.T, test func(t *testing.T, r *gin.Engine, db *sqlx.DB)) {
+	drivers := []driver.Type{driver.TypeSqlite3}
+	if os.Getenv("GOOF_TEST_POSTGRES") != "" {
+		drivers = append(drivers, driver.TypePostgres)
+	}
+	for _, d := range drivers {
+		t.Run(string(d), func(t *testing.T) {
+			r, db := crudEngine(t, d)
+			test(t, r, db)
+		})
+	}
+}
+
+var crudWidgetTables = map[driver.Type]string{
+	driver.TypeSqlite3:  "CREATE TABLE crud_widget (id INTEGER PRIMARY KEY, name TEXT NOT NULL, note TEXT, count INTEGER NOT NULL, secret TEXT NOT NULL DEFAULT '')",
+	driver.TypePostgres: "CREATE TABLE crud_widget (id SERIAL PRIMARY KEY, name TEXT NOT NULL, note TEXT, count INTEGER NOT NULL, secret TEXT NOT NULL DEFAULT '')",
+}
+
+func crudEngine(t *testing.T, d driver.Type) (*gin.Engine, *sqlx.DB) {
+	var db *sqlx.DB
+	var err error
+	if d == driver.TypePostgres {
+		if db, err = sqlx.Open(string(d), os.Getenv("GOOF_TEST_POSTGRES")); err == nil {
+			_, err = db.Exec("DROP TABLE IF EXISTS crud_widget")
+		}
+	} else {
+		db, err = sqlx.Open(string(d), filepath.Join(t.TempDir(), "test.db"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err = db.Exec(crudWidgetTables[d]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO crud_widget (name, note, count, secret) VALUES ('first', 'a note', 1, 'hidden')"); err != nil {
+		t.Fatal(err)
+	}
+	c := CRUD(db, crudWidget{}, &CrudOpts{
+		Get: true, List: true, Create: true, Update: true, Delete: true,
+		VisibleFields:   []string{"id", "name", "note", "count"},
+		UpdatableFields: []string{"name", "note", "count"},
+		FilterFields:    []string{"name", "count"},
+		SortFields:      []string{"name", "count"},
+		PageSize:        2,
+		MaxPageSize:     3,
+		Bulk:            true,
+		MaxBatchSize:    3,
+		Export:          true,
+		Import:          true,
+	})
+	return problemEngine(false, c), db
+}
+
+func doCrud(r *gin.Engine, method, path, body string) (res *httptest.ResponseRecorder, data map[string]any) {
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(res, req)
+	json.Unmarshal(res.Body.Bytes(), &data)
+	return
+}
+
+func TestCrudHidesInvisibleFields(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		res, data := doCrud(r, http.MethodGet, "/crud-widget/1", "")
+		if res.Code != http.StatusOK || data["name"] != "first" {
+			t.Fatalf("expected the widget; got %d %s", res.Code, res.Body.String())
+		}
+		if _, ok := data["secret"]; ok {
+			t.Errorf("expected secret to be hidden; got %s", res.Body.String())
+		}
+		res, _ = doCrud(r, http.MethodGet, "/crud-widget", "")
+		if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "first") || strings.Contains(res.Body.String(), "secret") {
+			t.Errorf("expected secret to be hidden from lists; got %s", res.Body.String())
+		}
+		if res, _ := doCrud(r, http.MethodGet, "/crud-widget/9", ""); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404; got %d", res.Code)
+		}
+	})
+}
+
+func TestCrudCreate(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		res, data := doCrud(r, http.MethodPost, "/crud-widget", `{"name":"second","count":2}`)
+		if res.Code != http.StatusCreated || data["id"] != float64(2) || data["name"] != "second" || data["note"] != nil {
+			t.Fatalf("expected the created widget; got %d %s", res.Code, res.Body.String())
+		}
+		if res, _ := doCrud(r, http.MethodPost, "/crud-widget", `{"name":"third","secret":"x"}`); res.Code != http.StatusBadRequest {
+			t.Errorf("expected writing a field that isn't updatable to fail; got %d", res.Code)
+		}
+		if res, _ := doCrud(r, http.MethodPost, "/crud-widget", `{"count":3}`); res.Code != http.StatusBadRequest {
+			t.Errorf("expected validation to fail; got %d", res.Code)
+		}
+	})
+}
+
+func TestCrudUpdate(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		res, data := doCrud(r, http.MethodPut, "/crud-widget/1", `{"id":1,"name":"renamed"}`)
+		if res.Code != http.StatusOK || data["name"] != "renamed" || data["note"] != nil || data["count"] != float64(0) {
+			t.Errorf("expected every updatable field to be replaced; got %d %s", res.Code, res.Body.String())
+		}
+		var secret string
+		db.Get(&secret, "SELECT secret FROM crud_widget WHERE id = 1")
+		if secret != "hidden" {
+			t.Errorf("expected fields that aren't updatable to be kept; got %q", secret)
+		}
+		if res, _ := doCrud(r, http.MethodPut, "/crud-widget/9", `{"name":"missing"}`); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404; got %d", res.Code)
+		}
+	})
+}
+
+func TestCrudPatch(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		res, data := doCrud(r, http.MethodPatch, "/crud-widget/1", `{"count":5}`)
+		if res.Code != http.StatusOK || data["count"] != float64(5) || data["name"] != "first" || data["note"] != "a note" {
+			t.Errorf("expected only count to change; got %d %s", res.Code, res.Body.String())
+		}
+		res, data = doCrud(r, http.MethodPatch, "/crud-widget/1", `{"note":null}`)
+		if res.Code != http.StatusOK || data["note"] != nil || data["count"] != float64(5) {
+			t.Errorf("expected note to be cleared; got %d %s", res.Code, res.Body.String())
+		}
+		if res, _ := doCrud(r, http.MethodPatch, "/crud-widget/1", `{"count":null}`); res.Code != http.StatusBadRequest {
+			t.Errorf("expected clearing a field that isn't nullable to fail; got %d", res.Code)
+		}
+		if res, _ := doCrud(r, http.MethodPatch, "/crud-widget/1", `{"name":""}`); res.Code != http.StatusBadRequest {
+			t.Errorf("expected the patched row to be validated; got %d", res.Code)
+		}
+		if res, _ := doCrud(r, http.MethodPatch, "/crud-widget/9", `{"count":1}`); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404; got %d", res.Code)
+		}
+	})
+}
+
+func TestCrudDelete(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		if res, _ := doCrud(r, http.MethodDelete, "/crud-widget/1", ""); res.Code != http.StatusNoContent {
+			t.Errorf("expected 204; got %d", res.Code)
+		}
+		if res, _ := doCrud(r, http.MethodDelete, "/crud-widget/1", ""); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404 once deleted; got %d", res.Code)
+		}
+	})
+}
+
+type widgetPage struct {
+	Items      []crudWidget `json:"items"`
+	Total      *int64       `json:"total"`
+	NextCursor string       `json:"nextCursor"`
+}
+
+func listWidgets(t *testing.T, r *gin.Engine, query string) (page widgetPage, code int) {
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/crud-widget?"+query, nil))
+	if res.Code == http.StatusOK {
+		if err := json.Unmarshal(res.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return page, res.Code
+}
+
+func widgetNames(page widgetPage) (names []string) {
+	for _, w := range page.Items {
+		names = append(names, w.Name)
+	}
+	return
+}
+
+func TestCrudListPagination(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		for _, name := range []string{"b", "c", "d", "e"} {
+			db.MustExec(db.Rebind("INSERT INTO crud_widget (name, count) VALUES (?, ?)"), name, len(name))
+		}
+
+		page, _ := listWidgets(t, r, "")
+		if len(page.Items) != 2 || page.Total == nil || *page.Total != 5 || page.NextCursor == "" {
+			t.Errorf("expected the first 2 of 5 widgets; got %+v", page)
+		}
+		page, _ = listWidgets(t, r, "offset=4")
+		if !reflect.DeepEqual(widgetNames(page), []string{"e"}) || page.NextCursor != "" {
+			t.Errorf("expected the last page; got %+v", page)
+		}
+		if page, _ = listWidgets(t, r, "limit=50"); len(page.Items) != 3 {
+			t.Errorf("expected the limit to be capped at 3; got %d items", len(page.Items))
+		}
+
+		var names []string
+		cursor := ""
+		for i := 0; i < 5; i++ {
+			page, code := listWidgets(t, r, "sort=-name&cursor="+cursor)
+			if code != http.StatusOK {
+				t.Fatalf("expected 200; got %d", code)
+			}
+			if page.Total != nil {
+				t.Errorf("expected cursor pages not to be counted")
+			}
+			names = append(names, widgetNames(page)...)
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if !reflect.DeepEqual(names, []string{"first", "e", "d", "c", "b"}) {
+			t.Errorf("expected every widget in descending order; got %v", names)
+		}
+
+		first, _ := listWidgets(t, r, "sort=name")
+		if _, code := listWidgets(t, r, "sort=count&cursor="+first.NextCursor); code != http.StatusBadRequest {
+			t.Errorf("expected a cursor to be rejected with a different sort; got %d", code)
+		}
+		for _, query := range []string{"limit=0", "offset=-1", "sort=secret", "secret=x", "other.gte=1", "cursor=abc"} {
+			if _, code := listWidgets(t, r, query); code != http.StatusBadRequest {
+				t.Errorf("expected %s to be rejected; got %d", query, code)
+			}
+		}
+		if page, code := listWidgets(t, r, "_=123&utm_source=mail"); code != http.StatusOK || len(page.Items) != 2 {
+			t.Errorf("expected unknown parameters to be ignored; got %d %+v", code, page)
+		}
+	})
+}
+
+func TestCrudListFilters(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		db.MustExec("INSERT INTO crud_widget (name, count) VALUES ('second', 2), ('third', 3), ('fourth', 4)")
+		for query, expected := range map[string][]string{
+			"name=third":                {"third"},
+			"count.gte=2&count.lt=4":    {"second", "third"},
+			"count.in=1,4":              {"first", "fourth"},
+			"name.like=f%25&sort=-name": {"fourth", "first"},
+		} {
+			page, code := listWidgets(t, r, query+"&limit=3")
+			if code != http.StatusOK || !reflect.DeepEqual(widgetNames(page), expected) {
+				t.Errorf("expected %s to match %v; got %d %v", query, expected, code, widgetNames(page))
+			}
+		}
+		if _, code := listWidgets(t, r, "count=abc"); code != http.StatusBadRequest {
+			t.Errorf("expected an invalid number to be rejected; got %d", code)
+		}
+		if _, code := listWidgets(t, r, "q=first"); code != http.StatusBadRequest {
+			t.Errorf("expected search to be rejected without SearchFields; got %d", code)
+		}
+	})
+}
+
+func TestCrudListParams(t *testing.T) {
+	_, db := crudEngine(t, driver.TypeSqlite3)
+	c := CRUD(db, crudWidget{}, &CrudOpts{List: true, FilterFields: []string{"count"}, SortFields: []string{"name"}})
+	keys := map[string]bool{}
+	for _, f := range c.Routes()[0].(IRouteDoc).RequestFields() {
+		keys[f.Key] = true
+	}
+	for _, key := range []string{"limit", "offset", "cursor", "sort", "count", "count.gte", "count.in", "count.like"} {
+		if !keys[key] {
+			t.Errorf("expected the %s parameter to be described; got %v", key, keys)
+		}
+	}
+}
+
+func TestCrudRejectsNullableSortFields(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a nullable sort field")
+		}
+	}()
+	CRUD(nil, crudWidget{}, &CrudOpts{List: true, SortFields: []string{"note"}, Driver: driver.TypeSqlite3})
+}
+
+type crudNote struct {
+	Id       int64  `json:"id" db:"id"`
+	OwnerId  string `json:"ownerId" db:"owner_id"`
+	Title    string `json:"title" db:"title"`
+	Revision int    `json:"revision" db:"revision"`
+}
+
+var crudNoteFinds int64
+
+func (n *crudNote) BeforeCreate(c *gin.Context, tx *sqlx.Tx) error {
+	n.OwnerId = c.GetHeader("X-User")
+	if owner := c.GetHeader("X-Owner"); owner != "" {
+		n.OwnerId = owner
+	}
+	return nil
+}
+
+func (n *crudNote) AfterCreate(c *gin.Context, tx *sqlx.Tx) error {
+	_, err := tx.ExecContext(c, "INSERT INTO crud_note_log (note_id) VALUES (?)", n.Id)
+	return err
+}
+
+func (n *crudNote) BeforeUpdate(c *gin.Context, tx *sqlx.Tx) error {
+	n.Revision++
+	return nil
+}
+
+func (n *crudNote) BeforeDelete(c *gin.Context, tx *sqlx.Tx) error {
+	if n.Title == "keep" {
+		return NewHTTPError(http.StatusConflict, "this note can't be deleted")
+	}
+	return nil
+}
+
+func (n *crudNote) AfterFind(c *gin.Context, tx *sqlx.Tx) error {
+	atomic.AddInt64(&crudNoteFinds, 1)
+	return nil
+}
+
+func ownerPolicy(c *gin.Context) (*CrudScope, error) {
+	user := c.GetHeader("X-User")
+	if user == "" {
+		return nil, NewHTTPError(http.StatusUnauthorized, "sign in first")
+	}
+	return &CrudScope{Where: "owner_id = ?", Args: []any{user}}, nil
+}
+
+func noteEngine(t *testing.T) (*gin.Engine, *sqlx.DB) {
+	db := crudDB(t)
+	db.MustExec("CREATE TABLE crud_note (id INTEGER PRIMARY KEY, owner_id TEXT NOT NULL, title TEXT NOT NULL, revision INTEGER NOT NULL DEFAULT 0)")
+	db.MustExec("CREATE TABLE crud_note_log (note_id INTEGER NOT NULL)")
+	c := CRUD(db, crudNote{}, &CrudOpts{
+		Get: true, List: true, Create: true, Update: true, Delete: true,
+		UpdatableFields: []string{"title"},
+		Policies: CrudPolicies{
+			Get: ownerPolicy, List: ownerPolicy, Create: ownerPolicy, Update: ownerPolicy, Delete: ownerPolicy,
+		},
+	})
+	return problemEngine(false, c), db
+}
+
+func doNote(r *gin.Engine, method, path, user, body string) (res *httptest.ResponseRecorder, note crudNote) {
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	r.ServeHTTP(res, req)
+	json.Unmarshal(res.Body.Bytes(), &note)
+	return
+}
+
+func TestCrudHooks(t *testing.T) {
+	r, db := noteEngine(t)
+	res, note := doNote(r, http.MethodPost, "/crud-note", "ann", `{"title":"keep"}`)
+	if res.Code != http.StatusCreated || note.OwnerId != "ann" {
+		t.Fatalf("expected BeforeCreate to set the owner; got %d %s", res.Code, res.Body.String())
+	}
+	var logged int
+	db.Get(&logged, "SELECT COUNT(*) FROM crud_note_log WHERE note_id = ?", note.Id)
+	if logged != 1 {
+		t.Errorf("expected AfterCreate to write in the transaction; got %d rows", logged)
+	}
+
+	finds := atomic.LoadInt64(&crudNoteFinds)
+	res, note = doNote(r, http.MethodPatch, "/crud-note/1", "ann", `{"title":"keep"}`)
+	if res.Code != http.StatusOK || note.Revision != 1 {
+		t.Errorf("expected BeforeUpdate to increment the revision; got %d %s", res.Code, res.Body.String())
+	}
+	if atomic.LoadInt64(&crudNoteFinds) == finds {
+		t.Errorf("expected AfterFind to be called")
+	}
+
+	if res, _ := doNote(r, http.MethodDelete, "/crud-note/1", "ann", ""); res.Code != http.StatusConflict {
+		t.Errorf("expected BeforeDelete to deny the delete; got %d", res.Code)
+	}
+	if res, _ := doNote(r, http.MethodGet, "/crud-note/1", "ann", ""); res.Code != http.StatusOK {
+		t.Errorf("expected the note to remain; got %d", res.Code)
+	}
+}
+
+func TestCrudPolicies(t *testing.T) {
+	r, db := noteEngine(t)
+	doNote(r, http.MethodPost, "/crud-note", "ann", `{"title":"ann's"}`)
+	doNote(r, http.MethodPost, "/crud-note", "bob", `{"title":"bob's"}`)
+
+	if res, _ := doNote(r, http.MethodGet, "/crud-note", "", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected the policy to deny anonymous requests; got %d", res.Code)
+	}
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/crud-note", nil)
+	req.Header.Set("X-User", "ann")
+	r.ServeHTTP(res, req)
+	var page Page[crudNote]
+	json.Unmarshal(res.Body.Bytes(), &page)
+	if len(page.Items) != 1 || page.Items[0].OwnerId != "ann" || *page.Total != 1 {
+		t.Errorf("expected lists to be scoped; got %s", res.Body.String())
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodPut, http.MethodDelete} {
+		if res, _ := doNote(r, method, "/crud-note/2", "ann", `{"title":"mine"}`); res.Code != http.StatusNotFound {
+			t.Errorf("expected %s of another user's note to 404; got %d", method, res.Code)
+		}
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/crud-note", bytes.NewBufferString(`{"title":"sneaky"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", "ann")
+	req.Header.Set("X-Owner", "bob")
+	r.ServeHTTP(res, req)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("expected creating a note outside of the scope to be denied; got %d", res.Code)
+	}
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM crud_note")
+	if count != 2 {
+		t.Errorf("expected the denied insert to be rolled back; got %d notes", count)
+	}
+}
+
+type crudPost struct {
+	Id        int64              `json:"id" db:"id"`
+	Title     string             `json:"title" db:"title"`
+	CreatedAt gtime.TimeDateTime `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time          `json:"updatedAt" db:"updated_at"`
+	DeletedAt *time.Time         `json:"deletedAt" db:"deleted_at"`
+}
+
+func postEngine(t *testing.T) (*gin.Engine, *sqlx.DB) {
+	db := crudDB(t)
+	db.MustExec("CREATE TABLE crud_post (id INTEGER PRIMARY KEY, title TEXT NOT NULL, created_at DATETIME NOT NULL, updated_at DATETIME NOT NULL, deleted_at DATETIME NULL)")
+	c := CRUD(db, crudPost{}, &CrudOpts{
+		Get: true, List: true, Create: true, Update: true, Delete: true,
+		SoftDelete: true, IncludeDeleted: true, Restore: true,
+	})
+	return problemEngine(false, c), db
+}
+
+func doPost(r *gin.Engine, method, path, body string) (res *httptest.ResponseRecorder, post crudPost) {
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(res, req)
+	json.Unmarshal(res.Body.Bytes(), &post)
+	return
+}
+
+func TestCrudTimestamps(t *testing.T) {
+	r, db := postEngine(t)
+	res, post := doPost(r, http.MethodPost, "/crud-post", `{"title":"hello"}`)
+	if res.Code != http.StatusCreated || post.CreatedAt.IsZero() || post.UpdatedAt.IsZero() || post.DeletedAt != nil {
+		t.Fatalf("expected the timestamps to be set on insert; got %d %s", res.Code, res.Body.String())
+	}
+	if res, _ := doPost(r, http.MethodPatch, "/crud-post/1", `{"createdAt":"2000-01-01T00:00:00Z"}`); res.Code != http.StatusBadRequest {
+		t.Errorf("expected timestamps to be read only; got %d", res.Code)
+	}
+
+	db.MustExec("UPDATE crud_post SET updated_at = '2000-01-01 00:00:00'")
+	res, updated := doPost(r, http.MethodPatch, "/crud-post/1", `{"title":"changed"}`)
+	if res.Code != http.StatusOK || updated.UpdatedAt.Year() == 2000 || !updated.CreatedAt.Equal(post.CreatedAt) {
+		t.Errorf("expected only updated_at to change; got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestCrudSoftDelete(t *testing.T) {
+	r, db := postEngine(t)
+	doPost(r, http.MethodPost, "/crud-post", `{"title":"first"}`)
+	doPost(r, http.MethodPost, "/crud-post", `{"title":"second"}`)
+
+	if res, _ := doPost(r, http.MethodDelete, "/crud-post/1", ""); res.Code != http.StatusNoContent {
+		t.Fatalf("expected 204; got %d", res.Code)
+	}
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM crud_post WHERE deleted_at IS NOT NULL")
+	if count != 1 {
+		t.Errorf("expected the row to be kept and marked as deleted")
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		if res, _ := doPost(r, method, "/crud-post/1", `{"title":"x"}`); res.Code != http.StatusNotFound {
+			t.Errorf("expected %s of a deleted row to 404; got %d", method, res.Code)
+		}
+	}
+	if res, post := doPost(r, http.MethodGet, "/crud-post/1?include_deleted=true", ""); res.Code != http.StatusOK || post.DeletedAt == nil {
+		t.Errorf("expected include_deleted to return the deleted row; got %d %s", res.Code, res.Body.String())
+	}
+
+	list := func(query string) (page Page[crudPost]) {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/crud-post"+query, nil))
+		json.Unmarshal(res.Body.Bytes(), &page)
+		return
+	}
+	if page := list(""); len(page.Items) != 1 || page.Items[0].Title != "second" {
+		t.Errorf("expected lists to exclude deleted rows; got %+v", page.Items)
+	}
+	if page := list("?include_deleted=true"); len(page.Items) != 2 {
+		t.Errorf("expected include_deleted to list deleted rows; got %+v", page.Items)
+	}
+
+	if res, post := doPost(r, http.MethodPost, "/crud-post/1/restore", ""); res.Code != http.StatusOK || post.DeletedAt != nil {
+		t.Errorf("expected the row to be restored; got %d %s", res.Code, res.Body.String())
+	}
+	if page := list(""); len(page.Items) != 2 {
+		t.Errorf("expected the restored row to be listed; got %+v", page.Items)
+	}
+}
+
+type crudAuthor struct {
+	Id    int64  `json:"id" db:"id"`
+	Name  string `json:"name" db:"name"`
+	Email string `json:"email" db:"email"`
+}
+
+type crudComment struct {
+	Id       int64  `json:"id" db:"id"`
+	AuthorId int64  `json:"authorId" db:"author_id" binding:"required"`
+	Body     string `json:"body" db:"body"`
+}
+
+var crudCommentMigrations = []migrate.Migration{{
+	Up: func(s *schema.Schema) {
+		s.Create("crud_author", func(t *schema.Table) {
+			t.Primary("id")
+			t.String("name")
+			t.String("email")
+		})
+		s.Create("crud_comment", func(t *schema.Table) {
+			t.Primary("id")
+			t.Integer("author_id").References("crud_author", "id")
+			t.Text("body")
+		})
+	},
+}}
+
+func commentEngine(t *testing.T) (*gin.Engine, *sqlx.DB) {
+	db := testDB(t, crudCommentMigrations...)
+	db.MustExec("INSERT INTO crud_author (id, name, email) VALUES (1, 'ann', 'ann@example.com'), (2, 'bob', 'bob@example.com'), (3, 'cat', 'cat@example.com')")
+	authors := CRUD(db, crudAuthor{}, &CrudOpts{Get: true, VisibleFields: []string{"id", "name"}})
+	var relations []CrudRelation
+	for _, fk := range migrate.ForeignKeys(crudCommentMigrations, "crud_comment") {
+		relations = append(relations, CrudRelation{ForeignKey: fk, Nested: true, Parent: authors})
+	}
+	comments := CRUD(db, crudComment{}, &CrudOpts{
+		Get: true, List: true, Create: true,
+		UpdatableFields: []string{"author_id", "body"},
+		Relations:       relations,
+	})
+	return problemEngine(false, authors, comments), db
+}
+
+func TestCrudNestedRoutes(t *testing.T) {
+	r, _ := commentEngine(t)
+	res, _ := doCrud(r, http.MethodPost, "/crud-author/2/crud-comment", `{"body":"hi","authorId":1}`)
+	if res.Code != http.StatusCreated || !strings.Contains(res.Body.String(), `"authorId":2`) {
+		t.Fatalf("expected the author to be filled in from the path; got %d %s", res.Code, res.Body.String())
+	}
+	doCrud(r, http.MethodPost, "/crud-comment", `{"body":"hello","authorId":1}`)
+
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/crud-author/2/crud-comment", nil))
+	var page Page[crudComment]
+	json.Unmarshal(res.Body.Bytes(), &page)
+	if len(page.Items) != 1 || page.Items[0].Body != "hi" || *page.Total != 1 {
+		t.Errorf("expected the comments of the author; got %s", res.Body.String())
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if res, _ := doCrud(r, method, "/crud-author/9/crud-comment", `{"body":"hi"}`); res.Code != http.StatusNotFound {
+			t.Errorf("expected %s below a missing author to 404; got %d", method, res.Code)
+		}
+	}
+}
+
+func TestCrudIncludes(t *testing.T) {
+	r, db := commentEngine(t)
+	db.MustExec("INSERT INTO crud_comment (author_id, body) VALUES (1, 'a'), (2, 'b'), (1, 'c')")
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/crud-comment?include=author", nil))
+	var page struct {
+		Items []map[string]any `json:"items"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &page)
+	if res.Code != http.StatusOK || len(page.Items) != 3 {
+		t.Fatalf("expected 3 comments; got %d %s", res.Code, res.Body.String())
+	}
+	for _, item := range page.Items {
+		author, _ := item["author"].(map[string]any)
+		if author == nil || author["id"] != item["authorId"] || author["email"] != nil {
+			t.Errorf("expected the visible fields of the author to be included; got %v", item)
+		}
+	}
+
+	res, _ = doCrud(r, http.MethodGet, "/crud-comment/2?include=author", "")
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), `"author":{"id":2,"name":"bob"}`) {
+		t.Errorf("expected the author to be included; got %d %s", res.Code, res.Body.String())
+	}
+	if res, _ := doCrud(r, http.MethodGet, "/crud-comment?include=editor", ""); res.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown includes to be rejected; got %d", res.Code)
+	}
+}
+
+type bulkResponse struct {
+	Results []struct {
+		Index  int            `json:"index"`
+		Status int            `json:"status"`
+		Item   map[string]any `json:"item"`
+		Error  *Problem       `json:"error"`
+	} `json:"results"`
+}
+
+func doBulk(r *gin.Engine, method, path, body string) (res *httptest.ResponseRecorder, bulk bulkResponse) {
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(res, req)
+	json.Unmarshal(res.Body.Bytes(), &bulk)
+	return
+}
+
+func countWidgets(t *testing.T, db *sqlx.DB) (n int) {
+	if err := db.Get(&n, "SELECT COUNT(*) FROM crud_widget"); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestCrudBulkCreate(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		res, bulk := doBulk(r, http.MethodPost, "/crud-widget/bulk", `[{"name":"a","count":1},{"name":"b","note":"x"}]`)
+		if res.Code != http.StatusCreated || len(bulk.Results) != 2 {
+			t.Fatalf("expected 201 with 2 results; got %d %s", res.Code, res.Body.String())
+		}
+		for i, name := range []string{"a", "b"} {
+			result := bulk.Results[i]
+			if result.Status != http.StatusCreated || result.Item["name"] != name || result.Item["id"] != float64(i+2) {
+				t.Errorf("expected %s to be created with id %d; got %+v", name, i+2, result)
+			}
+		}
+
+		res, _ = doCrud(r, http.MethodPost, "/crud-widget/bulk", `[{"name":"c"},{"count":2}]`)
+		if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), `"field":"[1].name"`) {
+			t.Errorf("expected the invalid item to fail the request; got %d %s", res.Code, res.Body.String())
+		}
+		if n := countWidgets(t, db); n != 3 {
+			t.Errorf("expected atomic requests to be all-or-nothing; got %d widgets", n)
+		}
+
+		res, bulk = doBulk(r, http.MethodPost, "/crud-widget/bulk?atomic=false", `[{"name":"c"},{"count":2}]`)
+		if res.Code != http.StatusMultiStatus || len(bulk.Results) != 2 {
+			t.Fatalf("expected 207 with 2 results; got %d %s", res.Code, res.Body.String())
+		}
+		if bulk.Results[0].Status != http.StatusCreated || bulk.Results[1].Status != http.StatusBadRequest || bulk.Results[1].Error == nil {
+			t.Errorf("expected a result for each item; got %s", res.Body.String())
+		}
+		if n := countWidgets(t, db); n != 4 {
+			t.Errorf("expected the valid item to be created; got %d widgets", n)
+		}
+
+		if res, _ := doCrud(r, http.MethodPost, "/crud-widget/bulk", `[{"name":"a"},{"name":"b"},{"name":"c"},{"name":"d"}]`); res.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected batches over the limit to be rejected; got %d", res.Code)
+		}
+	})
+}
+
+func TestCrudBulkUpdateAndDelete(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		doBulk(r, http.MethodPost, "/crud-widget/bulk", `[{"name":"second"},{"name":"third"}]`)
+
+		res, bulk := doBulk(r, http.MethodPatch, "/crud-widget/bulk", `[{"id":1,"count":5},{"id":"2","name":"changed"}]`)
+		if res.Code != http.StatusOK || bulk.Results[0].Item["count"] != float64(5) || bulk.Results[1].Item["name"] != "changed" {
+			t.Errorf("expected both items to be patched; got %d %s", res.Code, res.Body.String())
+		}
+		if res, _ := doCrud(r, http.MethodPatch, "/crud-widget/bulk", `[{"name":"no id"}]`); res.Code != http.StatusBadRequest {
+			t.Errorf("expected items without an id to be rejected; got %d", res.Code)
+		}
+
+		if res, _ := doCrud(r, http.MethodDelete, "/crud-widget/bulk", `[1,9]`); res.Code != http.StatusNotFound {
+			t.Errorf("expected a missing row to fail the request; got %d", res.Code)
+		}
+		if n := countWidgets(t, db); n != 3 {
+			t.Errorf("expected nothing to be deleted; got %d widgets", n)
+		}
+		res, bulk = doBulk(r, http.MethodDelete, "/crud-widget/bulk", `[1,"2"]`)
+		if res.Code != http.StatusOK || len(bulk.Results) != 2 || bulk.Results[0].Status != http.StatusNoContent {
+			t.Errorf("expected the rows to be deleted; got %d %s", res.Code, res.Body.String())
+		}
+		if n := countWidgets(t, db); n != 1 {
+			t.Errorf("expected 1 widget to remain; got %d", n)
+		}
+	})
+}
+
+type crudDoc struct {
+	Id      int64  `json:"id" db:"id"`
+	Title   string `json:"title" db:"title"`
+	Version int    `json:"version" db:"version"`
+}
+
+func crudDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func doVersioned(r *gin.Engine, method, path, ifMatch, body string) (res *httptest.ResponseRecorder, data map[string]any) {
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	r.ServeHTTP(res, req)
+	json.Unmarshal(res.Body.Bytes(), &data)
+	return
+}
+
+func TestCrudVersionColumn(t *testing.T) {
+	db := crudDB(t)
+	db.MustExec("CREATE TABLE crud_doc (id INTEGER PRIMARY KEY, title TEXT NOT NULL, version INTEGER NOT NULL)")
+	r := problemEngine(false, CRUD(db, crudDoc{}, &CrudOpts{
+		Get: true, Create: true, Update: true, Delete: true,
+		VersionColumn: "version",
+	}))
+
+	res, data := doVersioned(r, http.MethodPost, "/crud-doc", "", `{"title":"a"}`)
+	etag := res.Header().Get("ETag")
+	if res.Code != http.StatusCreated || data["version"] != float64(1) || etag == "" {
+		t.Fatalf("expected the first version with an ETag; got %d %v %s", res.Code, res.Header(), res.Body.String())
+	}
+	if res, _ := doVersioned(r, http.MethodGet, "/crud-doc/1", "", ""); res.Header().Get("ETag") != etag {
+		t.Errorf("expected GET to return the same ETag; got %q", res.Header().Get("ETag"))
+	}
+
+	if res, _ := doVersioned(r, http.MethodPatch, "/crud-doc/1", "", `{"title":"b"}`); res.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected writes without a version to be rejected; got %d", res.Code)
+	}
+	res, data = doVersioned(r, http.MethodPatch, "/crud-doc/1", etag, `{"title":"b"}`)
+	if res.Code != http.StatusOK || data["version"] != float64(2) || res.Header().Get("ETag") == etag {
+		t.Fatalf("expected the version to be incremented; got %d %s", res.Code, res.Body.String())
+	}
+	if res, _ := doVersioned(r, http.MethodPatch, "/crud-doc/1", etag, `{"title":"c"}`); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected a stale If-Match to be rejected; got %d", res.Code)
+	}
+
+	if res, data := doVersioned(r, http.MethodPut, "/crud-doc/1", "", `{"title":"c","version":2}`); res.Code != http.StatusOK || data["version"] != float64(3) {
+		t.Errorf("expected the version in the payload to be accepted; got %d %s", res.Code, res.Body.String())
+	}
+	if res, _ := doVersioned(r, http.MethodPut, "/crud-doc/1", "", `{"title":"d","version":2}`); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected a stale version in the payload to be rejected; got %d", res.Code)
+	}
+
+	if res, _ := doVersioned(r, http.MethodDelete, "/crud-doc/1", etag, ""); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected a stale delete to be rejected; got %d", res.Code)
+	}
+	res, _ = doVersioned(r, http.MethodGet, "/crud-doc/1", "", "")
+	if res, _ := doVersioned(r, http.MethodDelete, "/crud-doc/1", res.Header().Get("ETag"), ""); res.Code != http.StatusNoContent {
+		t.Errorf("expected the delete to succeed; got %d", res.Code)
+	}
+}
+
+func TestCrudUnchangedUpdate(t *testing.T) {
+	r, db := crudEngine(t, driver.TypeSqlite3)
+	// skip updates which change nothing so no rows are affected, like MySQL
+	db.MustExec(`CREATE TRIGGER crud_widget_unchanged BEFORE UPDATE ON crud_widget
+		WHEN NEW.name = OLD.name AND NEW.note IS OLD.note AND NEW.count = OLD.count
+		BEGIN SELECT RAISE(IGNORE); END`)
+	res, _ := doCrud(r, http.MethodPatch, "/crud-widget/1", `{"name":"first","count":1}`)
+	if res.Code != http.StatusOK {
+		t.Errorf("expected an update without changes to succeed; got %d %s", res.Code, res.Body.String())
+	}
+	if res, _ = doCrud(r, http.MethodPatch, "/crud-widget/9", `{"name":"first"}`); res.Code != http.StatusNotFound {
+		t.Errorf("expected a missing row to 404; got %d", res.Code)
+	}
+}
+
+type crudVersionedPost struct {
+	Id        int64      `json:"id" db:"id"`
+	Title     string     `json:"title" db:"title"`
+	Version   int        `json:"version" db:"version"`
+	DeletedAt *time.Time `json:"deletedAt" db:"deleted_at"`
+}
+
+func TestCrudRestoreVersion(t *testing.T) {
+	db := crudDB(t)
+	db.MustExec("CREATE TABLE crud_versioned_post (id INTEGER PRIMARY KEY, title TEXT NOT NULL, version INTEGER NOT NULL, deleted_at DATETIME NULL)")
+	r := problemEngine(false, CRUD(db, crudVersionedPost{}, &CrudOpts{
+		Get: true, Create: true, Delete: true, IncludeDeleted: true,
+		SoftDelete: true, Restore: true, VersionColumn: "version",
+	}))
+	res, _ := doVersioned(r, http.MethodPost, "/crud-versioned-post", "", `{"title":"a"}`)
+	doVersioned(r, http.MethodDelete, "/crud-versioned-post/1", res.Header().Get("ETag"), "")
+	res, _ = doVersioned(r, http.MethodGet, "/crud-versioned-post/1?include_deleted=true", "", "")
+	etag := res.Header().Get("ETag")
+
+	if res, _ := doVersioned(r, http.MethodPost, "/crud-versioned-post/1/restore", "", ""); res.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected a restore without If-Match to be rejected; got %d", res.Code)
+	}
+	if res, _ := doVersioned(r, http.MethodPost, "/crud-versioned-post/1/restore", `"stale"`, ""); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected a stale restore to be rejected; got %d", res.Code)
+	}
+	res, data := doVersioned(r, http.MethodPost, "/crud-versioned-post/1/restore", etag, "")
+	if res.Code != http.StatusOK || data["deletedAt"] != nil || res.Header().Get("ETag") == etag {
+		t.Fatalf("expected the restore to change the version; got %d %s", res.Code, res.Body.String())
+	}
+	if res, _ := doVersioned(r, http.MethodPost, "/crud-versioned-post/1/restore", etag, ""); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected the old version to be stale after restoring; got %d", res.Code)
+	}
+	restored := res.Header().Get("ETag")
+	if res, _ := doVersioned(r, http.MethodPost, "/crud-versioned-post/1/restore", restored, ""); res.Code != http.StatusOK || res.Header().Get("ETag") != restored {
+		t.Errorf("expected restoring a live row to leave its version as is; got %d %s", res.Code, res.Header().Get("ETag"))
+	}
+}
+
+func TestCrudUpdatedAtVersion(t *testing.T) {
+	db := crudDB(t)
+	db.MustExec("CREATE TABLE crud_post (id INTEGER PRIMARY KEY, title TEXT NOT NULL, created_at DATETIME NOT NULL, updated_at DATETIME NOT NULL, deleted_at DATETIME NULL)")
+	db.MustExec("INSERT INTO crud_post (title, created_at, updated_at) VALUES ('a', '2000-01-01 00:00:00', '2000-01-01 00:00:00')")
+	r := problemEngine(false, CRUD(db, crudPost{}, &CrudOpts{Get: true, Update: true, VersionColumn: "updated_at"}))
+
+	res, _ := doVersioned(r, http.MethodGet, "/crud-post/1", "", "")
+	etag := res.Header().Get("ETag")
+	if res, _ := doVersioned(r, http.MethodPatch, "/crud-post/1", "", `{"title":"b","updatedAt":"2000-01-01T00:00:00Z"}`); res.Code != http.StatusOK {
+		t.Fatalf("expected the timestamp in the payload to match; got %d %s", res.Code, res.Body.String())
+	}
+	if res, _ := doVersioned(r, http.MethodPatch, "/crud-post/1", etag, `{"title":"c"}`); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected the ETag to change with updated_at; got %d", res.Code)
+	}
+
+	// writes within the same second still change the version
+	res, _ = doVersioned(r, http.MethodGet, "/crud-post/1", "", "")
+	etag = res.Header().Get("ETag")
+	res, _ = doVersioned(r, http.MethodPatch, "/crud-post/1", etag, `{"title":"d"}`)
+	if res.Code != http.StatusOK || res.Header().Get("ETag") == etag {
+		t.Fatalf("expected the write to change the ETag; got %d %s", res.Code, res.Header().Get("ETag"))
+	}
+	if res, _ := doVersioned(r, http.MethodPatch, "/crud-post/1", etag, `{"title":"e"}`); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected the ETag from before the last write to be stale; got %d", res.Code)
+	}
+}
+
+func TestCrudRejectsOtherVersionTypes(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a string version column")
+		}
+	}()
+	CRUD(nil, crudWidget{}, &CrudOpts{Update: true, VersionColumn: "name", Driver: driver.TypeSqlite3})
+}
+
+func TestCrudSparseFields(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		res, data := doCrud(r, http.MethodGet, "/crud-widget/1?fields=id,name", "")
+		if res.Code != http.StatusOK || !reflect.DeepEqual(data, map[string]any{"id": 1.0, "name": "first"}) {
+			t.Errorf("expected only the id and name; got %d %s", res.Code, res.Body.String())
+		}
+		page, code := listWidgets(t, r, "fields=name")
+		if code != http.StatusOK || len(page.Items) != 1 || !strings.Contains(fmt.Sprint(page.Items[0]), "first") {
+			t.Fatalf("expected the widget; got %d %v", code, page)
+		}
+		res, _ = doCrud(r, http.MethodGet, "/crud-widget?fields=name", "")
+		if strings.Contains(res.Body.String(), `"count"`) {
+			t.Errorf("expected only the name; got %s", res.Body.String())
+		}
+		for _, path := range []string{"/crud-widget/1?fields=secret", "/crud-widget?fields=name,bogus"} {
+			if res, _ := doCrud(r, http.MethodGet, path, ""); res.Code != http.StatusBadRequest {
+				t.Errorf("expected %s to be rejected; got %d", path, res.Code)
+			}
+		}
+	})
+}
+
+func TestCrudFieldPermissions(t *testing.T) {
+	db := crudDB(t)
+	db.MustExec(crudWidgetTables[driver.TypeSqlite3])
+	db.MustExec("INSERT INTO crud_widget (name, count, secret) VALUES ('alice', 1, 'a'), ('bob', 2, 'b')")
+	c := CRUD(db, crudWidget{}, &CrudOpts{
+		Get: true, List: true,
+		FilterFields: []string{"name", "secret"},
+		SortFields:   []string{"name", "secret"},
+		FieldPermissions: map[string]CrudFieldPermission{
+			"secret": {
+				Request: func(c *gin.Context) bool { return c.GetHeader("X-Admin") != "" },
+				Row:     func(c *gin.Context, row any) bool { return row.(crudWidget).Name == c.GetHeader("X-User") },
+			},
+			"count": {},
+		},
+	})
+	r := problemEngine(false, c)
+	get := func(path string, header ...string) (*httptest.ResponseRecorder, string) {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		r.ServeHTTP(res, req)
+		return res, res.Body.String()
+	}
+
+	res, body := get("/crud-widget?sort=name", "X-Admin", "1")
+	if res.Code != http.StatusOK || !strings.Contains(body, `"secret":"a"`) || !strings.Contains(body, `"secret":"b"`) {
+		t.Errorf("expected admins to see every secret; got %d %s", res.Code, body)
+	}
+	res, body = get("/crud-widget?sort=name", "X-User", "bob")
+	if res.Code != http.StatusOK || strings.Contains(body, `"secret":"a"`) || !strings.Contains(body, `"secret":"b"`) {
+		t.Errorf("expected only the secret of bob; got %d %s", res.Code, body)
+	}
+	if strings.Contains(body, `"count"`) {
+		t.Errorf("expected count to be hidden from everyone; got %s", body)
+	}
+	if _, body = get("/crud-widget/1", "X-User", "bob"); strings.Contains(body, "secret") {
+		t.Errorf("expected the secret of alice to be omitted; got %s", body)
+	}
+	if res, _ = get("/crud-widget/1?fields=count", "X-Admin", "1"); res.Code != http.StatusBadRequest {
+		t.Errorf("expected hidden fields to be rejected; got %d", res.Code)
+	}
+	for _, path := range []string{"/crud-widget?secret=a", "/crud-widget?sort=secret"} {
+		if res, _ = get(path, "X-User", "alice"); res.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected; got %d", path, res.Code)
+		}
+		if res, _ = get(path, "X-Admin", "1"); res.Code != http.StatusOK {
+			t.Errorf("expected %s to be allowed for admins; got %d", path, res.Code)
+		}
+	}
+}
+
+func doTransfer(r *gin.Engine, method, path, contentType, body string) (res *httptest.ResponseRecorder, result ImportResponse) {
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if method == http.MethodGet {
+		req.Header.Set("Accept", contentType)
+	} else {
+		req.Header.Set("Content-Type", contentType)
+	}
+	r.ServeHTTP(res, req)
+	json.Unmarshal(res.Body.Bytes(), &result)
+	return
+}
+
+func TestCrudExport(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		db.MustExec("INSERT INTO crud_widget (name, count) VALUES ('second', 2), ('third', 3), ('fourth', 4)")
+		res, _ := doTransfer(r, http.MethodGet, "/crud-widget/export?count.gte=2&sort=-count&fields=id,name", "", "")
+		expected := "{\"id\":4,\"name\":\"fourth\"}\n{\"id\":3,\"name\":\"third\"}\n{\"id\":2,\"name\":\"second\"}\n"
+		if res.Code != http.StatusOK || res.Body.String() != expected {
+			t.Errorf("expected every matching widget as NDJSON; got %d %q", res.Code, res.Body.String())
+		}
+		if ct := res.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("expected NDJSON; got %s", ct)
+		}
+
+		res, _ = doTransfer(r, http.MethodGet, "/crud-widget/export?count.lt=3", "text/csv", "")
+		expected = "id,name,note,count\n1,first,a note,1\n2,second,,2\n"
+		if res.Code != http.StatusOK || res.Body.String() != expected {
+			t.Errorf("expected the widgets as CSV; got %d %q", res.Code, res.Body.String())
+		}
+		if res, _ = doTransfer(r, http.MethodGet, "/crud-widget/export?name=none", "text/csv", ""); res.Body.String() != "id,name,note,count\n" {
+			t.Errorf("expected only the header; got %q", res.Body.String())
+		}
+		for _, query := range []string{"include=author", "secret=hidden", "fields=secret"} {
+			if res, _ = doTransfer(r, http.MethodGet, "/crud-widget/export?"+query, "", ""); res.Code != http.StatusBadRequest {
+				t.Errorf("expected %s to be rejected; got %d", query, res.Code)
+			}
+		}
+	})
+}
+
+func TestCrudImport(t *testing.T) {
+	forEachCrudDB(t, func(t *testing.T, r *gin.Engine, db *sqlx.DB) {
+		res, result := doTransfer(r, http.MethodPost, "/crud-widget/import", "application/x-ndjson", "{\"name\":\"a\",\"count\":1}\n\n{\"name\":\"b\"}\n")
+		if res.Code != http.StatusCreated || result.Rows != 2 || result.Created != 2 || countWidgets(t, db) != 3 {
+			t.Fatalf("expected 2 widgets to be created; got %d %s", res.Code, res.Body.String())
+		}
+
+		res, result = doTransfer(r, http.MethodPost, "/crud-widget/import", "text/csv", "name,note,count\nc,,3\nd,\"a, note\",4\n")
+		if res.Code != http.StatusCreated || result.Created != 2 {
+			t.Fatalf("expected 2 widgets to be created from CSV; got %d %s", res.Code, res.Body.String())
+		}
+		var note *string
+		if err := db.Get(&note, db.Rebind("SELECT note FROM crud_widget WHERE name = ?"), "c"); err != nil || note != nil {
+			t.Errorf("expected an empty cell to be null; got %v %v", note, err)
+		}
+
+		// a single transaction writes nothing when any row fails
+		body := "name,count\ne,5\n,6\nf,x\n"
+		res, result = doTransfer(r, http.MethodPost, "/crud-widget/import", "text/csv", body)
+		if res.Code != http.StatusUnprocessableEntity || result.Created != 0 || result.Failed != 3 || len(result.Errors) != 2 {
+			t.Fatalf("expected the import to fail; got %d %s", res.Code, res.Body.String())
+		}
+		if result.Errors[0].Index != 1 || result.Errors[0].Error.Errors[0].Field != "name" || result.Errors[1].Index != 2 {
+			t.Errorf("expected the errors of the second and third rows; got %+v", result.Errors)
+		}
+		if countWidgets(t, db) != 5 {
+			t.Errorf("expected nothing to be written; got %d widgets", countWidgets(t, db))
+		}
+
+		res, result = doTransfer(r, http.MethodPost, "/crud-widget/import?chunk_size=1", "text/csv", body)
+		if res.Code != http.StatusMultiStatus || result.Created != 1 || result.Failed != 2 || countWidgets(t, db) != 6 {
+			t.Errorf("expected the valid chunk to be written; got %d %s", res.Code, res.Body.String())
+		}
+
+		res, result = doTransfer(r, http.MethodPost, "/crud-widget/import?dry_run=true", "application/x-ndjson", "{\"name\":\"g\"}\n")
+		if res.Code != http.StatusOK || !result.DryRun || result.Created != 1 || countWidgets(t, db) != 6 {
+			t.Errorf("expected a dry run to write nothing; got %d %s", res.Code, res.Body.String())
+		}
+
+		for contentType, body := range map[string]string{
+			"application/json":     `[{"name":"h"}]`,
+			"text/csv":             "name,bogus\nh,1\n",
+			"application/x-ndjson": "{\"name\":\"h\"}\n{oops\n",
+		} {
+			if res, _ = doTransfer(r, http.MethodPost, "/crud-widget/import", contentType, body); res.Code < 400 {
+				t.Errorf("expected %s %q to be rejected; got %d", contentType, body, res.Code)
+			}
+		}
+		if countWidgets(t, db) != 6 {
+			t.Errorf("expected rejected imports to write nothing; got %d widgets", countWidgets(t, db))
+		}
+	})
+}
+
+func TestCrudImportLimitAndHooks(t *testing.T) {
+	db := crudDB(t)
+	db.MustExec("CREATE TABLE crud_note (id INTEGER PRIMARY KEY, owner_id TEXT NOT NULL, title TEXT NOT NULL, revision INTEGER NOT NULL DEFAULT 0)")
+	db.MustExec("CREATE TABLE crud_note_log (note_id INTEGER NOT NULL)")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorHandler(false), middleware.BodyLimit(64))
+	RouteGin(r, CRUD(db, crudNote{}, &CrudOpts{
+		Import: true, MaxImportSize: 1024,
+		Policies: CrudPolicies{Create: ownerPolicy},
+	}))
+	doImport := func(path string, rows int) (res *httptest.ResponseRecorder) {
+		res = httptest.NewRecorder()
+		body := strings.Repeat("{\"title\":\"a note\"}\n", rows)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("X-User", "ann")
+		r.ServeHTTP(res, req)
+		return
+	}
+	logged := func() (n int) {
+		db.Get(&n, "SELECT COUNT(*) FROM crud_note_log")
+		return
+	}
+
+	if res := doImport("/crud-note/import?dry_run=true", 10); res.Code != http.StatusOK {
+		t.Fatalf("expected an import over the server's body limit to be allowed; got %d %s", res.Code, res.Body.String())
+	}
+	if logged() != 0 {
+		t.Errorf("expected a dry run to skip AfterCreate; got %d rows", logged())
+	}
+	if res := doImport("/crud-note/import", 10); res.Code != http.StatusCreated || logged() != 10 {
+		t.Errorf("expected AfterCreate to run for each row; got %d %d", res.Code, logged())
+	}
+	if res := doImport("/crud-note/import", 100); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected an import over MaxImportSize to be rejected; got %d %s", res.Code, res.Body.String())
+	}
+}
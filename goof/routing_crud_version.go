@@ -0,0 +1,124 @@
+package goof
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/wyattis/goof/sql/driver"
+)
+
+// Decodes the new values of a row from the existing row. Returns the JSON names of the fields in the payload and
+// which fields should be written.
+type crudDecoder[T any] func(existing T) (item T, present map[string]bool, include func(field) bool, err error)
+
+// The field of the version column if the controller uses one
+func (c *crud[T]) versionField() (field, bool) {
+	if c.opts.VersionColumn == "" {
+		return field{}, false
+	}
+	return c.fieldByColumn(c.opts.VersionColumn)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Check if the field is an integer version which is incremented by every write
+func (c *crud[T]) counted(f field) bool {
+	return f.dbName == c.opts.VersionColumn && isInteger(f.typ)
+}
+
+func isInteger(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// The condition matching rows which still have the current version. SQLite stores times as text in whichever format
+// wrote them so they are compared as julian days, which keep milliseconds.
+func (c *crud[T]) versionCondition(f field, current any) (string, any) {
+	if f.typ == timeType && c.opts.Driver == driver.TypeSqlite3 {
+		return fmt.Sprintf("julianday(%s) = julianday(?)", f.column), current
+	}
+	return f.column + " = ?", current
+}
+
+// The assignment changing the version of a row written by a request. Times come from the server rather than the
+// database since CURRENT_TIMESTAMP only has seconds on SQLite and two writes within a second would share a version.
+func (c *crud[T]) nextVersion(f field, current any) (string, []any) {
+	if !c.counted(f) {
+		previous, _ := current.(time.Time)
+		next := time.Now().UTC().Truncate(time.Millisecond)
+		if !next.After(previous) {
+			next = previous.UTC().Truncate(time.Millisecond).Add(time.Millisecond)
+		}
+		return f.column + " = ?", []any{next}
+	}
+	return f.column + " = " + f.column + " + 1", nil
+}
+
+// The ETag of a row, which changes whenever its version does. Empty if the controller has no version column.
+func (c *crud[T]) etag(item T) string {
+	f, ok := c.versionField()
+	if !ok {
+		return ""
+	}
+	return versionTag(reflect.ValueOf(item).FieldByIndex(f.index).Interface())
+}
+
+// Set the ETag header to the version of a row
+func (c *crud[T]) setETag(w http.ResponseWriter, item T) {
+	if etag := c.etag(item); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+// Check the version a write expects against the current row. The expected version comes from If-Match or, when the
+// header is missing, from the version field of the payload, which is invalid if the payload doesn't include it.
+func (c *crud[T]) checkVersion(ifMatch string, existing T, expected reflect.Value) error {
+	current := c.etag(existing)
+	if current == "" {
+		return nil
+	}
+	if ifMatch != "" {
+		// If-Match uses the strong comparison so weak tags never match
+		for _, m := range strings.Split(ifMatch, ",") {
+			if m = strings.TrimSpace(m); m == "*" || m == current {
+				return nil
+			}
+		}
+		return c.staleVersion()
+	}
+	if !expected.IsValid() {
+		f, _ := c.versionField()
+		return NewHTTPError(http.StatusPreconditionRequired, fmt.Sprintf("writing a %s requires an If-Match header or the %s field", c.opts.PathName, f.jsonName)).
+			WithCode("version_required")
+	}
+	if versionTag(expected.Interface()) != current {
+		return c.staleVersion()
+	}
+	return nil
+}
+
+func (c *crud[T]) staleVersion() error {
+	return NewHTTPError(http.StatusPreconditionFailed, fmt.Sprintf("the %s has changed since it was read", c.opts.PathName)).
+		WithCode("version_mismatch")
+}
+
+// Format a version as an ETag. Times are compared in UTC so a version read back from JSON matches the stored one.
+func versionTag(v any) string {
+	switch t := v.(type) {
+	case time.Time:
+		v = t.UTC().Format(time.RFC3339Nano)
+	case interface{ UTC() time.Time }:
+		v = t.UTC().Format(time.RFC3339Nano)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
@@ -0,0 +1,272 @@
+package goof
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handles a streaming route. Call send for each event. send returns an error once the client has disconnected.
+type StreamHandler[Req any, Event any] func(c *gin.Context, req Req, send func(Event) error) error
+
+// Server-Sent Events can implement these interfaces to set the id and event fields of the event. The id is sent back
+// by the client in the Last-Event-ID header when it reconnects.
+type SSEIdentifier interface {
+	EventId() string
+}
+
+type SSENamer interface {
+	EventName() string
+}
+
+// The interval between heartbeats on Server-Sent Event streams
+var DefaultHeartbeat = 15 * time.Second
+
+func init() {
+	RegisterFormat(Format{
+		Name:      "sse",
+		MimeTypes: []string{"text/event-stream"},
+		Render: func(c *gin.Context, status int, obj any) error {
+			c.Status(status)
+			setStreamHeaders(c, "text/event-stream")
+			return writeSSE(c.Writer, obj)
+		},
+	})
+	RegisterFormat(Format{
+		Name:      "ndjson",
+		MimeTypes: []string{"application/x-ndjson"},
+		Render: func(c *gin.Context, status int, obj any) error {
+			c.Status(status)
+			setStreamHeaders(c, "application/x-ndjson")
+			return writeNDJSON(c.Writer, obj)
+		},
+	})
+}
+
+// Get the id of the last event the client received. Browsers send it in the Last-Event-ID header when reconnecting
+// and the lastEventId query parameter is supported for clients that can't set headers.
+func LastEventId(c *gin.Context) string {
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		return id
+	}
+	return c.Query("lastEventId")
+}
+
+type streamOptions struct {
+	heartbeat time.Duration
+	retry     time.Duration
+}
+
+type sseBuilder[Req any, Event any] struct {
+	*routeBuilder[Req, Event]
+	options streamOptions
+}
+
+// Stream Server-Sent Events of the given type from a GET route. Events are encoded as JSON. A comment is sent as a
+// heartbeat when no events have been sent for the heartbeat interval so that proxies don't close the connection.
+func SSE[Req any, Event any](pattern string, handler StreamHandler[Req, Event]) *sseBuilder[Req, Event] {
+	r := newRoute[Req, Event](pattern, []string{"sse"}, nil)
+	r.method = http.MethodGet
+	b := &sseBuilder[Req, Event]{
+		routeBuilder: &routeBuilder[Req, Event]{route: r},
+		options:      streamOptions{heartbeat: DefaultHeartbeat},
+	}
+	r.handler = streamHandler(r, "text/event-stream", &b.options, func(w io.Writer, event Event) error {
+		return writeSSE(w, event)
+	}, handler)
+	return b
+}
+
+// Set the heartbeat interval. Use 0 to disable heartbeats.
+func (b *sseBuilder[Req, Event]) Heartbeat(interval time.Duration) *sseBuilder[Req, Event] {
+	b.options.heartbeat = interval
+	return b
+}
+
+// Set the reconnection delay clients should use after losing the connection
+func (b *sseBuilder[Req, Event]) Retry(delay time.Duration) *sseBuilder[Req, Event] {
+	b.options.retry = delay
+	return b
+}
+
+// Stream newline delimited JSON of the given type. Useful for large exports since items are written as they're
+// produced.
+func NDJSON[Req any, Item any](pattern string, handler StreamHandler[Req, Item]) *routeBuilder[Req, Item] {
+	r := newRoute[Req, Item](pattern, []string{"ndjson"}, nil)
+	r.handler = streamHandler(r, "application/x-ndjson", &streamOptions{}, func(w io.Writer, item Item) error {
+		return writeNDJSON(w, item)
+	}, handler)
+	return &routeBuilder[Req, Item]{route: r}
+}
+
+func streamHandler[Req any, Event any](r *route[Req, Event], contentType string, options *streamOptions, write func(io.Writer, Event) error, handler StreamHandler[Req, Event]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := r.negotiate(c); err != nil {
+			abortWithError(c, http.StatusNotAcceptable, err)
+			return
+		}
+		var payload Req
+		if err := r.bind(c, &payload); err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		s := &stream{c: c, contentType: contentType, retry: options.retry}
+		stop := s.startHeartbeat(options.heartbeat, contentType == "text/event-stream")
+		err := handler(c, payload, func(e Event) error {
+			return s.write(func(w io.Writer) error {
+				return write(w, e)
+			})
+		})
+		stop()
+		if err == nil {
+			return
+		}
+		if !s.started {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.Error(err)
+		if contentType == "text/event-stream" && c.Request.Context().Err() == nil {
+			// the status has already been sent so the problem is sent as the last event instead
+			p := NewProblem(toHTTPError(0, err), isProduction(c))
+			s.write(func(w io.Writer) error {
+				return writeSSE(w, sseError{p})
+			})
+		}
+	}
+}
+
+type sseError struct {
+	Problem
+}
+
+func (e sseError) EventName() string {
+	return "error"
+}
+
+// Serializes writes to a streaming response
+type stream struct {
+	mu          sync.Mutex
+	c           *gin.Context
+	contentType string
+	retry       time.Duration
+	started     bool
+	lastWrite   time.Time
+}
+
+func (s *stream) write(fn func(w io.Writer) error) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = s.c.Request.Context().Err(); err != nil {
+		return
+	}
+	if !s.started {
+		s.started = true
+		s.c.Status(http.StatusOK)
+		setStreamHeaders(s.c, s.contentType)
+		if s.retry > 0 && s.contentType == "text/event-stream" {
+			if _, err = fmt.Fprintf(s.c.Writer, "retry: %d\n\n", s.retry.Milliseconds()); err != nil {
+				return
+			}
+		}
+	}
+	if err = fn(s.c.Writer); err != nil {
+		return
+	}
+	s.lastWrite = time.Now()
+	s.c.Writer.Flush()
+	return
+}
+
+// Send a comment whenever the stream has been idle for the given interval. Only SSE streams have heartbeats since
+// other formats don't support comments. stop waits for the heartbeat to exit so nothing is written after it returns.
+func (s *stream) startHeartbeat(interval time.Duration, enabled bool) (stop func()) {
+	if !enabled || interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(exited)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-s.c.Request.Context().Done():
+				return
+			case <-ticker.C:
+				s.mu.Lock()
+				idle := time.Since(s.lastWrite) >= interval
+				s.mu.Unlock()
+				if idle {
+					s.write(func(w io.Writer) error {
+						_, err := io.WriteString(w, ": heartbeat\n\n")
+						return err
+					})
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}
+}
+
+func setStreamHeaders(c *gin.Context, contentType string) {
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// disable response buffering in nginx
+	c.Header("X-Accel-Buffering", "no")
+}
+
+// Write a single Server-Sent Event
+func writeSSE(w io.Writer, event any) (err error) {
+	buf := bytes.Buffer{}
+	if e, ok := event.(SSEIdentifier); ok {
+		if id := e.EventId(); id != "" {
+			fmt.Fprintf(&buf, "id: %s\n", sanitizeSSEField(id))
+		}
+	}
+	if e, ok := event.(SSENamer); ok {
+		if name := e.EventName(); name != "" {
+			fmt.Fprintf(&buf, "event: %s\n", sanitizeSSEField(name))
+		}
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// Write a single line of newline delimited JSON
+func writeNDJSON(w io.Writer, item any) (err error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(append(data, '\n'))
+	return
+}
+
+// Field values can't contain newlines since they would be interpreted as new fields
+func sanitizeSSEField(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
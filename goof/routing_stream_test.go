@@ -0,0 +1,159 @@
+package goof
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type progressEvent struct {
+	Id      string `json:"-"`
+	Percent int    `json:"percent"`
+}
+
+func (e progressEvent) EventId() string {
+	return e.Id
+}
+
+func (e progressEvent) EventName() string {
+	return "progress"
+}
+
+type streamRequest struct {
+	Count int `form:"count"`
+}
+
+func TestSSE(t *testing.T) {
+	var lastId string
+	r := problemEngine(false, SSE("/progress", func(c *gin.Context, req streamRequest, send func(progressEvent) error) error {
+		lastId = LastEventId(c)
+		for i := 1; i <= req.Count; i++ {
+			if err := send(progressEvent{Id: string(rune('0' + i)), Percent: i * 50}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Retry(time.Second))
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/progress?count=2", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", "4")
+	r.ServeHTTP(res, req)
+
+	expected := "retry: 1000\n\nid: 1\nevent: progress\ndata: {\"percent\":50}\n\nid: 2\nevent: progress\ndata: {\"percent\":100}\n\n"
+	if res.Body.String() != expected {
+		t.Errorf("expected %q; got %q", expected, res.Body.String())
+	}
+	if res.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("expected event stream content type; got %s", res.Header().Get("Content-Type"))
+	}
+	if lastId != "4" {
+		t.Errorf("expected last event id 4; got %q", lastId)
+	}
+}
+
+func TestSSEHeartbeatAndDisconnect(t *testing.T) {
+	sendErr := make(chan error, 1)
+	r := problemEngine(false, SSE("/", func(c *gin.Context, req struct{}, send func(progressEvent) error) error {
+		for {
+			if err := send(progressEvent{Percent: 1}); err != nil {
+				sendErr <- err
+				return err
+			}
+			time.Sleep(30 * time.Millisecond)
+		}
+	}).Heartbeat(10*time.Millisecond))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	res, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 0, 1024)
+	for !strings.Contains(string(buf), ": heartbeat") {
+		chunk := make([]byte, 256)
+		n, err := res.Body.Read(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(buf, chunk[:n]...)
+	}
+	cancel()
+	res.Body.Close()
+
+	select {
+	case err := <-sendErr:
+		if err == nil {
+			t.Error("expected send to fail after the client disconnected")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("expected the handler to stop after the client disconnected")
+	}
+}
+
+// Run with -race to check that heartbeats stop before the handler returns
+func TestSSEHeartbeatStopsWithHandler(t *testing.T) {
+	r := problemEngine(false, SSE("/", func(c *gin.Context, req struct{}, send func(progressEvent) error) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}).Heartbeat(time.Millisecond))
+	for i := 0; i < 20; i++ {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		body := res.Body.String()
+		time.Sleep(2 * time.Millisecond)
+		if res.Body.String() != body {
+			t.Fatalf("expected nothing to be written after the handler returned; got %q", res.Body.String())
+		}
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	r := problemEngine(false, NDJSON("/export", func(c *gin.Context, req streamRequest, send func(formatItem) error) error {
+		if req.Count == 0 {
+			return NewHTTPError(http.StatusBadRequest, "count is required")
+		}
+		for i := 1; i <= req.Count; i++ {
+			if err := send(formatItem{Id: i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Get())
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/export?count=2", nil))
+	body, _ := io.ReadAll(res.Body)
+	expected := "{\"id\":1,\"name\":\"\"}\n{\"id\":2,\"name\":\"\"}\n"
+	if string(body) != expected {
+		t.Errorf("expected %q; got %q", expected, string(body))
+	}
+
+	p, res := doProblem(t, r, http.MethodGet, "/export", "")
+	if res.Code != http.StatusBadRequest || p.Detail != "count is required" {
+		t.Errorf("expected errors before the first item to be rendered as a problem; got %d %+v", res.Code, p)
+	}
+}
+
+func TestStreamRouteMetadata(t *testing.T) {
+	route := SSE("/", func(c *gin.Context, req struct{}, send func(progressEvent) error) error {
+		return errors.New("unused")
+	}).Get().route
+	if produces := route.Produces(); len(produces) != 1 || produces[0] != "text/event-stream" {
+		t.Errorf("expected the route to produce text/event-stream; got %v", produces)
+	}
+	if _, ok := route.ResponseType().(progressEvent); !ok {
+		t.Errorf("expected the response type to be the event type; got %T", route.ResponseType())
+	}
+}
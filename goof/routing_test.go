@@ -1,6 +1,10 @@
 package goof
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 )
@@ -8,6 +12,29 @@ import (
 func init() {
 	var _ Routable = CRUD(&sqlx.DB{}, struct{}{}, nil)
 	var _ Routable = Json("", func(_ *gin.Context, _ struct{}) (r struct{}, s int, e error) { return })
+	var _ Routable = Handler("", func(_ *gin.Context, _ struct{}) (r struct{}, s int, e error) { return })
 	var _ Routable = ToJson("", func(_ *gin.Context) (r struct{}, s int, e error) { return })
 	var _ Routable = FromJson("", func(_ *gin.Context, _ struct{}) (s int, e error) { return })
 }
+
+func TestRouteGinUsesArePerRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	count := func(c *gin.Context) (res int, status int, err error) {
+		return 1, 0, nil
+	}
+	tag := func(c *gin.Context) {
+		c.Header("X-Tagged", "true")
+	}
+	RouteGin(r,
+		ToJson("/tagged", count).Get().Use(tag),
+		ToJson("/plain", count).Get(),
+	)
+	for path, expected := range map[string]string{"/tagged": "true", "/plain": ""} {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		if res.Code != http.StatusOK || res.Header().Get("X-Tagged") != expected {
+			t.Errorf("expected %s to have X-Tagged %q; got %d %v", path, expected, res.Code, res.Header())
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package goof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wyattis/goof/http/middleware"
+)
+
+// Handles an uploaded file after it has been stored. The file is removed again if an error is returned.
+type UploadHandler[Res any] func(c *gin.Context, file File) (Res, int, error)
+
+type UploadConfig struct {
+	// Maximum size of the file in bytes
+	MaxSize int64
+	// Allowed MIME types of the file such as "image/png" or "image/*". Any type is allowed if this is empty. The type
+	// is sniffed from the contents of the file instead of trusting the Content-Type sent by the client.
+	AllowTypes []string
+	// Name of the multipart form field containing the file
+	FormField string
+}
+
+var DefaultUploadConfig = UploadConfig{
+	MaxSize:   32 << 20,
+	FormField: "file",
+}
+
+// Extra room allowed for multipart boundaries and other form fields on top of UploadConfig.MaxSize
+const multipartOverhead = 1 << 20
+
+var errFileTooLarge = errors.New("file is too large")
+
+type fileRequest struct {
+	Id string `uri:"id" binding:"required"`
+}
+
+type uploadBuilder[Res any] struct {
+	*routeBuilder[File, Res]
+	config UploadConfig
+}
+
+type downloadBuilder struct {
+	*routeBuilder[fileRequest, any]
+	inline bool
+}
+
+// Accepts a file as multipart/form-data or as the raw request body and streams it to files. Raw uploads are named
+// using the filename of the Content-Disposition header or the name query parameter.
+func Upload[Res any](pattern string, files *FileStore, handler UploadHandler[Res]) *uploadBuilder[Res] {
+	r := newRoute[File, Res](pattern, DefaultResponseFormats, nil)
+	r.method = http.MethodPost
+	b := &uploadBuilder[Res]{routeBuilder: &routeBuilder[File, Res]{route: r}, config: DefaultUploadConfig}
+	r.handler = func(c *gin.Context) {
+		middleware.SetBodyLimit(c, b.config.MaxSize+multipartOverhead)
+		format, err := r.negotiate(c)
+		if err != nil {
+			abortWithError(c, http.StatusNotAcceptable, err)
+			return
+		}
+		// problems with the request are HTTPErrors so anything else is a storage or database error
+		file, err := receiveFile(c, files, b.config)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		response, status, err := handler(c, file)
+		if err != nil {
+			if err := files.Delete(c, file.Id); err != nil {
+				c.Error(err)
+			}
+			abortWithError(c, status, err)
+			return
+		}
+		if status == 0 {
+			status = http.StatusCreated
+		}
+		r.render(c, format, status, response)
+	}
+	return b
+}
+
+// Configure the size and type limits of the route. Zero values are taken from DefaultUploadConfig.
+func (b *uploadBuilder[Res]) UploadConfig(config UploadConfig) *uploadBuilder[Res] {
+	if config.MaxSize == 0 {
+		config.MaxSize = DefaultUploadConfig.MaxSize
+	}
+	if config.FormField == "" {
+		config.FormField = DefaultUploadConfig.FormField
+	}
+	b.config = config
+	return b
+}
+
+// Serves a file stored in files using the id path parameter. Range and conditional requests are supported. Files
+// are sent as attachments unless Inline is used.
+func Download(pattern string, files *FileStore) *downloadBuilder {
+	r := newRoute[fileRequest, any](pattern, nil, nil)
+	r.method = http.MethodGet
+	b := &downloadBuilder{routeBuilder: &routeBuilder[fileRequest, any]{route: r}}
+	r.handler = func(c *gin.Context) {
+		var req fileRequest
+		if err := r.bind(c, &req); err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		file, content, err := files.Open(c, req.Id)
+		if errors.Is(err, ErrFileNotFound) {
+			abortWithError(c, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+		defer content.Close()
+
+		disposition := "attachment"
+		if b.inline {
+			disposition = "inline"
+		}
+		c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.Name}))
+		c.Header("Content-Type", file.ContentType)
+		c.Header("ETag", fmt.Sprintf(`"%s"`, file.Checksum))
+		c.Header("X-Content-Type-Options", "nosniff")
+		http.ServeContent(c.Writer, c.Request, file.Name, file.CreatedAt.Time, content)
+	}
+	return b
+}
+
+// Let browsers display the files instead of saving them
+func (b *downloadBuilder) Inline() *downloadBuilder {
+	b.inline = true
+	return b
+}
+
+// Read the file from the request and save it to files. Errors caused by the request are returned as HTTPErrors.
+func receiveFile(c *gin.Context, files *FileStore, config UploadConfig) (file File, err error) {
+	var name, declared string
+	var body io.Reader
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	if mediaType == "multipart/form-data" {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxSize+multipartOverhead)
+		var part *multipart.Part
+		if part, err = filePart(c, config.FormField); err != nil {
+			return file, uploadError(err)
+		}
+		defer part.Close()
+		name, declared, body = part.FileName(), part.Header.Get("Content-Type"), part
+	} else {
+		if c.Request.ContentLength > config.MaxSize {
+			err = NewHTTPError(http.StatusRequestEntityTooLarge, errFileTooLarge.Error())
+			return
+		}
+		name, declared, body = c.Query("name"), mediaType, c.Request.Body
+		if _, params, err := mime.ParseMediaType(c.GetHeader("Content-Disposition")); err == nil && params["filename"] != "" {
+			name = params["filename"]
+		}
+	}
+
+	buffered := bufio.NewReaderSize(body, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		return file, uploadError(err)
+	}
+	contentType := resolveContentType(declared, http.DetectContentType(head))
+	if !typeAllowed(config.AllowTypes, contentType) {
+		err = NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("files of type %s are not allowed", contentType))
+		return
+	}
+	file, err = files.Save(c, name, contentType, &requestReader{&maxSizeReader{r: buffered, remaining: config.MaxSize}})
+	var reqErr requestReadError
+	if errors.As(err, &reqErr) {
+		return file, uploadError(reqErr.err)
+	}
+	return
+}
+
+// Find the part containing the file. Other form fields are skipped.
+func filePart(c *gin.Context, field string) (*multipart.Part, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, NewHTTPError(http.StatusBadRequest, fmt.Sprintf("missing file field %s", field))
+		} else if err != nil {
+			return nil, uploadError(err)
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// Map errors reading the request to 413 when caused by the size limits and otherwise to 400
+func uploadError(err error) error {
+	var httpErr *HTTPError
+	switch {
+	case err == nil || errors.As(err, &httpErr):
+		return err
+	case errors.Is(err, errFileTooLarge) || middleware.IsBodyTooLarge(err):
+		return NewHTTPError(http.StatusRequestEntityTooLarge, errFileTooLarge.Error())
+	default:
+		return WrapHTTPError(http.StatusBadRequest, err)
+	}
+}
+
+// Marks errors from reading the request so they can be told apart from storage errors
+type requestReader struct {
+	r io.Reader
+}
+
+type requestReadError struct {
+	err error
+}
+
+func (e requestReadError) Error() string {
+	return e.err.Error()
+}
+
+func (e requestReadError) Unwrap() error {
+	return e.err
+}
+
+func (r *requestReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = requestReadError{err}
+	}
+	return
+}
+
+// The sniffed type is used unless the contents only look like plain text or binary data, in which case a more
+// specific type declared by the client is kept if it is in the same family. This keeps types like text/csv and
+// application/json without allowing a client to pass off an executable as an image.
+func resolveContentType(declared, sniffed string) string {
+	declared = strings.ToLower(strings.TrimSpace(strings.SplitN(declared, ";", 2)[0]))
+	if declared == "" {
+		return sniffed
+	}
+	switch {
+	case strings.HasPrefix(sniffed, "text/plain"):
+		if strings.HasPrefix(declared, "text/") || isTextType(declared) {
+			return declared
+		}
+	case sniffed == "application/octet-stream":
+		if strings.HasPrefix(declared, "application/") && !isTextType(declared) {
+			return declared
+		}
+	}
+	return sniffed
+}
+
+func isTextType(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/xml", "application/x-ndjson", "application/yaml", "application/x-yaml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// Check if contentType matches any of the allowed types. Wildcards like image/* are supported.
+func typeAllowed(allowed []string, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType := strings.SplitN(contentType, ";", 2)[0]
+	for _, a := range allowed {
+		if a == mediaType || a == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(a, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns errFileTooLarge once more than remaining bytes have been read
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (n int, err error) {
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err = m.r.Read(p)
+	if int64(n) > m.remaining {
+		return int(m.remaining), errFileTooLarge
+	}
+	m.remaining -= int64(n)
+	return
+}
@@ -0,0 +1,142 @@
+package goof
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n0000000000000000")
+
+func uploadEngine(t *testing.T, config UploadConfig) *gin.Engine {
+	db := testDB(t, FilesMigration())
+	files := NewFileStore(db, NewDBStorage(db))
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorHandler(false))
+	RouteGin(r,
+		Upload("/files", files, func(c *gin.Context, f File) (File, int, error) {
+			return f, 0, nil
+		}).UploadConfig(config),
+		Download("/files/:id", files),
+	)
+	return r
+}
+
+func multipartBody(t *testing.T, field, name string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("description", "ignored")
+	part, err := w.CreateFormFile(field, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	w.Close()
+	return body, w.FormDataContentType()
+}
+
+func doUpload(r *gin.Engine, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/files", body)
+	req.Header.Set("Content-Type", contentType)
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestUploadMultipart(t *testing.T) {
+	r := uploadEngine(t, UploadConfig{MaxSize: 1024, AllowTypes: []string{"image/*"}})
+	body, contentType := multipartBody(t, "file", "pixel.png", pngHeader)
+	res := doUpload(r, body, contentType)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected 201; got %d %s", res.Code, res.Body)
+	}
+	var f File
+	if err := json.Unmarshal(res.Body.Bytes(), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "pixel.png" || f.ContentType != "image/png" || f.Size != int64(len(pngHeader)) {
+		t.Errorf("unexpected file %+v", f)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/files/"+f.Id, nil)
+	req.Header.Set("Range", "bytes=1-3")
+	dl := httptest.NewRecorder()
+	r.ServeHTTP(dl, req)
+	if dl.Code != http.StatusPartialContent || dl.Body.String() != "PNG" {
+		t.Errorf("expected a partial response; got %d %q", dl.Code, dl.Body)
+	}
+	if cd := dl.Header().Get("Content-Disposition"); cd != `attachment; filename=pixel.png` {
+		t.Errorf("unexpected Content-Disposition %q", cd)
+	}
+}
+
+func TestUploadSniffsType(t *testing.T) {
+	r := uploadEngine(t, UploadConfig{MaxSize: 1024, AllowTypes: []string{"image/png"}})
+	body, contentType := multipartBody(t, "file", "fake.png", []byte("MZ not really a png"))
+	if res := doUpload(r, body, contentType); res.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415; got %d %s", res.Code, res.Body)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	r := uploadEngine(t, UploadConfig{MaxSize: 8})
+	body, contentType := multipartBody(t, "file", "big.txt", []byte("more than eight bytes"))
+	if res := doUpload(r, body, contentType); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for multipart; got %d %s", res.Code, res.Body)
+	}
+	if res := doUpload(r, strings.NewReader("more than eight bytes"), "text/plain"); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for raw body; got %d %s", res.Code, res.Body)
+	}
+	body, contentType = multipartBody(t, "other", "small.txt", []byte("small"))
+	if res := doUpload(r, body, contentType); res.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a missing file field; got %d %s", res.Code, res.Body)
+	}
+}
+
+func TestUploadStorageErrors(t *testing.T) {
+	db := testDB(t, FilesMigration())
+	files := NewFileStore(db, NewDBStorage(db))
+	r := problemEngine(false, Upload("/files", files, func(c *gin.Context, f File) (File, int, error) {
+		return f, 0, nil
+	}))
+	db.MustExec("DROP TABLE goof_file_chunks")
+	body, contentType := multipartBody(t, "file", "pixel.png", pngHeader)
+	if res := doUpload(r, body, contentType); res.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 when the file can't be stored; got %d %s", res.Code, res.Body)
+	}
+}
+
+func TestUploadRaw(t *testing.T) {
+	r := uploadEngine(t, UploadConfig{})
+	req := httptest.NewRequest(http.MethodPost, "/files", strings.NewReader("a,b\n1,2\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("Content-Disposition", `attachment; filename="data.csv"`)
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected 201; got %d %s", res.Code, res.Body)
+	}
+	var f File
+	json.Unmarshal(res.Body.Bytes(), &f)
+	if f.Name != "data.csv" || f.ContentType != "text/csv" {
+		t.Errorf("unexpected file %+v", f)
+	}
+	dl := httptest.NewRecorder()
+	r.ServeHTTP(dl, httptest.NewRequest(http.MethodGet, "/files/"+f.Id, nil))
+	if dl.Body.String() != "a,b\n1,2\n" || dl.Header().Get("ETag") == "" {
+		t.Errorf("unexpected download %d %q", dl.Code, dl.Body)
+	}
+	missing := httptest.NewRecorder()
+	r.ServeHTTP(missing, httptest.NewRequest(http.MethodGet, "/files/nope", nil))
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing file; got %d", missing.Code)
+	}
+}
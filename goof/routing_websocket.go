@@ -0,0 +1,291 @@
+package goof
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/wyattis/goof/http/middleware"
+)
+
+var (
+	ErrWebSocketClosed = errors.New("websocket connection is closed")
+	ErrSendBufferFull  = errors.New("websocket send buffer is full")
+)
+
+// Handles a websocket connection. The connection is closed once the handler returns.
+type WebSocketHandler[In any, Out any] func(c *gin.Context, conn *WebSocketConn[In, Out]) error
+
+type WebSocketConfig struct {
+	// The number of outgoing messages which can be queued before Send blocks
+	SendBuffer int
+	// How often pings are sent to the client
+	PingInterval time.Duration
+	// How long to wait for a pong or a message before the connection is considered dead. Must be longer than
+	// PingInterval.
+	PongWait time.Duration
+	// How long a single write may take
+	WriteWait time.Duration
+	// The maximum size in bytes of an incoming message
+	MaxMessageSize int64
+}
+
+var DefaultWebSocketConfig = WebSocketConfig{
+	SendBuffer:     16,
+	PingInterval:   30 * time.Second,
+	PongWait:       40 * time.Second,
+	WriteWait:      10 * time.Second,
+	MaxMessageSize: 1 << 20,
+}
+
+type webSocketBuilder[In any, Out any] struct {
+	*routeBuilder[In, Out]
+	config WebSocketConfig
+}
+
+// Accept websocket connections which exchange JSON messages of the given types. The origin of the request is checked
+// against the CORS configuration when the CORS middleware is used. Otherwise only same origin requests are allowed.
+func WebSocket[In any, Out any](pattern string, handler WebSocketHandler[In, Out]) *webSocketBuilder[In, Out] {
+	r := newRoute[In, Out](pattern, nil, nil)
+	r.method = http.MethodGet
+	b := &webSocketBuilder[In, Out]{routeBuilder: &routeBuilder[In, Out]{route: r}, config: DefaultWebSocketConfig}
+	r.handler = func(c *gin.Context) {
+		config := b.config
+		upgrader := websocket.Upgrader{
+			CheckOrigin: func(req *http.Request) bool {
+				return checkOrigin(c, req)
+			},
+		}
+		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// the upgrader has already responded with an error
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		conn := newWebSocketConn[In, Out](ws, config)
+		err = handler(c, conn)
+		if err != nil {
+			c.Error(err)
+			conn.closeWith(websocket.CloseInternalServerErr, "")
+		} else {
+			conn.closeWith(websocket.CloseNormalClosure, "")
+		}
+		<-conn.writerDone
+	}
+	return b
+}
+
+// Configure the connections of the route
+func (b *webSocketBuilder[In, Out]) WebSocketConfig(config WebSocketConfig) *webSocketBuilder[In, Out] {
+	b.config = config
+	return b
+}
+
+func checkOrigin(c *gin.Context, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if v, ok := c.Get(middleware.CORSConfigKey); ok {
+		return v.(middleware.CORSConfig).AllowsOrigin(origin)
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+// A websocket connection exchanging JSON messages. Receive must only be called from a single goroutine while Send
+// and TrySend are safe to call concurrently.
+type WebSocketConn[In any, Out any] struct {
+	ws         *websocket.Conn
+	config     WebSocketConfig
+	send       chan Out
+	ctx        context.Context
+	cancel     context.CancelFunc
+	closeOnce  sync.Once
+	closeCode  int
+	closeText  string
+	writerDone chan struct{}
+}
+
+func newWebSocketConn[In any, Out any](ws *websocket.Conn, config WebSocketConfig) *WebSocketConn[In, Out] {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &WebSocketConn[In, Out]{
+		ws:         ws,
+		config:     config,
+		send:       make(chan Out, config.SendBuffer),
+		ctx:        ctx,
+		cancel:     cancel,
+		writerDone: make(chan struct{}),
+	}
+	ws.SetReadLimit(config.MaxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(config.PongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(config.PongWait))
+	})
+	trackWebSocket(conn)
+	go conn.writeLoop()
+	return conn
+}
+
+// Wait for the next message from the client. Messages which fail validation are returned along with the error so the
+// handler can decide whether to keep the connection open.
+func (c *WebSocketConn[In, Out]) Receive() (msg In, err error) {
+	_, r, err := c.ws.NextReader()
+	if err != nil {
+		// errors from the underlying connection are permanent
+		c.closeWith(websocket.CloseAbnormalClosure, "")
+		err = ErrWebSocketClosed
+		return
+	}
+	c.ws.SetReadDeadline(time.Now().Add(c.config.PongWait))
+	if err = json.NewDecoder(r).Decode(&msg); err != nil {
+		return
+	}
+	err = Validate(&msg)
+	return
+}
+
+// Queue a message for the client. Blocks while the send buffer is full until there is room or the connection closes.
+func (c *WebSocketConn[In, Out]) Send(msg Out) error {
+	select {
+	case <-c.ctx.Done():
+		return ErrWebSocketClosed
+	default:
+	}
+	select {
+	case c.send <- msg:
+		return nil
+	case <-c.ctx.Done():
+		return ErrWebSocketClosed
+	}
+}
+
+// Queue a message for the client without blocking. Returns ErrSendBufferFull if the client isn't keeping up.
+func (c *WebSocketConn[In, Out]) TrySend(msg Out) error {
+	select {
+	case <-c.ctx.Done():
+		return ErrWebSocketClosed
+	default:
+	}
+	select {
+	case c.send <- msg:
+		return nil
+	default:
+		return ErrSendBufferFull
+	}
+}
+
+// Closed once the connection has been closed
+func (c *WebSocketConn[In, Out]) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Close the connection after sending any queued messages
+func (c *WebSocketConn[In, Out]) Close() error {
+	c.closeWith(websocket.CloseNormalClosure, "")
+	return nil
+}
+
+func (c *WebSocketConn[In, Out]) closeWith(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		c.cancel()
+	})
+}
+
+// Owns all writes to the underlying connection. Queued messages are flushed before the close frame is sent.
+func (c *WebSocketConn[In, Out]) writeLoop() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		untrackWebSocket(c)
+		c.ws.Close()
+		close(c.writerDone)
+	}()
+	write := func(msg Out) error {
+		c.ws.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+		return c.ws.WriteJSON(msg)
+	}
+	for {
+		select {
+		case msg := <-c.send:
+			if err := write(msg); err != nil {
+				c.closeWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			deadline := time.Now().Add(c.config.WriteWait)
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				c.closeWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.ctx.Done():
+			if !c.drain(write) {
+				return
+			}
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				msg := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+				c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.config.WriteWait))
+			}
+			return
+		}
+	}
+}
+
+// Write any queued messages. Returns false if a write failed.
+func (c *WebSocketConn[In, Out]) drain(write func(Out) error) bool {
+	for {
+		select {
+		case msg := <-c.send:
+			if err := write(msg); err != nil {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+type trackedWebSocket interface {
+	closeWith(code int, text string)
+}
+
+var (
+	webSocketsMu sync.Mutex
+	webSockets   = map[trackedWebSocket]struct{}{}
+)
+
+func trackWebSocket(c trackedWebSocket) {
+	webSocketsMu.Lock()
+	defer webSocketsMu.Unlock()
+	webSockets[c] = struct{}{}
+}
+
+func untrackWebSocket(c trackedWebSocket) {
+	webSocketsMu.Lock()
+	defer webSocketsMu.Unlock()
+	delete(webSockets, c)
+}
+
+// Tell every open websocket connection that the server is going away. This is registered with http.Server.Shutdown
+// by RootModule since hijacked connections aren't closed by the server.
+func CloseWebSockets() {
+	webSocketsMu.Lock()
+	defer webSocketsMu.Unlock()
+	for c := range webSockets {
+		c.closeWith(websocket.CloseGoingAway, "server shutting down")
+	}
+}
@@ -0,0 +1,136 @@
+package goof
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/wyattis/goof/http/middleware"
+)
+
+type chatIn struct {
+	Room string `json:"room" binding:"required"`
+	Text string `json:"text"`
+}
+
+type chatOut struct {
+	Text  string `json:"text,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func chatServer(t *testing.T, hub *Hub[chatOut], use ...gin.HandlerFunc) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(use...)
+	RouteGin(r, WebSocket("/chat", func(c *gin.Context, conn *WebSocketConn[chatIn, chatOut]) error {
+		for {
+			msg, err := conn.Receive()
+			if err == ErrWebSocketClosed {
+				return nil
+			} else if err != nil {
+				if err := conn.Send(chatOut{Error: err.Error()}); err != nil {
+					return nil
+				}
+				continue
+			}
+			hub.Join(msg.Room, conn)
+			hub.Broadcast(msg.Room, chatOut{Text: msg.Text})
+		}
+	}))
+	s := httptest.NewServer(r)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func dialChat(t *testing.T, s *httptest.Server, header http.Header) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/chat"
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
+
+func TestWebSocketBroadcast(t *testing.T) {
+	hub := NewHub[chatOut]()
+	s := chatServer(t, hub)
+	a := dialChat(t, s, nil)
+	b := dialChat(t, s, nil)
+
+	var out chatOut
+	// a sees its own join and then b's join
+	for _, step := range []struct {
+		sender  *websocket.Conn
+		readers []*websocket.Conn
+	}{{a, []*websocket.Conn{a}}, {b, []*websocket.Conn{a, b}}} {
+		if err := step.sender.WriteJSON(chatIn{Room: "general", Text: "joined"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, conn := range step.readers {
+			if err := conn.ReadJSON(&out); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := a.WriteJSON(chatIn{Room: "general", Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{a, b} {
+		if err := conn.ReadJSON(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Text != "hello" {
+			t.Errorf("expected broadcast message; got %+v", out)
+		}
+	}
+}
+
+func TestWebSocketValidation(t *testing.T) {
+	s := chatServer(t, NewHub[chatOut]())
+	conn := dialChat(t, s, nil)
+	if err := conn.WriteJSON(chatIn{Text: "no room"}); err != nil {
+		t.Fatal(err)
+	}
+	var out chatOut
+	if err := conn.ReadJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.Error, "room") {
+		t.Errorf("expected a validation error for room; got %+v", out)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	s := chatServer(t, NewHub[chatOut](), middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: []string{"https://allowed.example"},
+	}))
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/chat"
+	_, res, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}})
+	if err == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a disallowed origin to be rejected")
+	}
+	dialChat(t, s, http.Header{"Origin": {"https://allowed.example"}})
+}
+
+func TestCloseWebSockets(t *testing.T) {
+	s := chatServer(t, NewHub[chatOut]())
+	conn := dialChat(t, s, nil)
+	// make sure the connection has been registered before closing
+	conn.WriteJSON(chatIn{Room: "general"})
+	var out chatOut
+	if err := conn.ReadJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	CloseWebSockets()
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close frame; got %v", err)
+	}
+}
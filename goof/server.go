@@ -0,0 +1,145 @@
+package goof
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultMaxBodyBytes      = 10 << 20
+	defaultUnixSocketMode    = 0660
+
+	// The first file descriptor passed by systemd socket activation
+	listenFdsStart = 3
+)
+
+func (r *RootModule) newServer() *http.Server {
+	config := r.Config.Http
+	server := &http.Server{
+		Addr:              config.Addr,
+		Handler:           r.engine.Handler(),
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+	if server.ReadHeaderTimeout == 0 {
+		server.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if server.ReadTimeout == 0 {
+		server.ReadTimeout = defaultReadTimeout
+	}
+	if server.IdleTimeout == 0 {
+		server.IdleTimeout = defaultIdleTimeout
+	}
+	server.RegisterOnShutdown(CloseWebSockets)
+	return server
+}
+
+// Open every configured listener. Sockets passed in by systemd socket activation replace the configured addresses.
+func (r *RootModule) listeners() (listeners []net.Listener, err error) {
+	if listeners, err = systemdListeners(); err != nil || len(listeners) > 0 {
+		return
+	}
+	defer func() {
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			listeners = nil
+		}
+	}()
+	config := r.Config.Http
+	addrs := config.Addrs
+	if config.Addr != "" || (len(addrs) == 0 && config.UnixSocket == "") {
+		addr := config.Addr
+		if addr == "" {
+			addr = ":http"
+			if config.SSL.Enabled {
+				addr = ":https"
+			}
+		}
+		addrs = append([]string{addr}, addrs...)
+	}
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return listeners, err
+		}
+		listeners = append(listeners, l)
+	}
+	if config.UnixSocket != "" {
+		l, err := listenUnix(config.UnixSocket, config.UnixSocketMode)
+		if err != nil {
+			return listeners, err
+		}
+		listeners = append(listeners, l)
+	}
+	return
+}
+
+// Listen on a Unix socket, replacing a stale socket file left behind by a previous run
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		mode = defaultUnixSocketMode
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Get the sockets passed in by systemd using the LISTEN_PID and LISTEN_FDS environment variables
+func systemdListeners() (listeners []net.Listener, err error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	// the sockets shouldn't be inherited by child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("fd %d is not a listening socket: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return
+}
+
+func (r *RootModule) serve(l net.Listener) error {
+	if !r.Config.Http.SSL.Enabled {
+		return r.server.Serve(l)
+	}
+	// the certificate comes from TLSConfig.GetCertificate so it can be reloaded
+	return r.server.ServeTLS(l, "", "")
+}
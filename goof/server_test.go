@@ -0,0 +1,108 @@
+package goof
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wyattis/goof/http/middleware"
+)
+
+func TestServerDefaults(t *testing.T) {
+	r := &RootModule{engine: gin.New()}
+	r.Config.Http.WriteTimeout = time.Minute
+	s := r.newServer()
+	if s.ReadHeaderTimeout != defaultReadHeaderTimeout || s.ReadTimeout != defaultReadTimeout ||
+		s.IdleTimeout != defaultIdleTimeout || s.WriteTimeout != time.Minute {
+		t.Errorf("unexpected timeouts %v %v %v %v", s.ReadHeaderTimeout, s.ReadTimeout, s.IdleTimeout, s.WriteTimeout)
+	}
+}
+
+func TestServerListeners(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	socket := filepath.Join(t.TempDir(), "goof.sock")
+	r := &RootModule{engine: gin.New()}
+	r.engine.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	r.Config.Http.Addr = "127.0.0.1:0"
+	r.Config.Http.Addrs = []string{"127.0.0.1:0"}
+	r.Config.Http.UnixSocket = socket
+	r.Config.Http.UnixSocketMode = 0600
+	r.server = r.newServer()
+
+	// a stale socket file from a previous run is replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, err := r.listeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 3 {
+		t.Fatalf("expected 3 listeners; got %d", len(listeners))
+	}
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the socket mode to be 0600; got %v", info.Mode().Perm())
+	}
+	for _, l := range listeners {
+		go r.serve(l)
+	}
+	defer r.server.Shutdown(context.Background())
+
+	for _, l := range listeners {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, l.Addr().Network(), l.Addr().String())
+			},
+		}}
+		res, err := client.Get("http://goof/ping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != "pong" {
+			t.Errorf("expected pong from %s; got %q", l.Addr(), body)
+		}
+	}
+}
+
+func TestSystemdListenersIgnoresOtherProcesses(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := systemdListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("expected no listeners for another process; got %v %v", listeners, err)
+	}
+}
+
+func TestBodyLimitProblem(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorHandler(false), middleware.BodyLimit(16))
+	RouteGin(r, Json("/", func(c *gin.Context, p payload) (payload, int, error) {
+		return p, 0, nil
+	}).Post())
+	p, res := doProblem(t, r, http.MethodPost, "/", `{"name":"`+strings.Repeat("a", 100)+`"}`)
+	if res.Code != http.StatusRequestEntityTooLarge || p.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413; got %d %+v", res.Code, p)
+	}
+}
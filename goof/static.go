@@ -0,0 +1,262 @@
+package goof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StaticConfig struct {
+	// URL prefix the files are served at. Defaults to "/".
+	Prefix string
+	// Files to serve in production such as an embed.FS. Use fs.Sub to serve a subdirectory of an embed.FS.
+	FS fs.FS
+	// Directory the files are read from on every request outside of production. This is usually the directory FS was
+	// embedded from. Dir is also used in production if FS is nil.
+	Dir string
+	// File served for directories and as the SPA fallback. Defaults to "index.html".
+	Index string
+	// Serve Index for unknown paths so that client side routes work
+	SPA bool
+	// Paths that never fall back to Index. Defaults to "/api".
+	ExcludePrefixes []string
+	// Matches file names that contain a content hash. These are cached by clients forever. Defaults to names with a
+	// hex hash of at least 8 characters such as app.3f2a9c1b.js or chunk-5d41402a.css. Set it for other hash styles,
+	// like the base64 hashes of index-B1x9_fQ2.css.
+	Hashed *regexp.Regexp
+}
+
+var defaultHashedName = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[A-Za-z0-9]+$`)
+
+// Precompressed variants that are served in place of a file when the client accepts the encoding
+var staticEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Serves static files and single page applications. Files with a .br or .gz variant next to them are served
+// compressed when the client supports it.
+type StaticModule struct {
+	BaseModule
+	Config StaticConfig
+
+	production bool
+	etags      sync.Map
+}
+
+func NewStaticModule(config StaticConfig) *StaticModule {
+	if config.Prefix == "" {
+		config.Prefix = "/"
+	}
+	config.Prefix = "/" + strings.Trim(config.Prefix, "/")
+	if config.Index == "" {
+		config.Index = "index.html"
+	}
+	if config.ExcludePrefixes == nil {
+		config.ExcludePrefixes = []string{"/api"}
+	}
+	if config.Hashed == nil {
+		config.Hashed = defaultHashedName
+	}
+	return &StaticModule{Config: config}
+}
+
+func (m *StaticModule) Id() string {
+	return "static:" + m.Config.Prefix
+}
+
+func (m *StaticModule) Init(api ModuleApi, config any) (err error) {
+	if m.Config.FS == nil && m.Config.Dir == "" {
+		return errors.New("static module requires FS or Dir")
+	}
+	noRouteApi, ok := api.(NoRouteApi)
+	if !ok {
+		return errors.New("static module requires a module api which supports AddNoRoute")
+	}
+	api.AddController(&staticController{module: m})
+	noRouteApi.AddNoRoute(m.noRoute)
+	return
+}
+
+type staticController struct {
+	BaseController
+	module *StaticModule
+}
+
+func (c *staticController) Init(config ControllerConfig) (err error) {
+	c.module.production = config.Production
+	return
+}
+
+// The files to serve. Outside of production the directory is read live so changes show up without a restart.
+func (m *StaticModule) files() fs.FS {
+	if m.Config.Dir != "" && (!m.production || m.Config.FS == nil) {
+		return os.DirFS(m.Config.Dir)
+	}
+	return m.Config.FS
+}
+
+// Serve files and the SPA fallback for GET and HEAD requests under the prefix
+func (m *StaticModule) noRoute(c *gin.Context) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return
+	}
+	name, ok := m.fileName(c.Request.URL.Path)
+	if !ok {
+		return
+	}
+	files := m.files()
+	if m.serveFile(c, files, name) {
+		c.Abort()
+		return
+	}
+	if m.fallback(c, name) && m.serveFile(c, files, m.Config.Index) {
+		c.Abort()
+	}
+}
+
+// Map a request path to a file name in the file system
+func (m *StaticModule) fileName(urlPath string) (name string, ok bool) {
+	urlPath = path.Clean("/" + urlPath)
+	if m.Config.Prefix != "/" {
+		if urlPath != m.Config.Prefix && !strings.HasPrefix(urlPath, m.Config.Prefix+"/") {
+			return "", false
+		}
+		urlPath = strings.TrimPrefix(urlPath, m.Config.Prefix)
+	}
+	name = strings.TrimPrefix(urlPath, "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+// Only requests for pages fall back to the index. Requests for missing assets and excluded paths such as API
+// routes still get a 404.
+func (m *StaticModule) fallback(c *gin.Context, name string) bool {
+	if !m.Config.SPA || path.Ext(name) != "" {
+		return false
+	}
+	for _, prefix := range m.Config.ExcludePrefixes {
+		p := c.Request.URL.Path
+		if p == prefix || strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/") {
+			return false
+		}
+	}
+	accept := c.GetHeader("Accept")
+	return accept == "" || strings.Contains(accept, "text/html") || strings.Contains(accept, "*/*")
+}
+
+// Serve the file, its index if it is a directory, or a precompressed variant. Returns false if it doesn't exist.
+func (m *StaticModule) serveFile(c *gin.Context, files fs.FS, name string) bool {
+	if info, err := fs.Stat(files, name); err != nil {
+		return false
+	} else if info.IsDir() {
+		name = path.Join(name, m.Config.Index)
+		if info, err = fs.Stat(files, name); err != nil || info.IsDir() {
+			return false
+		}
+	}
+
+	served, encoding := name, ""
+	accepted := c.GetHeader("Accept-Encoding")
+	for _, e := range staticEncodings {
+		if acceptsEncoding(accepted, e.encoding) {
+			if info, err := fs.Stat(files, name+e.extension); err == nil && !info.IsDir() {
+				served, encoding = name+e.extension, e.encoding
+				break
+			}
+		}
+	}
+
+	f, err := files.Open(served)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return false
+		}
+		content = bytes.NewReader(b)
+	}
+	etag, err := m.etag(served, content)
+	if err != nil {
+		return false
+	}
+
+	header := c.Writer.Header()
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+	header.Add("Vary", "Accept-Encoding")
+	header.Set("ETag", etag)
+	if m.Config.Hashed.MatchString(path.Base(name)) && path.Base(name) != m.Config.Index {
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), content)
+	return true
+}
+
+// Hash the contents of a file. Hashes are cached in production since the files don't change.
+func (m *StaticModule) etag(name string, content io.ReadSeeker) (string, error) {
+	if etag, ok := m.etags.Load(name); ok && m.production {
+		return etag.(string), nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil))[:32])
+	if m.production {
+		m.etags.Store(name, etag)
+	}
+	return etag, nil
+}
+
+// Check if an Accept-Encoding header allows the encoding
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != encoding && strings.TrimSpace(fields[0]) != "*" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if q := strings.ReplaceAll(param, " ", ""); q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package goof
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func staticEngine(m *StaticModule) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorHandler(false))
+	r.GET("/api/users", func(c *gin.Context) { c.String(http.StatusOK, "users") })
+	r.NoRoute(m.noRoute, func(c *gin.Context) {
+		abortWithError(c, http.StatusNotFound, errors.New("no route"))
+	})
+	return r
+}
+
+func getStatic(r *gin.Engine, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestStaticModule(t *testing.T) {
+	m := NewStaticModule(StaticConfig{
+		SPA: true,
+		FS: fstest.MapFS{
+			"index.html":                {Data: []byte("<html>app</html>")},
+			"assets/app.3f2a9c1b.js":    {Data: []byte("console.log(1)")},
+			"assets/app.3f2a9c1b.js.br": {Data: []byte("brotli")},
+			"robots.txt":                {Data: []byte("User-agent: *")},
+		},
+	})
+	m.production = true
+	r := staticEngine(m)
+
+	res := getStatic(r, "/assets/app.3f2a9c1b.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	if res.Code != http.StatusOK || res.Body.String() != "brotli" || res.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("expected the brotli variant; got %d %q %v", res.Code, res.Body, res.Header())
+	}
+	if cc := res.Header().Get("Cache-Control"); cc != "public, max-age=31536000, immutable" {
+		t.Errorf("expected hashed files to be immutable; got %q", cc)
+	}
+	res = getStatic(r, "/assets/app.3f2a9c1b.js", nil)
+	if res.Body.String() != "console.log(1)" || res.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected the uncompressed file; got %q", res.Body)
+	}
+
+	res = getStatic(r, "/robots.txt", nil)
+	etag := res.Header().Get("ETag")
+	if etag == "" || res.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expected an ETag and revalidation; got %v", res.Header())
+	}
+	if res = getStatic(r, "/robots.txt", map[string]string{"If-None-Match": etag}); res.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag; got %d", res.Code)
+	}
+
+	for _, path := range []string{"/", "/users/42"} {
+		res = getStatic(r, path, map[string]string{"Accept": "text/html"})
+		if res.Code != http.StatusOK || res.Body.String() != "<html>app</html>" {
+			t.Errorf("expected the index for %s; got %d %q", path, res.Code, res.Body)
+		}
+	}
+	if res = getStatic(r, "/api/users", nil); res.Body.String() != "users" {
+		t.Errorf("expected routes to take priority; got %q", res.Body)
+	}
+	for _, path := range []string{"/api/missing", "/assets/missing.js"} {
+		if res = getStatic(r, path, map[string]string{"Accept": "text/html"}); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for %s; got %d %q", path, res.Code, res.Body)
+		}
+	}
+	if res = getStatic(r, "/users/42", map[string]string{"Accept": "application/json"}); res.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for non-HTML requests; got %d", res.Code)
+	}
+}
+
+func TestStaticModuleLiveDir(t *testing.T) {
+	dir := t.TempDir()
+	m := NewStaticModule(StaticConfig{
+		Prefix: "/static/",
+		Dir:    dir,
+		FS:     fstest.MapFS{"app.css": {Data: []byte("embedded")}},
+	})
+	r := staticEngine(m)
+	if err := os.WriteFile(filepath.Join(dir, "app.css"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if res := getStatic(r, "/static/app.css", nil); res.Body.String() != "v1" {
+		t.Errorf("expected the file on disk; got %q", res.Body)
+	}
+	os.WriteFile(filepath.Join(dir, "app.css"), []byte("v2"), 0644)
+	if res := getStatic(r, "/static/app.css", nil); res.Body.String() != "v2" {
+		t.Errorf("expected changes on disk to be served; got %q", res.Body)
+	}
+	if res := getStatic(r, "/app.css", nil); res.Code != http.StatusNotFound {
+		t.Errorf("expected paths outside the prefix to 404; got %d", res.Code)
+	}
+	if res := getStatic(r, "/static/../go.mod", nil); res.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for paths escaping the directory; got %d", res.Code)
+	}
+	m.production = true
+	if res := getStatic(r, "/static/app.css", nil); res.Body.String() != "embedded" {
+		t.Errorf("expected the embedded file in production; got %q", res.Body)
+	}
+}
+
+func TestDefaultHashedName(t *testing.T) {
+	for name, hashed := range map[string]bool{
+		"app.3f2a9c1b.js":            true,
+		"chunk-5d41402abc4b2a76.css": true,
+		"logo-2x.png":                false,
+		"favicon-32.png":             false,
+		"app-v1.js":                  false,
+		"index.html":                 false,
+	} {
+		if defaultHashedName.MatchString(name) != hashed {
+			t.Errorf("expected %s hashed to be %v", name, hashed)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package goof
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrFileNotFound = errors.New("file not found")
+
+// Storage persists the contents of files by key. Implementations must stream the contents instead of buffering the
+// entire file in memory.
+type Storage interface {
+	// Write the contents of r under key and return the number of bytes written
+	Put(ctx context.Context, key string, r io.Reader) (n int64, err error)
+	// Open the contents stored under key. Returns ErrFileNotFound if the key does not exist.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Remove the contents stored under key. Removing a key that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Stores files in a directory on the local filesystem
+type LocalStorage struct {
+	Dir string
+}
+
+// Create a storage backend that writes files to dir. The directory is created if it does not exist.
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{Dir: dir}, nil
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.Dir, key), nil
+}
+
+// Write to a temporary file first so partially written files are never visible under key
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) (n int64, err error) {
+	p, err := s.path(key)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if n, err = io.Copy(tmp, r); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), p)
+	return
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrFileNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+const DefaultChunkSize = 256 * 1024
+
+// Stores files as chunked blobs in the goof_file_chunks table. See FilesMigration.
+type DBStorage struct {
+	db *sqlx.DB
+	// Size of each stored blob in bytes
+	ChunkSize int
+}
+
+func NewDBStorage(db *sqlx.DB) *DBStorage {
+	return &DBStorage{db: db, ChunkSize: DefaultChunkSize}
+}
+
+func (s *DBStorage) Put(ctx context.Context, key string, r io.Reader) (n int64, err error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if _, err = tx.ExecContext(ctx, s.db.Rebind("DELETE FROM goof_file_chunks WHERE file_key = ?"), key); err != nil {
+		return
+	}
+	insert, err := tx.PreparexContext(ctx, s.db.Rebind("INSERT INTO goof_file_chunks (file_key, position, data) VALUES (?, ?, ?)"))
+	if err != nil {
+		return
+	}
+	defer insert.Close()
+	buf := make([]byte, s.ChunkSize)
+	for {
+		size, readErr := io.ReadFull(r, buf)
+		// empty files are stored as a single empty chunk so they can be told apart from missing files
+		if size > 0 || n == 0 {
+			if _, err = insert.ExecContext(ctx, key, n, buf[:size]); err != nil {
+				return
+			}
+			n += int64(size)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		} else if readErr != nil {
+			err = readErr
+			return
+		}
+	}
+	err = tx.Commit()
+	return
+}
+
+func (s *DBStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	var count, size int64
+	q := s.db.Rebind("SELECT COUNT(*), COALESCE(SUM(LENGTH(data)), 0) FROM goof_file_chunks WHERE file_key = ?")
+	if err := s.db.QueryRowxContext(ctx, q, key).Scan(&count, &size); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrFileNotFound
+	}
+	return &dbFileReader{ctx: ctx, db: s.db, key: key, size: size}, nil
+}
+
+func (s *DBStorage) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.db.Rebind("DELETE FROM goof_file_chunks WHERE file_key = ?"), key)
+	return err
+}
+
+// Reads a file stored by DBStorage one chunk at a time
+type dbFileReader struct {
+	ctx  context.Context
+	db   *sqlx.DB
+	key  string
+	size int64
+	pos  int64
+
+	chunk      []byte
+	chunkStart int64
+}
+
+func (r *dbFileReader) Read(p []byte) (n int, err error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.chunk == nil || r.pos < r.chunkStart || r.pos >= r.chunkStart+int64(len(r.chunk)) {
+		if err = r.load(); err != nil {
+			return
+		}
+	}
+	n = copy(p, r.chunk[r.pos-r.chunkStart:])
+	r.pos += int64(n)
+	return
+}
+
+// Load the chunk containing the current position
+func (r *dbFileReader) load() error {
+	var row struct {
+		Position int64  `db:"position"`
+		Data     []byte `db:"data"`
+	}
+	q := r.db.Rebind("SELECT position, data FROM goof_file_chunks WHERE file_key = ? AND position <= ? ORDER BY position DESC LIMIT 1")
+	err := r.db.GetContext(r.ctx, &row, q, r.key, r.pos)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && r.pos >= row.Position+int64(len(row.Data))) {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	r.chunk, r.chunkStart = row.Data, row.Position
+	return nil
+}
+
+func (r *dbFileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *dbFileReader) Close() error {
+	r.chunk = nil
+	return nil
+}
@@ -0,0 +1,317 @@
+package goof
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wyattis/goof/log"
+)
+
+type SSLConfig struct {
+	Enabled  bool
+	CertFile string
+	KeyFile  string
+	// The certificate files are checked for changes this often and reloaded. They are also reloaded on SIGHUP.
+	// Defaults to 1 minute.
+	ReloadInterval time.Duration
+	// Minimum TLS version, either "1.2" or "1.3". Defaults to "1.2".
+	MinVersion string
+	// Names of the cipher suites allowed for TLS 1.2 such as "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256". Only secure
+	// suites can be used. Defaults to Go's defaults.
+	CipherSuites []string
+	// PEM bundle of the CAs that sign client certificates. Setting this enables mutual TLS.
+	ClientCAFile string
+	// One of "request", "require", "verify_if_given" or "require_and_verify". Defaults to "require_and_verify" when
+	// ClientCAFile is set.
+	ClientAuth string
+	// Generate a self-signed certificate for Host when CertFile and KeyFile are empty. It is cached in CacheDir and
+	// reused until it is close to expiring. Can't be used in production.
+	SelfSigned bool
+	// Defaults to the goof directory in os.UserCacheDir
+	CacheDir string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// Build the TLS configuration of the server. The certificate is reloaded when the files change until the server is
+// shutdown.
+func (r *RootModule) tlsConfig() (config *tls.Config, err error) {
+	ssl := r.Config.Http.SSL
+	if ssl.CertFile == "" && ssl.KeyFile == "" && ssl.SelfSigned {
+		if r.Config.Production {
+			return nil, errors.New("self-signed certificates can't be used in production")
+		}
+		if ssl.CertFile, ssl.KeyFile, err = selfSignedCertificate(ssl.CacheDir, r.Config.Http.Host); err != nil {
+			return nil, fmt.Errorf("Failed to create self-signed certificate:\n %w", err)
+		}
+	}
+	if ssl.CertFile == "" || ssl.KeyFile == "" {
+		return nil, errors.New("SSL is enabled without a CertFile and KeyFile")
+	}
+	reloader, err := newCertReloader(ssl.CertFile, ssl.KeyFile)
+	if err != nil {
+		return
+	}
+	config = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if ssl.MinVersion != "" {
+		var ok bool
+		if config.MinVersion, ok = tlsVersions[ssl.MinVersion]; !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q", ssl.MinVersion)
+		}
+	}
+	if config.CipherSuites, err = cipherSuites(ssl.CipherSuites); err != nil {
+		return
+	}
+	if ssl.ClientCAFile != "" {
+		pem, err := os.ReadFile(ssl.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", ssl.ClientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if ssl.ClientAuth != "" {
+		var ok bool
+		if config.ClientAuth, ok = clientAuthTypes[ssl.ClientAuth]; !ok {
+			return nil, fmt.Errorf("unsupported client auth %q", ssl.ClientAuth)
+		}
+	}
+	r.server.RegisterOnShutdown(reloader.watch(ssl.ReloadInterval))
+	return
+}
+
+// Look up cipher suites by name. Insecure suites are rejected.
+func cipherSuites(names []string) (ids []uint16, err error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+// Get the verified certificate the client authenticated with. Returns nil if the client didn't send one.
+func ClientCert(c *gin.Context) *x509.Certificate {
+	state := c.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// Serves the most recently loaded certificate
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	return r, r.reload()
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// The latest modification time of the certificate and key files
+func (r *certReloader) lastModified() (modTime time.Time, err error) {
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+// Reload the certificate if the files changed. The current certificate is kept if the new one fails to load, such as
+// when only one of the files has been replaced so far.
+func (r *certReloader) reloadIfChanged() {
+	modTime, err := r.lastModified()
+	r.mu.RLock()
+	changed := err == nil && !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err = r.reload(); err != nil {
+		log.Warn().Err(err).Msg("failed to reload TLS certificate")
+		return
+	}
+	log.Info().Str("cert", r.certFile).Msg("reloaded TLS certificate")
+}
+
+// Check for changes every interval and on SIGHUP until the returned function is called
+func (r *certReloader) watch(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	done := make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ticker.C:
+				r.reloadIfChanged()
+			case <-hup:
+				if err := r.reload(); err != nil {
+					log.Warn().Err(err).Msg("failed to reload TLS certificate")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Create a self-signed certificate for host or reuse the cached one. Returns the paths of the certificate and key.
+func selfSignedCertificate(dir, host string) (certFile, keyFile string, err error) {
+	if dir == "" {
+		if dir, err = os.UserCacheDir(); err != nil {
+			return
+		}
+		dir = filepath.Join(dir, "goof")
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	name := strings.NewReplacer("*", "_", "/", "_").Replace(host)
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if cachedCertificateValid(certFile, keyFile, host) {
+		return
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host, Organization: []string{"goof development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	return
+}
+
+// Check that a cached certificate covers host and isn't expiring within 30 days
+func cachedCertificateValid(certFile, keyFile, host string) bool {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	return cert.VerifyHostname(host) == nil && time.Now().AddDate(0, 0, 30).Before(cert.NotAfter)
+}
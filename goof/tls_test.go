@@ -0,0 +1,186 @@
+package goof
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := selfSignedCertificate(dir, "dev.example:8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cachedCertificateValid(certFile, keyFile, "dev.example") || !cachedCertificateValid(certFile, keyFile, "localhost") {
+		t.Errorf("expected the certificate to cover the host and localhost")
+	}
+	first, _ := os.ReadFile(certFile)
+	if _, _, err = selfSignedCertificate(dir, "dev.example"); err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := os.ReadFile(certFile); string(first) != string(second) {
+		t.Errorf("expected the cached certificate to be reused")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	certFile, keyFile := filepath.Join(t.TempDir(), "tls.crt"), filepath.Join(t.TempDir(), "tls.key")
+	install := func(host string, modTime time.Time) {
+		cert, key, err := selfSignedCertificate(t.TempDir(), host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for src, dst := range map[string]string{cert: certFile, key: keyFile} {
+			b, _ := os.ReadFile(src)
+			if err = os.WriteFile(dst, b, 0600); err != nil {
+				t.Fatal(err)
+			}
+			os.Chtimes(dst, modTime, modTime)
+		}
+	}
+	commonName := func(r *certReloader) string {
+		cert, _ := r.GetCertificate(nil)
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+
+	install("a.test", time.Now().Add(-time.Hour))
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	install("b.test", time.Now())
+	r.reloadIfChanged()
+	if name := commonName(r); name != "b.test" {
+		t.Errorf("expected the new certificate to be loaded; got %s", name)
+	}
+	os.WriteFile(keyFile, []byte("half written"), 0600)
+	r.reloadIfChanged()
+	if name := commonName(r); name != "b.test" {
+		t.Errorf("expected the previous certificate to be kept; got %s", name)
+	}
+}
+
+// Create a CA and a client certificate it signed
+func clientCertificate(t *testing.T, dir, name string) (caFile string, cert tls.Certificate) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile = filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600)
+	ca, _ = x509.ParseCertificate(caDer)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caFile, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	caFile, clientCert := clientCertificate(t, dir, "service-a")
+
+	r := &RootModule{engine: gin.New()}
+	r.engine.GET("/whoami", func(c *gin.Context) {
+		c.String(http.StatusOK, ClientCert(c).Subject.CommonName)
+	})
+	r.Config.Http.Addr = "127.0.0.1:0"
+	r.Config.Http.SSL = SSLConfig{
+		Enabled:      true,
+		SelfSigned:   true,
+		CacheDir:     dir,
+		MinVersion:   "1.3",
+		ClientCAFile: caFile,
+	}
+	r.server = r.newServer()
+	var err error
+	if r.server.TLSConfig, err = r.tlsConfig(); err != nil {
+		t.Fatal(err)
+	}
+	listeners, err := r.listeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.serve(listeners[0])
+	defer r.server.Shutdown(context.Background())
+
+	get := func(certs ...tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}}}
+		res, err := client.Get("https://" + listeners[0].Addr().String() + "/whoami")
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		return string(b), err
+	}
+	if name, err := get(clientCert); err != nil || name != "service-a" {
+		t.Errorf("expected the client identity; got %q %v", name, err)
+	}
+	if _, err := get(); err == nil {
+		t.Errorf("expected clients without a certificate to be rejected")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	for name, ssl := range map[string]SSLConfig{
+		"missing files": {Enabled: true},
+		"bad version":   {Enabled: true, SelfSigned: true, MinVersion: "1.0"},
+		"bad cipher":    {Enabled: true, SelfSigned: true, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+	} {
+		r := &RootModule{engine: gin.New()}
+		ssl.CacheDir = t.TempDir()
+		r.Config.Http.SSL = ssl
+		r.server = r.newServer()
+		if _, err := r.tlsConfig(); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+	r := &RootModule{engine: gin.New()}
+	r.Config.Production = true
+	r.Config.Http.SSL = SSLConfig{Enabled: true, SelfSigned: true, CacheDir: t.TempDir()}
+	r.server = r.newServer()
+	if _, err := r.tlsConfig(); err == nil {
+		t.Errorf("expected self-signed certificates to be rejected in production")
+	}
+}
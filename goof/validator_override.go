@@ -1,15 +1,52 @@
 package goof
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"reflect"
+	"strings"
 	"sync"
 
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 )
 
+var defaultValidate = new(defaultValidator)
+
 func init() {
-	binding.Validator = new(defaultValidator)
+	binding.Validator = defaultValidate
+}
+
+// Payload types can implement Validatable to add validation that can't be expressed with struct tags. Validate is
+// called after the struct tags have been validated successfully. Return FieldErrors to report errors for specific
+// fields.
+type Validatable interface {
+	Validate() error
+}
+
+// Register a custom validation for the given tag. Validations must be registered before they are used.
+func RegisterValidation(tag string, fn validator.Func, callValidationEvenIfNull ...bool) error {
+	defaultValidate.lazyinit()
+	return defaultValidate.validate.RegisterValidation(tag, fn, callValidationEvenIfNull...)
+}
+
+// Register a struct level validation for the given types. Struct level validations are used for rules that depend on
+// several fields. Report errors using validator.StructLevel.ReportError.
+func RegisterStructValidation(fn validator.StructLevelFunc, types ...any) {
+	defaultValidate.lazyinit()
+	defaultValidate.validate.RegisterStructValidation(fn, types...)
+}
+
+// Register an alias for one or more validation tags. For example RegisterAlias("password", "min=8,max=64").
+func RegisterAlias(alias, tags string) {
+	defaultValidate.lazyinit()
+	defaultValidate.validate.RegisterAlias(alias, tags)
+}
+
+// Validate a struct or a slice of structs using the same rules as the route builders
+func Validate(obj any) error {
+	return defaultValidate.ValidateStruct(obj)
 }
 
 type defaultValidator struct {
@@ -20,14 +57,48 @@ type defaultValidator struct {
 var _ binding.StructValidator = &defaultValidator{}
 
 func (v *defaultValidator) ValidateStruct(obj interface{}) error {
+	if obj == nil {
+		return nil
+	}
 
-	if kindOfData(obj) == reflect.Struct {
-
+	switch kindOfData(obj) {
+	case reflect.Struct:
 		v.lazyinit()
-
 		if err := v.validate.Struct(obj); err != nil {
+			var validationErrs validator.ValidationErrors
+			if errors.As(err, &validationErrs) {
+				// the type is needed to report the other fields of cross-field rules by their JSON names
+				return FieldErrors(fieldErrors(validationErrs, reflect.TypeOf(obj)))
+			}
 			return err
 		}
+		if val, ok := obj.(Validatable); ok {
+			return val.Validate()
+		}
+	case reflect.Slice, reflect.Array:
+		value := reflect.Indirect(reflect.ValueOf(obj))
+		var fields FieldErrors
+		for i := 0; i < value.Len(); i++ {
+			item := value.Index(i)
+			if item.Kind() != reflect.Ptr && item.CanAddr() {
+				item = item.Addr()
+			}
+			err := v.ValidateStruct(item.Interface())
+			if err == nil {
+				continue
+			}
+			itemFields, ok := asFieldErrors(err)
+			if !ok {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+			for _, f := range itemFields {
+				f.Field = fmt.Sprintf("[%d].%s", i, f.Field)
+				fields = append(fields, f)
+			}
+		}
+		if len(fields) > 0 {
+			return fields
+		}
 	}
 
 	return nil
@@ -43,10 +114,25 @@ func (v *defaultValidator) lazyinit() {
 		v.validate = validator.New()
 		v.validate.SetTagName("binding")
 
-		// add any custom validations etc. here
+		// report fields using the same name clients use
+		v.validate.RegisterTagNameFunc(fieldName)
 	})
 }
 
+// Get the name a client uses for a struct field. The first tag found of json, form, uri and header is used.
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "uri", "header"} {
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
 func kindOfData(data interface{}) reflect.Kind {
 
 	value := reflect.ValueOf(data)
@@ -57,3 +143,37 @@ func kindOfData(data interface{}) reflect.Kind {
 	}
 	return valueType
 }
+
+// A list of field errors which can be returned from Validatable.Validate
+type FieldErrors []FieldError
+
+func (f FieldErrors) Error() string {
+	msgs := make([]string, len(f))
+	for i, e := range f {
+		msgs[i] = fmt.Sprintf("%s %s", e.Field, e.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Convert validation failures into field errors
+func asFieldErrors(err error) (fields FieldErrors, ok bool) {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return fieldErrors(validationErrs, nil), true
+	}
+	if errors.As(err, &fields) {
+		return fields, true
+	}
+	return nil, false
+}
+
+func validationError(err error) *HTTPError {
+	fields, _ := asFieldErrors(err)
+	return &HTTPError{
+		Status: http.StatusBadRequest,
+		Code:   "validation_failed",
+		Detail: "The request failed validation",
+		Fields: fields,
+		Err:    err,
+	}
+}
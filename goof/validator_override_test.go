@@ -0,0 +1,103 @@
+package goof
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type validationAddress struct {
+	City string `json:"city" binding:"required"`
+}
+
+type validationRange struct {
+	From int `json:"from"`
+	To   int `json:"to" binding:"gtefield=From"`
+}
+
+type validationPayload struct {
+	Name    string            `json:"full_name" binding:"required,notadmin"`
+	Start   int               `json:"start"`
+	End     int               `json:"end" binding:"gtfield=Start"`
+	Address validationAddress `json:"address"`
+	Ranges  []validationRange `json:"ranges" binding:"dive"`
+}
+
+type validatablePayload struct {
+	Password string `json:"password"`
+	Confirm  string `json:"confirm"`
+}
+
+func (p validatablePayload) Validate() error {
+	if p.Password != p.Confirm {
+		return FieldErrors{{Field: "confirm", Message: "must match password"}}
+	}
+	return nil
+}
+
+func init() {
+	if err := RegisterValidation("notadmin", func(fl validator.FieldLevel) bool {
+		return fl.Field().String() != "admin"
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func fieldMessages(err error) map[string]string {
+	res := map[string]string{}
+	fields, _ := asFieldErrors(err)
+	for _, f := range fields {
+		res[f.Field] = f.Message
+	}
+	return res
+}
+
+func TestValidateUsesJsonNames(t *testing.T) {
+	err := Validate(&validationPayload{Name: "admin", Start: 2, End: 1, Ranges: []validationRange{{From: 1, To: 2}, {From: 3, To: 1}}})
+	fields := fieldMessages(err)
+	expected := map[string]string{
+		"full_name":    "failed the 'notadmin' validation",
+		"end":          "must be greater than start",
+		"address.city": "is required",
+		"ranges[1].to": "must be greater than or equal to from",
+	}
+	for field, msg := range expected {
+		if fields[field] != msg {
+			t.Errorf("expected %s to have message %q; got %q", field, msg, fields[field])
+		}
+	}
+}
+
+func TestValidateSlice(t *testing.T) {
+	err := Validate(&[]validationAddress{{City: "a"}, {}})
+	fields := fieldMessages(err)
+	if fields["[1].city"] != "is required" {
+		t.Errorf("expected the second item to fail; got %v", fields)
+	}
+}
+
+func TestValidateValidatable(t *testing.T) {
+	err := Validate(&validatablePayload{Password: "a", Confirm: "b"})
+	var fields FieldErrors
+	if !errors.As(err, &fields) || fields[0].Field != "confirm" {
+		t.Errorf("expected confirm field error; got %v", err)
+	}
+}
+
+func TestFromJsonValidates(t *testing.T) {
+	called := false
+	r := problemEngine(true, FromJson("/", func(c *gin.Context, payload validatablePayload) (status int, err error) {
+		called = true
+		return
+	}).Post())
+	p, res := doProblem(t, r, http.MethodPost, "/", `{"password": "a", "confirm": "b"}`)
+	if called {
+		t.Error("expected the handler not to be called")
+	}
+	if res.Code != http.StatusBadRequest || len(p.Errors) != 1 || p.Errors[0].Field != "confirm" {
+		t.Errorf("expected a confirm field error; got %d %+v", res.Code, p)
+	}
+}
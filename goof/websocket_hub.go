@@ -0,0 +1,82 @@
+package goof
+
+import (
+	"errors"
+	"sync"
+)
+
+// A connection which can be added to a Hub. Implemented by *WebSocketConn.
+type HubConn[Out any] interface {
+	TrySend(msg Out) error
+	Close() error
+	Done() <-chan struct{}
+}
+
+// Groups websocket connections into rooms so messages can be broadcast to every connection in a room. Connections
+// leave all of their rooms when they close.
+type Hub[Out any] struct {
+	mu    sync.RWMutex
+	rooms map[string]map[HubConn[Out]]struct{}
+}
+
+func NewHub[Out any]() *Hub[Out] {
+	return &Hub[Out]{
+		rooms: map[string]map[HubConn[Out]]struct{}{},
+	}
+}
+
+// Add a connection to a room
+func (h *Hub[Out]) Join(room string, conn HubConn[Out]) {
+	h.mu.Lock()
+	members, ok := h.rooms[room]
+	if !ok {
+		members = map[HubConn[Out]]struct{}{}
+		h.rooms[room] = members
+	}
+	_, joined := members[conn]
+	members[conn] = struct{}{}
+	h.mu.Unlock()
+	if !joined {
+		go func() {
+			<-conn.Done()
+			h.Leave(room, conn)
+		}()
+	}
+}
+
+// Remove a connection from a room
+func (h *Hub[Out]) Leave(room string, conn HubConn[Out]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	members, ok := h.rooms[room]
+	if !ok {
+		return
+	}
+	delete(members, conn)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// Get the number of connections in a room
+func (h *Hub[Out]) Count(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
+
+// Send a message to every connection in a room. Connections which can't keep up with the messages are closed rather
+// than slowing down the rest of the room.
+func (h *Hub[Out]) Broadcast(room string, msg Out) {
+	h.mu.RLock()
+	members := make([]HubConn[Out], 0, len(h.rooms[room]))
+	for conn := range h.rooms[room] {
+		members = append(members, conn)
+	}
+	h.mu.RUnlock()
+	for _, conn := range members {
+		if err := conn.TrySend(msg); errors.Is(err, ErrSendBufferFull) {
+			conn.Close()
+		}
+	}
+}
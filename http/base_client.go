@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/url"
@@ -47,11 +48,49 @@ func (c *BaseClient) PostForm(path string, data url.Values) (res *http.Response,
 	return c.Client.PostForm(c.fixPath(path), data)
 }
 
+// Same as Get, but the request is bound to ctx and forwards its request id
+func (c *BaseClient) GetContext(ctx context.Context, path string) (res *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return
+	}
+	return c.Do(req)
+}
+
+// Same as Head, but the request is bound to ctx and forwards its request id
+func (c *BaseClient) HeadContext(ctx context.Context, path string) (res *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, path, nil)
+	if err != nil {
+		return
+	}
+	return c.Do(req)
+}
+
+// Same as Post, but the request is bound to ctx and forwards its request id
+func (c *BaseClient) PostContext(ctx context.Context, path, contentType string, body io.Reader) (res *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// Same as PostForm, but the request is bound to ctx and forwards its request id
+func (c *BaseClient) PostFormContext(ctx context.Context, path string, data url.Values) (res *http.Response, err error) {
+	return c.PostContext(ctx, path, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+}
+
+// Send the request. If the request context carries a request id it is forwarded in the RequestIdHeader unless the
+// header has already been set.
 func (c *BaseClient) Do(req *http.Request) (res *http.Response, err error) {
 	uri := req.URL.String()
 	req.URL, err = url.Parse(c.fixPath(uri))
 	if err != nil {
 		return
 	}
+	if id := RequestId(req.Context()); id != "" && req.Header.Get(RequestIdHeader) == "" {
+		req.Header.Set(RequestIdHeader, id)
+	}
 	return c.Client.Do(req)
 }
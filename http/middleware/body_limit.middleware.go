@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The gin context key the request body limit is stored under
+const BodyLimitKey = "bodyLimit"
+
+// Limit request bodies to n bytes. Reading past the limit fails with *http.MaxBytesError, as does the first read of
+// a body declaring a larger Content-Length. Routes that accept larger bodies can raise the limit with SetBodyLimit
+// before reading the body.
+func BodyLimit(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := &limitedBody{ReadCloser: c.Request.Body, limit: n, declared: c.Request.ContentLength}
+		c.Request.Body = body
+		c.Set(BodyLimitKey, body)
+		c.Next()
+	}
+}
+
+// Change the body limit of the current request. Does nothing if BodyLimit is not in use.
+func SetBodyLimit(c *gin.Context, n int64) {
+	if body, ok := c.Value(BodyLimitKey).(*limitedBody); ok {
+		body.limit = n
+	}
+}
+
+// Like http.MaxBytesReader except that the limit can change until the body is read
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	declared int64
+}
+
+func (b *limitedBody) Read(p []byte) (n int, err error) {
+	if b.declared > b.limit {
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	remaining := b.limit - b.read
+	if remaining < 0 {
+		remaining = 0
+	}
+	if int64(len(p)) > remaining+1 {
+		p = p[:remaining+1]
+	}
+	n, err = b.ReadCloser.Read(p)
+	if int64(n) > remaining {
+		n = int(remaining)
+		b.read += int64(n)
+		return n, &http.MaxBytesError{Limit: b.limit}
+	}
+	b.read += int64(n)
+	return
+}
+
+// Check if err was caused by a request body exceeding its limit
+func IsBodyTooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(BodyLimit(8))
+	read := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); IsBodyTooLarge(err) {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	r.POST("/", read)
+	r.POST("/large", func(c *gin.Context) {
+		SetBodyLimit(c, 64)
+		read(c)
+	})
+	for _, tc := range []struct {
+		path     string
+		body     string
+		chunked  bool
+		expected int
+	}{
+		{"/", "12345678", false, http.StatusOK},
+		{"/", "123456789", false, http.StatusRequestEntityTooLarge},
+		{"/", "123456789", true, http.StatusRequestEntityTooLarge},
+		{"/large", "123456789", false, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+		if tc.chunked {
+			req.ContentLength = -1
+		}
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != tc.expected {
+			t.Errorf("expected %d for %q on %s; got %d", tc.expected, tc.body, tc.path, res.Code)
+		}
+	}
+}
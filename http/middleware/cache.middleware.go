@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wyattis/goof/log"
+)
+
+type CacheConfig struct {
+	// Where full responses are stored. Responses are only validated with ETags if this is nil.
+	Store CacheStore
+	// How long responses are stored for
+	TTL time.Duration
+	// Cache-Control header added to responses which don't set their own, such as "public, max-age=60"
+	CacheControl string
+	// Request headers that change the response. They are included in the cache key and the Vary header along with
+	// Accept, which picks the response format. Requests with an Authorization or Cookie header are never stored unless
+	// that header is listed here.
+	VaryHeaders []string
+	// Query parameters included in the cache key. Every parameter is included if this is nil.
+	Query []string
+}
+
+// The response headers which are stored with a response. Headers about the exchange rather than the representation,
+// like X-Request-ID, CORS headers and Set-Cookie, are never replayed.
+var cachedHeaders = []string{"Content-Type", "Content-Language", "Content-Disposition", "ETag", "Last-Modified", "Cache-Control"}
+
+// Add ETags to successful GET responses and answer conditional requests with 304 Not Modified. If a store is
+// configured full responses are stored and served from it until they expire or are invalidated.
+func Cache(config CacheConfig) gin.HandlerFunc {
+	varyHeaders := make([]string, 0, len(config.VaryHeaders)+1)
+	for _, h := range config.VaryHeaders {
+		varyHeaders = append(varyHeaders, http.CanonicalHeaderKey(h))
+	}
+	// the same path can be rendered in several formats
+	if !containsHeader(varyHeaders, "Accept") {
+		varyHeaders = append(varyHeaders, "Accept")
+	}
+	config.VaryHeaders = varyHeaders
+	vary := strings.Join(config.VaryHeaders, ", ")
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.Next()
+			return
+		}
+		key := cacheKey(c.Request, config)
+		useStore := config.Store != nil && config.TTL > 0
+		if useStore {
+			res, ok, err := config.Store.Get(c, key)
+			if err != nil {
+				log.Ctx(c).Warn().Err(err).Msg("failed to read cached response")
+			} else if ok {
+				c.Writer.Header().Add("Vary", vary)
+				writeCached(c, res, "HIT")
+				c.Abort()
+				return
+			}
+		}
+
+		w := &cacheWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.passthrough {
+			return
+		}
+		if w.status == 0 && w.body.Len() == 0 {
+			// nothing was written so leave the response to the error handler
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", vary)
+		if w.Status() != http.StatusOK {
+			w.flush()
+			return
+		}
+		if header.Get("ETag") == "" {
+			sum := sha256.Sum256(w.body.Bytes())
+			header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		}
+		if config.CacheControl != "" && header.Get("Cache-Control") == "" {
+			header.Set("Cache-Control", config.CacheControl)
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		store := useStore && storable(c.Request, header, config.VaryHeaders)
+		if store && header.Get("Last-Modified") == "" {
+			header.Set("Last-Modified", now.Format(http.TimeFormat))
+		}
+		stored := http.Header{}
+		for _, h := range cachedHeaders {
+			if v := header.Values(h); len(v) > 0 {
+				stored[http.CanonicalHeaderKey(h)] = append([]string{}, v...)
+			}
+		}
+		res := &CachedResponse{
+			Path:     c.Request.URL.Path,
+			Status:   w.Status(),
+			Header:   stored,
+			Body:     w.body.Bytes(),
+			StoredAt: now,
+			Expires:  now.Add(config.TTL),
+		}
+		if store {
+			if err := config.Store.Set(c, key, res); err != nil {
+				log.Ctx(c).Warn().Err(err).Msg("failed to store response")
+			}
+		}
+		writeCached(c, res, "MISS")
+	}
+}
+
+// Build the key of a request from its path, the selected query parameters and the vary headers
+func cacheKey(req *http.Request, config CacheConfig) string {
+	query := req.URL.Query()
+	if config.Query != nil {
+		selected := url.Values{}
+		for _, k := range config.Query {
+			if v, ok := query[k]; ok {
+				selected[k] = v
+			}
+		}
+		query = selected
+	}
+	for _, v := range query {
+		sort.Strings(v)
+	}
+	key := strings.Builder{}
+	key.WriteString(req.URL.Path)
+	key.WriteString("?")
+	key.WriteString(query.Encode())
+	for _, h := range config.VaryHeaders {
+		key.WriteString("\n")
+		key.WriteString(h)
+		key.WriteString(":")
+		key.WriteString(strings.Join(req.Header.Values(h), ","))
+	}
+	return key.String()
+}
+
+// Responses that are private to a client are never stored
+func storable(req *http.Request, header http.Header, vary []string) bool {
+	for _, h := range []string{"Authorization", "Cookie"} {
+		if req.Header.Get(h) != "" && !containsHeader(vary, h) {
+			return false
+		}
+	}
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	return header.Get("Set-Cookie") == "" && !strings.Contains(cacheControl, "no-store") &&
+		!strings.Contains(cacheControl, "private")
+}
+
+func containsHeader(headers []string, header string) bool {
+	for _, h := range headers {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// Write a response or 304 if the client already has it
+func writeCached(c *gin.Context, res *CachedResponse, status string) {
+	header := c.Writer.Header()
+	for k, v := range res.Header {
+		header[k] = v
+	}
+	header.Set("X-Cache", status)
+	if status == "HIT" {
+		header.Set("Age", strconv.Itoa(int(time.Since(res.StoredAt).Seconds())))
+	}
+	if notModified(c.Request, header) {
+		for _, h := range []string{"Content-Type", "Content-Length"} {
+			header.Del(h)
+		}
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Status(res.Status)
+	if c.Request.Method == http.MethodHead {
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Writer.Write(res.Body)
+}
+
+// Evaluate If-None-Match and, when it is absent, If-Modified-Since
+func notModified(req *http.Request, header http.Header) bool {
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		for _, m := range strings.Split(match, ",") {
+			m = strings.TrimPrefix(strings.TrimSpace(m), "W/")
+			if m == "*" || (etag != "" && m == etag) {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// Buffers the response so that it can be hashed and stored. Streaming responses switch to writing directly to the
+// client the first time they are flushed.
+type cacheWriter struct {
+	gin.ResponseWriter
+	status      int
+	written     bool
+	body        bytes.Buffer
+	passthrough bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *cacheWriter) WriteHeaderNow() {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.written = true
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	w.WriteHeaderNow()
+	return w.body.Write(data)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *cacheWriter) Status() int {
+	if w.passthrough || w.status == 0 {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *cacheWriter) Size() int {
+	if w.passthrough {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+// Like gin, setting the status alone doesn't count as writing the response
+func (w *cacheWriter) Written() bool {
+	if w.passthrough {
+		return w.ResponseWriter.Written()
+	}
+	return w.written
+}
+
+func (w *cacheWriter) Flush() {
+	w.flush()
+	w.ResponseWriter.Flush()
+}
+
+// Write everything buffered so far and stop buffering
+func (w *cacheWriter) flush() {
+	if w.passthrough {
+		return
+	}
+	w.passthrough = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+	}
+	w.body.Reset()
+}
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func cacheEngine(config CacheConfig) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	hits := 0
+	r := gin.New()
+	r.Use(Cache(config))
+	r.GET("/users", func(c *gin.Context) {
+		hits++
+		c.JSON(http.StatusOK, gin.H{"page": c.Query("page"), "hits": hits})
+	})
+	r.GET("/users/:id", func(c *gin.Context) {
+		hits++
+		if c.Param("id") == "missing" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "missing"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+	})
+	r.GET("/format", func(c *gin.Context) {
+		hits++
+		c.Header("X-Request-ID", strconv.Itoa(hits))
+		c.Header("Access-Control-Allow-Origin", "*")
+		if c.GetHeader("Accept") == "application/xml" {
+			c.XML(http.StatusOK, gin.H{"hits": hits})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"hits": hits})
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("a")
+		c.Writer.Flush()
+		c.Writer.WriteString("b")
+	})
+	return r, &hits
+}
+
+func getCached(r *gin.Engine, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestCacheETag(t *testing.T) {
+	r, hits := cacheEngine(CacheConfig{CacheControl: "no-cache"})
+	res := getCached(r, "/users/1", nil)
+	etag := res.Header().Get("ETag")
+	if res.Code != http.StatusOK || etag == "" || res.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("expected an ETag; got %d %v", res.Code, res.Header())
+	}
+	res = getCached(r, "/users/1", map[string]string{"If-None-Match": etag})
+	if res.Code != http.StatusNotModified || res.Body.Len() != 0 {
+		t.Errorf("expected 304; got %d %q", res.Code, res.Body)
+	}
+	if *hits != 2 {
+		t.Errorf("expected responses to be generated without a store; got %d hits", *hits)
+	}
+	if res = getCached(r, "/users/missing", nil); res.Code != http.StatusNotFound || res.Header().Get("ETag") != "" {
+		t.Errorf("expected errors to pass through; got %d %v", res.Code, res.Header())
+	}
+	if res = getCached(r, "/stream", nil); res.Body.String() != "ab" {
+		t.Errorf("expected flushed responses to stream; got %q", res.Body)
+	}
+}
+
+func TestCacheStore(t *testing.T) {
+	store := NewMemoryCacheStore(10)
+	r, hits := cacheEngine(CacheConfig{Store: store, TTL: time.Minute, Query: []string{"page"}})
+	first := getCached(r, "/users?page=1&utm=a", nil)
+	second := getCached(r, "/users?utm=b&page=1", nil)
+	if *hits != 1 || second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Errorf("expected the second request to be served from the store; got %d hits %v", *hits, second.Header())
+	}
+	if getCached(r, "/users?page=2", nil); *hits != 2 {
+		t.Errorf("expected selected query parameters to be part of the key; got %d hits", *hits)
+	}
+	res := getCached(r, "/users?page=1", map[string]string{"If-Modified-Since": first.Header().Get("Last-Modified")})
+	if res.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for If-Modified-Since; got %d", res.Code)
+	}
+	if getCached(r, "/users?page=1", map[string]string{"Authorization": "Bearer x"}); *hits != 2 {
+		t.Errorf("expected authorized requests to be served from the store; got %d hits", *hits)
+	}
+
+	getCached(r, "/users/1", nil)
+	store.Invalidate(context.Background(), "/users")
+	getCached(r, "/users?page=1", nil)
+	getCached(r, "/users/1", nil)
+	if *hits != 5 {
+		t.Errorf("expected invalidated responses to be regenerated; got %d hits", *hits)
+	}
+}
+
+func TestCacheStoresRepresentations(t *testing.T) {
+	r, hits := cacheEngine(CacheConfig{Store: NewMemoryCacheStore(10), TTL: time.Minute})
+	getCached(r, "/format", map[string]string{"Accept": "application/json"})
+	res := getCached(r, "/format", map[string]string{"Accept": "application/json"})
+	if *hits != 1 || res.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("expected the response to be served from the store; got %d hits %v", *hits, res.Header())
+	}
+	for _, h := range []string{"X-Request-ID", "Access-Control-Allow-Origin"} {
+		if res.Header().Get(h) != "" {
+			t.Errorf("expected %s not to be replayed; got %v", h, res.Header())
+		}
+	}
+	if res.Header().Get("Content-Type") == "" || res.Header().Get("ETag") == "" || res.Header().Get("Vary") != "Accept" {
+		t.Errorf("expected the representation headers and Vary; got %v", res.Header())
+	}
+
+	res = getCached(r, "/format", map[string]string{"Accept": "application/xml"})
+	if *hits != 2 || res.Header().Get("X-Cache") != "MISS" || res.Header().Get("Content-Type") != "application/xml; charset=utf-8" {
+		t.Errorf("expected each format to be stored separately; got %d hits %v", *hits, res.Header())
+	}
+}
+
+func TestCachePrivateResponses(t *testing.T) {
+	store := NewMemoryCacheStore(10)
+	r, hits := cacheEngine(CacheConfig{Store: store, TTL: time.Minute})
+	getCached(r, "/users/1", map[string]string{"Authorization": "Bearer x"})
+	getCached(r, "/users/1", map[string]string{"Authorization": "Bearer y"})
+	if *hits != 2 {
+		t.Errorf("expected authorized responses not to be stored; got %d hits", *hits)
+	}
+
+	r, hits = cacheEngine(CacheConfig{Store: store, TTL: time.Minute, VaryHeaders: []string{"authorization"}})
+	getCached(r, "/users/2", map[string]string{"Authorization": "Bearer x"})
+	getCached(r, "/users/2", map[string]string{"Authorization": "Bearer y"})
+	res := getCached(r, "/users/2", map[string]string{"Authorization": "Bearer x"})
+	if *hits != 2 || res.Header().Get("Vary") != "Authorization, Accept" {
+		t.Errorf("expected responses to be stored per vary header; got %d hits %v", *hits, res.Header())
+	}
+}
+
+func TestMemoryCacheStoreEviction(t *testing.T) {
+	store := NewMemoryCacheStore(2)
+	ctx := context.Background()
+	now := time.Now()
+	store.Set(ctx, "a", &CachedResponse{Expires: now.Add(time.Minute)})
+	store.Set(ctx, "b", &CachedResponse{Expires: now.Add(time.Hour)})
+	store.Set(ctx, "c", &CachedResponse{Expires: now.Add(time.Hour)})
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Errorf("expected the entry closest to expiring to be evicted")
+	}
+	if _, ok, _ := store.Get(ctx, "c"); !ok {
+		t.Errorf("expected the new entry to be stored")
+	}
+}
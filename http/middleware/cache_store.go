@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A response stored by the Cache middleware
+type CachedResponse struct {
+	// Request path the response belongs to. Used to invalidate responses.
+	Path     string
+	Status   int
+	Header   http.Header
+	Body     []byte
+	StoredAt time.Time
+	Expires  time.Time
+}
+
+// Stores full responses for the Cache middleware
+type CacheStore interface {
+	// Get a response that has not expired yet
+	Get(ctx context.Context, key string) (res *CachedResponse, ok bool, err error)
+	Set(ctx context.Context, key string, res *CachedResponse) error
+	// Remove every response stored for path and the paths below it. Invalidating "/users" removes the responses for
+	// "/users" and "/users/1" but not "/users-archive".
+	Invalidate(ctx context.Context, path string) error
+}
+
+// Check if a response path is path or below it
+func PathMatches(responsePath, path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	return responsePath == path || strings.HasPrefix(responsePath, path+"/")
+}
+
+// Stores responses in memory. Once MaxEntries is reached expired responses are removed, followed by the responses
+// closest to expiring.
+type MemoryCacheStore struct {
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*CachedResponse
+}
+
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		MaxEntries: maxEntries,
+		entries:    map[string]*CachedResponse{},
+	}
+}
+
+func (s *MemoryCacheStore) Get(ctx context.Context, key string) (*CachedResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, ok := s.entries[key]
+	if ok && !time.Now().Before(res.Expires) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	return res, ok, nil
+}
+
+func (s *MemoryCacheStore) Set(ctx context.Context, key string, res *CachedResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.entries[key]; !exists && s.MaxEntries > 0 && len(s.entries) >= s.MaxEntries {
+		s.evict()
+	}
+	s.entries[key] = res
+	return nil
+}
+
+// Make room for a single entry
+func (s *MemoryCacheStore) evict() {
+	now := time.Now()
+	var soonest string
+	for key, res := range s.entries {
+		if !now.Before(res.Expires) {
+			delete(s.entries, key)
+		} else if soonest == "" || res.Expires.Before(s.entries[soonest].Expires) {
+			soonest = key
+		}
+	}
+	if len(s.entries) >= s.MaxEntries {
+		delete(s.entries, soonest)
+	}
+}
+
+func (s *MemoryCacheStore) Invalidate(ctx context.Context, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, res := range s.entries {
+		if PathMatches(res.Path, path) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
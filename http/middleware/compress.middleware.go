@@ -0,0 +1,318 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+type CompressConfig struct {
+	// Responses smaller than this many bytes are sent uncompressed unless they are flushed first
+	MinSize int
+	// Media types that are compressed. Entries ending in "/" match every subtype, such as "text/", and entries
+	// starting with "+" match structured syntax suffixes, such as "+json".
+	ContentTypes []string
+	// Supported encodings in order of preference. Any of "br", "gzip" and "deflate".
+	Encodings []string
+	// Compression level passed to the encoders. Zero uses each encoder's default.
+	Level int
+}
+
+var DefaultCompressConfig = CompressConfig{
+	MinSize: 1024,
+	ContentTypes: []string{
+		"text/",
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"application/x-ndjson",
+		"application/x-yaml",
+		"application/yaml",
+		"image/svg+xml",
+		"+json",
+		"+xml",
+	},
+	Encodings: []string{"br", "gzip", "deflate"},
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func Compress() gin.HandlerFunc {
+	return CompressWithConfig(DefaultCompressConfig)
+}
+
+// Compress responses using the encoding negotiated with the Accept-Encoding header. Responses which already have a
+// Content-Encoding, partial responses and websocket upgrades are left alone. Flushed responses, like Server-Sent
+// Events, are compressed as they are streamed.
+func CompressWithConfig(config CompressConfig) gin.HandlerFunc {
+	if config.ContentTypes == nil {
+		config.ContentTypes = DefaultCompressConfig.ContentTypes
+	}
+	if len(config.Encodings) == 0 {
+		config.Encodings = DefaultCompressConfig.Encodings
+	}
+	pools := map[string]*sync.Pool{}
+	for _, encoding := range config.Encodings {
+		pools[encoding] = encoderPool(encoding, config.Level)
+	}
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), config.Encodings)
+		if encoding == "" {
+			c.Next()
+			return
+		}
+		w := &compressWriter{ResponseWriter: c.Writer, config: &config, encoding: encoding, pool: pools[encoding]}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+		w.close()
+	}
+}
+
+func encoderPool(encoding string, level int) *sync.Pool {
+	return &sync.Pool{
+		New: func() any {
+			switch encoding {
+			case "br":
+				if level == 0 {
+					level = brotli.DefaultCompression
+				}
+				return brotli.NewWriterLevel(io.Discard, level)
+			case "gzip":
+				if level == 0 {
+					level = gzip.DefaultCompression
+				}
+				w, err := gzip.NewWriterLevel(io.Discard, level)
+				if err != nil {
+					panic(err)
+				}
+				return w
+			case "deflate":
+				if level == 0 {
+					level = zlib.DefaultCompression
+				}
+				w, err := zlib.NewWriterLevel(io.Discard, level)
+				if err != nil {
+					panic(err)
+				}
+				return w
+			}
+			panic("unsupported encoding " + encoding)
+		},
+	}
+}
+
+// Pick the supported encoding with the highest quality. Ties go to the earliest supported encoding.
+func negotiateEncoding(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[name] = q
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		q, ok := qualities[encoding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func (config *CompressConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range config.ContentTypes {
+		switch {
+		case strings.HasPrefix(t, "+") && strings.HasSuffix(mediaType, t):
+			return true
+		case strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t):
+			return true
+		case t == mediaType:
+			return true
+		}
+	}
+	return false
+}
+
+// Holds the response until MinSize bytes are written or it is flushed and then decides whether to compress it
+type compressWriter struct {
+	gin.ResponseWriter
+	config   *CompressConfig
+	encoding string
+	pool     *sync.Pool
+
+	status  int
+	written bool
+	buf     bytes.Buffer
+	decided bool
+	encoder encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+	} else if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.written = true
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeaderNow()
+		w.buf.Write(data)
+		if w.buf.Len() >= w.config.MinSize || !w.shouldCompress() {
+			if err := w.decide(w.shouldCompress()); err != nil {
+				return 0, err
+			}
+		}
+		return len(data), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Status() int {
+	if w.decided || w.status == 0 {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *compressWriter) Size() int {
+	if w.decided {
+		return w.ResponseWriter.Size()
+	}
+	return w.buf.Len()
+}
+
+// Like gin, setting the status alone doesn't count as writing the response
+func (w *compressWriter) Written() bool {
+	if w.decided {
+		return w.ResponseWriter.Written()
+	}
+	return w.written
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.WriteHeaderNow()
+		w.decide(w.shouldCompress())
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) shouldCompress() bool {
+	header := w.Header()
+	switch w.status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	return header.Get("Content-Encoding") == "" && header.Get("Content-Range") == "" &&
+		w.config.compressible(header.Get("Content-Type"))
+}
+
+// Send the headers and everything buffered so far, compressed or not
+func (w *compressWriter) decide(compress bool) (err error) {
+	w.decided = true
+	header := w.Header()
+	if w.config.compressible(header.Get("Content-Type")) {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		// the compressed bytes differ from the ones a strong ETag promises
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.encoder = w.pool.Get().(encoder)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	if w.buf.Len() > 0 {
+		if w.encoder != nil {
+			_, err = w.encoder.Write(w.buf.Bytes())
+		} else {
+			_, err = w.ResponseWriter.Write(w.buf.Bytes())
+		}
+	}
+	w.buf.Reset()
+	return
+}
+
+// Finish the response once the handlers are done
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 && w.buf.Len() == 0 {
+			// nothing was written so leave the response to gin
+			return
+		}
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.pool.Put(w.encoder)
+		w.encoder = nil
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+var largeText = strings.Repeat("compress me please ", 200)
+
+func compressEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Compress())
+	r.GET("/large", func(c *gin.Context) {
+		c.String(http.StatusOK, largeText)
+	})
+	r.GET("/small", func(c *gin.Context) {
+		c.String(http.StatusOK, "small")
+	})
+	r.GET("/image", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", []byte(largeText))
+	})
+	r.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "gzip")
+		c.Data(http.StatusOK, "text/plain", []byte(largeText))
+	})
+	return r
+}
+
+func getCompressed(r http.Handler, path, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestCompressEncodings(t *testing.T) {
+	r := compressEngine()
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		"br":      func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for accept, expected := range map[string]string{
+		"gzip":                   "gzip",
+		"deflate":                "deflate",
+		"gzip, deflate, br":      "br",
+		"br;q=0.5, gzip;q=0.8":   "gzip",
+		"*":                      "br",
+		"identity, br;q=0, gzip": "gzip",
+	} {
+		res := getCompressed(r, "/large", accept)
+		if encoding := res.Header().Get("Content-Encoding"); encoding != expected {
+			t.Errorf("expected %s for %q; got %q", expected, accept, encoding)
+			continue
+		}
+		if res.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("expected Vary to be set; got %v", res.Header())
+		}
+		body, err := decoders[expected](res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := io.ReadAll(body); string(b) != largeText {
+			t.Errorf("expected the decompressed body to match for %s", expected)
+		}
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	r := compressEngine()
+	for _, path := range []string{"/small", "/image", "/encoded"} {
+		res := getCompressed(r, path, "gzip")
+		if path != "/encoded" && res.Header().Get("Content-Encoding") != "" {
+			t.Errorf("expected %s not to be compressed; got %v", path, res.Header())
+		}
+		if path == "/small" && res.Body.String() != "small" {
+			t.Errorf("expected small responses to be sent as is; got %q", res.Body)
+		}
+		if path == "/encoded" && (res.Header().Get("Content-Encoding") != "gzip" || res.Body.String() != largeText) {
+			t.Errorf("expected encoded responses to pass through")
+		}
+	}
+	if res := getCompressed(r, "/large", ""); res.Header().Get("Content-Encoding") != "" || res.Body.String() != largeText {
+		t.Errorf("expected no compression without Accept-Encoding")
+	}
+}
+
+func TestCompressStreaming(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Compress())
+	next := make(chan struct{})
+	r.GET("/events", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("data: one\n\n")
+		c.Writer.Flush()
+		<-next
+		c.Writer.WriteString("data: two\n\n")
+		c.Writer.Flush()
+	})
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip stream; got %v", res.Header)
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewReader(gz)
+	// the first event must arrive before the handler finishes
+	if line, err := lines.ReadString('\n'); err != nil || line != "data: one\n" {
+		t.Fatalf("expected the first event; got %q %v", line, err)
+	}
+	close(next)
+	rest, _ := io.ReadAll(lines)
+	if string(rest) != "\ndata: two\n\n" {
+		t.Errorf("expected the second event; got %q", rest)
+	}
+}
+
+func TestCompressWeakensETags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Compress(), Cache(CacheConfig{}))
+	r.GET("/large", func(c *gin.Context) {
+		c.String(http.StatusOK, largeText)
+	})
+	res := getCompressed(r, "/large", "gzip")
+	etag := res.Header().Get("ETag")
+	if res.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("expected a weak ETag for the compressed body; got %v", res.Header())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/large", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", etag)
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != http.StatusNotModified {
+		t.Errorf("expected the weak ETag to match; got %d", res.Code)
+	}
+}
+
+func TestWritersFollowGinWritten(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Compress(), Cache(CacheConfig{}))
+	var afterStatus, afterWrite bool
+	r.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+		afterStatus = c.Writer.Written()
+		c.Writer.WriteString("done")
+		afterWrite = c.Writer.Written()
+	})
+	getCompressed(r, "/", "gzip")
+	if afterStatus || !afterWrite {
+		t.Errorf("expected only writes to mark the response written; got %v %v", afterStatus, afterWrite)
+	}
+}
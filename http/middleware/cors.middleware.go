@@ -1,6 +1,42 @@
 package middleware
 
-import "github.com/gin-gonic/gin"
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The gin context key the active CORS configuration is stored under. This lets other handlers, like websocket
+// upgrades, apply the same origin policy.
+const CORSConfigKey = "corsConfig"
+
+type CORSConfig struct {
+	// Origins which are allowed to make requests. Use "*" to allow any origin.
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+var DefaultCORSConfig = CORSConfig{
+	AllowOrigins: []string{"*"},
+	AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+	AllowHeaders: []string{"Content-Type", "Authorization"},
+}
+
+// Check if requests from the given origin are allowed
+func (c CORSConfig) AllowsOrigin(origin string) bool {
+	for _, o := range c.AllowOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
 
 func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -10,3 +46,46 @@ func CORS() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// Add CORS headers for allowed origins and answer preflight requests. Panics if any origin is allowed along with
+// credentials since browsers reject that combination and reflecting every origin would defeat the origin check.
+func CORSWithConfig(config CORSConfig) gin.HandlerFunc {
+	if config.AllowCredentials && config.AllowsOrigin("*") {
+		panic("cors: AllowCredentials can't be used when AllowOrigins includes \"*\"")
+	}
+	if len(config.AllowMethods) == 0 {
+		config.AllowMethods = DefaultCORSConfig.AllowMethods
+	}
+	if len(config.AllowHeaders) == 0 {
+		config.AllowHeaders = DefaultCORSConfig.AllowHeaders
+	}
+	allowAll := config.AllowsOrigin("*")
+	methods := strings.Join(config.AllowMethods, ", ")
+	headers := strings.Join(config.AllowHeaders, ", ")
+	return func(c *gin.Context) {
+		c.Set(CORSConfigKey, config)
+		origin := c.GetHeader("Origin")
+		if allowAll {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else if origin != "" && config.AllowsOrigin(origin) {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+			if config.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			c.Next()
+			return
+		}
+		c.Header("Access-Control-Allow-Methods", methods)
+		c.Header("Access-Control-Allow-Headers", headers)
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			if config.MaxAge > 0 {
+				c.Header("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSPassesPreflightThrough(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CORS())
+	r.OPTIONS("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the OPTIONS route to handle the request; got %d", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected any origin to be allowed; got %q", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSWithConfigPreflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CORSWithConfig(CORSConfig{AllowOrigins: []string{"https://example.com"}, AllowCredentials: true}))
+	r.OPTIONS("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected the preflight to be answered; got %d", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("expected the origin to be allowed with credentials; got %v", w.Header())
+	}
+}
+
+func TestCORSWithConfigRejectsWildcardCredentials(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for any origin with credentials")
+		}
+	}()
+	CORSWithConfig(CORSConfig{AllowOrigins: []string{"*"}, AllowCredentials: true})
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/wyattis/goof/log"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 )
 
@@ -16,12 +15,12 @@ func applyRequestEvent(e *zerolog.Event, c *gin.Context) *zerolog.Event {
 		Int("status", c.Writer.Status())
 }
 
+// Log the completion of each request. Use after RequestId so that every entry carries the request id.
 func Log() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if log.Debug().Enabled() {
 			startTime := time.Now()
-			requestId := uuid.NewString()
-			c.Set("requestId", requestId)
+			requestId := c.GetString(RequestIdKey)
 			log.Debug().Str("request", requestId).Msg("start")
 			c.Next()
 			var event *zerolog.Event
@@ -44,7 +43,9 @@ func Log() gin.HandlerFunc {
 			} else {
 				event = log.Info()
 			}
-			applyRequestEvent(event, c).Strs("errors", c.Errors.Errors()).Msg("complete")
+			applyRequestEvent(event, c).
+				Str("request", c.GetString(RequestIdKey)).
+				Strs("errors", c.Errors.Errors()).Msg("complete")
 		}
 	}
 }
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	ghttp "github.com/wyattis/goof/http"
+	"github.com/wyattis/goof/log"
+)
+
+// The gin context key the request id is stored under
+const RequestIdKey = ghttp.RequestIdKey
+
+// Incoming ids are echoed in headers and logs so only short ids made of safe characters are honored
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Honor a safe incoming X-Request-ID header or generate a new id. The id is echoed in the response and a logger carrying
+// the request id and route is attached to the request context. Use log.Ctx to retrieve the logger from a handler.
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(ghttp.RequestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIdKey, id)
+		c.Header(ghttp.RequestIdHeader, id)
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		logger := log.Logger.With().
+			Str("request", id).
+			Str("method", c.Request.Method).
+			Str("route", route).
+			Logger()
+		c.Set(log.ContextKey, &logger)
+		ctx := ghttp.WithRequestId(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(log.WithContext(ctx, &logger))
+		c.Next()
+	}
+}
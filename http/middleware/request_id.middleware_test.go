@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+
+	ghttp "github.com/wyattis/goof/http"
+	"github.com/wyattis/goof/http/mock"
+	"github.com/wyattis/goof/log"
+)
+
+func TestRequestIdHonorsHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	buf := &bytes.Buffer{}
+	logger := log.Logger
+	t.Cleanup(func() { log.Logger = logger })
+	log.Logger = zerolog.New(buf)
+
+	r := gin.New()
+	r.Use(RequestId())
+	r.GET("/user/:id", func(c *gin.Context) {
+		log.Ctx(c).Info().Msg("handled")
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+	req.Header.Set(ghttp.RequestIdHeader, "abc")
+	r.ServeHTTP(w, req)
+
+	if w.Header().Get(ghttp.RequestIdHeader) != "abc" {
+		t.Errorf("expected request id abc; got %q", w.Header().Get(ghttp.RequestIdHeader))
+	}
+	if !strings.Contains(buf.String(), `"request":"abc"`) || !strings.Contains(buf.String(), `"route":"/user/:id"`) {
+		t.Errorf("expected log entry to contain the request id and route; got %s", buf.String())
+	}
+}
+
+func TestRequestIdGenerated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestId())
+	var fromCtx string
+	r.GET("/", func(c *gin.Context) {
+		fromCtx = ghttp.RequestId(c)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	id := w.Header().Get(ghttp.RequestIdHeader)
+	if id == "" {
+		t.Fatal("expected a generated request id")
+	}
+	if fromCtx != id {
+		t.Errorf("expected context request id %q; got %q", id, fromCtx)
+	}
+}
+
+func TestRequestIdReplacesUnsafeIds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestId())
+	r.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	for _, incoming := range []string{"a b", "abc\"}", "<script>", strings.Repeat("a", 129)} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(ghttp.RequestIdHeader, incoming)
+		r.ServeHTTP(w, req)
+		if id := w.Header().Get(ghttp.RequestIdHeader); id == "" || id == incoming {
+			t.Errorf("expected %q to be replaced by a generated id; got %q", incoming, id)
+		}
+	}
+}
+
+func TestRequestIdForwardedByBaseClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := mock.NewServer(mock.Routes{
+		"GET /": mock.Json(map[string]string{}, mock.ExpectHeader(ghttp.RequestIdHeader, "abc")),
+	})
+	defer upstream.Close()
+	client := ghttp.NewBaseClient(upstream.URL, upstream.Client())
+
+	r := gin.New()
+	r.Use(RequestId())
+	r.GET("/", func(c *gin.Context) {
+		res, err := client.GetContext(c, "/")
+		if err != nil {
+			c.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+		defer res.Body.Close()
+		c.Status(res.StatusCode)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ghttp.RequestIdHeader, "abc")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected upstream to receive the request id; got status %d", w.Code)
+	}
+}
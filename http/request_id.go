@@ -0,0 +1,28 @@
+package http
+
+import "context"
+
+// The header used to propagate request ids between services
+const RequestIdHeader = "X-Request-ID"
+
+// The gin context key the request id is stored under
+const RequestIdKey = "requestId"
+
+type requestIdKey struct{}
+
+// Return a copy of ctx which carries the given request id
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// Get the request id stored in ctx. A *gin.Context is also accepted. Returns an empty string if there isn't one.
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if id, ok := ctx.Value(RequestIdKey).(string); ok {
+		return id
+	}
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
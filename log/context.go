@@ -0,0 +1,39 @@
+package log
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// The gin context key the request scoped logger is stored under
+const ContextKey = "logger"
+
+// Get the request scoped logger stored in ctx. Both a *gin.Context and the request context are accepted. Returns a
+// copy of the global Logger if the context doesn't carry a logger so it's always safe to call UpdateContext on the
+// result.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ContextKey).(*zerolog.Logger); ok {
+			return l
+		}
+		if l := zerolog.Ctx(ctx); l != nil && l.GetLevel() != zerolog.Disabled {
+			return l
+		}
+	}
+	l := Logger
+	return &l
+}
+
+// Return a copy of ctx which carries the given logger
+func WithContext(ctx context.Context, l *zerolog.Logger) context.Context {
+	return l.WithContext(ctx)
+}
+
+// Attach a user to the request scoped logger stored in ctx. This is meant to be called by authentication middleware
+// once the user is known.
+func SetUser(ctx context.Context, user string) {
+	Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("user", user)
+	})
+}
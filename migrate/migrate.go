@@ -210,3 +210,15 @@ func UpTo(db *sql.DB, driverType driver.Type, name string, version uint) (err er
 	}
 	return MigrateUpTo(Migrations, db, driverType, name, version)
 }
+
+// Get the foreign keys of a table declared by the Up functions of the migrations, such as the foreign keys used by
+// CRUD controllers. All foreign keys are returned if table is empty.
+func ForeignKeys(migrations []Migration, table string) []schema.ForeignKey {
+	s := schema.New("", "")
+	for _, m := range migrations {
+		if m.Up != nil {
+			m.Up(s)
+		}
+	}
+	return s.Schema.ForeignKeys(table)
+}
@@ -2,6 +2,7 @@ package migrate
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/wyattis/goof/schema"
@@ -85,3 +86,14 @@ func TestSqliteDown(t *testing.T) {
 		t.Error("Failed to migrate down", err)
 	}
 }
+
+func TestForeignKeys(t *testing.T) {
+	keys := ForeignKeys(userCommentMigrations, "comment")
+	expected := []schema.ForeignKey{{Table: "comment", Column: "user_id", RefTable: "user", RefColumn: "id"}}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, keys)
+	}
+	if keys := ForeignKeys(userCommentMigrations, "user"); len(keys) != 0 {
+		t.Errorf("Expected no foreign keys on user but got %+v", keys)
+	}
+}
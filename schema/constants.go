@@ -11,7 +11,7 @@ type NOW struct{}
 
 func (n NOW) Constant(driverType driver.Type) string {
 	switch driverType {
-	case driver.TypeSqlite3:
+	case driver.TypeSqlite3, driver.TypePostgres, driver.TypeMysql:
 		return "CURRENT_TIMESTAMP"
 	default:
 		panic("unsupported driver type")
@@ -0,0 +1,31 @@
+package schema
+
+// A foreign key from a column of a table to a column of another table
+type ForeignKey struct {
+	Table     string
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+// Get the foreign keys of a table declared using References. All foreign keys in the schema are returned if table is
+// empty.
+func (s *SchemaDef) ForeignKeys(table string) (keys []ForeignKey) {
+	for _, t := range s.Tables {
+		if table != "" && t.Name != table {
+			continue
+		}
+		for _, c := range t.Columns {
+			if c.ReferenceTo == nil {
+				continue
+			}
+			keys = append(keys, ForeignKey{
+				Table:     t.Name,
+				Column:    c.Name,
+				RefTable:  c.ReferenceTo.Table,
+				RefColumn: c.ReferenceTo.Column,
+			})
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wyattis/goof/sql/driver"
+)
+
+// The tsvector column added to tables searched on Postgres
+const SearchColumn = "search"
+
+// The FTS5 table which indexes a table searched on SQLite
+func SearchTable(table string) string {
+	return table + "_search"
+}
+
+type searchDef struct {
+	Table    *TableDef
+	Key      string
+	Columns  []string
+	Language string
+	Drop     bool
+}
+
+type searchBuilder struct {
+	search *searchDef
+}
+
+// Set the integer column identifying the rows of the table. Defaults to id.
+func (s *searchBuilder) Key(column string) *searchBuilder {
+	s.search.Key = column
+	return s
+}
+
+// Set the text search configuration used on Postgres. Defaults to english.
+func (s *searchBuilder) Language(language string) *searchBuilder {
+	s.search.Language = language
+	return s
+}
+
+// Index the text columns of this table for full-text search. SQLite gets an external content FTS5 table kept in sync
+// with triggers and Postgres gets a generated tsvector column with a GIN index. Earlier columns rank higher on
+// Postgres. Existing rows are indexed when the statements run.
+func (t *Table) Search(cols ...string) *searchBuilder {
+	s := &searchDef{
+		Table:    t.tableDef,
+		Key:      "id",
+		Columns:  cols,
+		Language: "english",
+	}
+	t.tableDef.Searches = append(t.tableDef.Searches, s)
+	return &searchBuilder{s}
+}
+
+// Remove the full-text search index created by Search
+func (t *Table) DropSearch() {
+	t.tableDef.Searches = append(t.tableDef.Searches, &searchDef{Table: t.tableDef, Drop: true})
+}
+
+func (s *searchDef) Statements() []string {
+	d := s.Table.Schema.Driver
+	switch d {
+	case driver.TypeSqlite3:
+		if s.Drop {
+			return s.sqliteDrop(d)
+		}
+		return s.sqliteCreate(d)
+	case driver.TypePostgres:
+		if s.Drop {
+			return s.postgresDrop(d)
+		}
+		return s.postgresCreate(d)
+	default:
+		panic("unsupported driver type")
+	}
+}
+
+func (s *searchDef) sqliteCreate(d driver.Type) []string {
+	table := s.Table.Name
+	search := d.Quote(SearchTable(table))
+	cols := make([]string, len(s.Columns))
+	newValues := make([]string, len(s.Columns))
+	oldValues := make([]string, len(s.Columns))
+	for i, col := range s.Columns {
+		cols[i] = d.Quote(col)
+		newValues[i] = "new." + d.Quote(col)
+		oldValues[i] = "old." + d.Quote(col)
+	}
+	key := d.Quote(s.Key)
+	insert := fmt.Sprintf("INSERT INTO %s (rowid, %s) VALUES (new.%s, %s);", search, strings.Join(cols, ", "), key,
+		strings.Join(newValues, ", "))
+	// external content tables are told which values to remove with the special delete command
+	remove := fmt.Sprintf("INSERT INTO %[1]s (%[1]s, rowid, %[2]s) VALUES ('delete', old.%[3]s, %[4]s);", search,
+		strings.Join(cols, ", "), key, strings.Join(oldValues, ", "))
+	return []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, content='%s', content_rowid='%s', tokenize='porter unicode61')",
+			search, strings.Join(cols, ", "), table, s.Key),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s BEGIN %s END", d.Quote(SearchTable(table)+"_insert"), d.Quote(table), insert),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s BEGIN %s END", d.Quote(SearchTable(table)+"_delete"), d.Quote(table), remove),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s BEGIN %s %s END", d.Quote(SearchTable(table)+"_update"), d.Quote(table), remove, insert),
+		fmt.Sprintf("INSERT INTO %[1]s (%[1]s) VALUES ('rebuild')", search),
+	}
+}
+
+func (s *searchDef) sqliteDrop(d driver.Type) (statements []string) {
+	search := SearchTable(s.Table.Name)
+	for _, trigger := range []string{"insert", "delete", "update"} {
+		statements = append(statements, fmt.Sprintf("DROP TRIGGER IF EXISTS %s", d.Quote(search+"_"+trigger)))
+	}
+	return append(statements, fmt.Sprintf("DROP TABLE IF EXISTS %s", d.Quote(search)))
+}
+
+func (s *searchDef) postgresCreate(d driver.Type) []string {
+	weights := "ABCD"
+	vectors := make([]string, len(s.Columns))
+	for i, col := range s.Columns {
+		weight := weights[len(weights)-1]
+		if i < len(weights) {
+			weight = weights[i]
+		}
+		vectors[i] = fmt.Sprintf("setweight(to_tsvector('%s', coalesce(%s, '')), '%c')", s.Language, d.Quote(col), weight)
+	}
+	table := d.Quote(s.Table.Name)
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s tsvector GENERATED ALWAYS AS (%s) STORED", table, d.Quote(SearchColumn),
+			strings.Join(vectors, " || ")),
+		fmt.Sprintf("CREATE INDEX %s ON %s USING GIN (%s)", d.Quote(SearchTable(s.Table.Name)+"_idx"), table, d.Quote(SearchColumn)),
+	}
+}
+
+func (s *searchDef) postgresDrop(d driver.Type) []string {
+	return []string{
+		fmt.Sprintf("DROP INDEX IF EXISTS %s", d.Quote(SearchTable(s.Table.Name)+"_idx")),
+		fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", d.Quote(s.Table.Name), d.Quote(SearchColumn)),
+	}
+}
@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/wyattis/goof/sql/driver"
+)
+
+func TestSearchStatements(t *testing.T) {
+	sqlite := New(driver.TypeSqlite3, "test")
+	sqlite.Table("post", func(t *Table) {
+		t.Search("title", "body")
+	})
+	expected := []string{
+		`CREATE VIRTUAL TABLE "post_search" USING fts5("title", "body", content='post', content_rowid='id', tokenize='porter unicode61')`,
+		`CREATE TRIGGER "post_search_insert" AFTER INSERT ON "post" BEGIN INSERT INTO "post_search" (rowid, "title", "body") VALUES (new."id", new."title", new."body"); END`,
+		`CREATE TRIGGER "post_search_delete" AFTER DELETE ON "post" BEGIN INSERT INTO "post_search" ("post_search", rowid, "title", "body") VALUES ('delete', old."id", old."title", old."body"); END`,
+		`CREATE TRIGGER "post_search_update" AFTER UPDATE ON "post" BEGIN INSERT INTO "post_search" ("post_search", rowid, "title", "body") VALUES ('delete', old."id", old."title", old."body"); INSERT INTO "post_search" (rowid, "title", "body") VALUES (new."id", new."title", new."body"); END`,
+		`INSERT INTO "post_search" ("post_search") VALUES ('rebuild')`,
+	}
+	if statements := sqlite.Schema.Statements(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("expected %q; got %q", expected, statements)
+	}
+
+	postgres := New(driver.TypePostgres, "test")
+	postgres.Table("post", func(t *Table) {
+		t.Search("title", "body").Language("simple")
+	})
+	expected = []string{
+		`ALTER TABLE "post" ADD COLUMN "search" tsvector GENERATED ALWAYS AS (setweight(to_tsvector('simple', coalesce("title", '')), 'A') || setweight(to_tsvector('simple', coalesce("body", '')), 'B')) STORED`,
+		`CREATE INDEX "post_search_idx" ON "post" USING GIN ("search")`,
+	}
+	if statements := postgres.Schema.Statements(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("expected %q; got %q", expected, statements)
+	}
+
+	postgres = New(driver.TypePostgres, "test")
+	postgres.Table("post", func(t *Table) {
+		t.DropSearch()
+	})
+	expected = []string{`DROP INDEX IF EXISTS "post_search_idx"`, `ALTER TABLE "post" DROP COLUMN IF EXISTS "search"`}
+	if statements := postgres.Schema.Statements(); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("expected %q; got %q", expected, statements)
+	}
+}
@@ -19,6 +19,7 @@ type TableDef struct {
 	IfNotExists  bool
 	Columns      []*columnDef
 	Indices      []*indexDef
+	Searches     []*searchDef
 }
 
 type Table struct {
@@ -149,20 +150,24 @@ func (t *TableDef) NumPrimary() int {
 func (t *TableDef) Statements() (statements []string) {
 	if t.WillCreate {
 		statements = append(statements, t.createStatement())
-	} else {
-		statements = append(statements, t.alterStatement())
+	} else if alter := t.alterStatement(); alter != "" {
+		statements = append(statements, alter)
 	}
 	statements = append(statements, t.indexStatements()...)
+	for _, s := range t.Searches {
+		statements = append(statements, s.Statements()...)
+	}
 	return
 }
 
 //go:embed templates/*
 var templates embed.FS
 
-func sqliteFuncMap() template.FuncMap {
+// The template functions of a driver. Column types are looked up in types.
+func funcMap(d driver.Type, types typeMap) template.FuncMap {
 	return template.FuncMap{
 		"GetType": func(kind ColumnType, num int) string {
-			res := sqliteTypeMap[kind]
+			res := types[kind]
 			if res == "VARCHAR" || res == "NVARCHAR" {
 				res += fmt.Sprintf("(%d)", num)
 			}
@@ -188,7 +193,7 @@ func sqliteFuncMap() template.FuncMap {
 				if !ok {
 					return fmt.Sprintf(" DEFAULT '%s'", val)
 				}
-				return fmt.Sprintf(" DEFAULT %s", c.Constant(driver.TypeSqlite3))
+				return fmt.Sprintf(" DEFAULT %s", c.Constant(d))
 			default:
 				return ""
 			}
@@ -202,18 +207,18 @@ func (t *TableDef) loadTemplates() (tmp *template.Template) {
 	if err != nil {
 		panic(err)
 	}
-	var funcMap template.FuncMap
+	var funcs template.FuncMap
 	switch t.Schema.Driver {
 	case driver.TypeMysql:
 		// TODO
 	case driver.TypePostgres:
-		// TODO
+		funcs = funcMap(t.Schema.Driver, postgresTypeMap)
 	case driver.TypeSqlite3:
-		funcMap = sqliteFuncMap()
+		funcs = funcMap(t.Schema.Driver, sqliteTypeMap)
 	default:
 		panic("unknown driver type")
 	}
-	tmp = template.New("table").Funcs(funcMap)
+	tmp = template.New("table").Funcs(funcs)
 	tmpName := fmt.Sprintf("%s.tpl", t.Schema.Driver)
 	tmp, err = tmp.ParseFS(dirFs, tmpName)
 	if err != nil {
@@ -237,6 +242,9 @@ func (t *TableDef) alterStatement() string {
 }
 
 func (t *TableDef) indexStatements() (statements []string) {
+	if len(t.Indices) == 0 {
+		return
+	}
 	tmp := t.loadTemplates()
 	for _, idx := range t.Indices {
 		res := bytes.Buffer{}
@@ -31,6 +31,33 @@ var mysqlTypeMap = typeMap{
 	TypeTimestamp: "TIMESTAMP",
 	TypeBit:       "BIT",
 	TypeBinary:    "BINARY",
+	TypeBlob:      "LONGBLOB",
+}
+
+var postgresTypeMap = typeMap{
+	TypeVarChar:   "VARCHAR",
+	TypeNVarChar:  "VARCHAR",
+	TypeText:      "TEXT",
+	TypeJson:      "JSONB",
+	TypeDateTime:  "TIMESTAMP",
+	TypeEnum:      "TEXT",
+	TypeBoolean:   "BOOLEAN",
+	TypeInteger:   "INTEGER",
+	TypeTinyInt:   "SMALLINT",
+	TypeSmallInt:  "SMALLINT",
+	TypeMediumInt: "INTEGER",
+	TypeBigInt:    "BIGINT",
+	TypeDecimal:   "DECIMAL",
+	TypeNumeric:   "NUMERIC",
+	TypeFloat:     "REAL",
+	TypeDouble:    "DOUBLE PRECISION",
+	TypeDate:      "DATE",
+	TypeTime:      "TIME",
+	TypeTimestamp: "TIMESTAMP",
+	TypeBit:       "BIT",
+	TypeBinary:    "BYTEA",
+	TypeVarBinary: "BYTEA",
+	TypeBlob:      "BYTEA",
 }
 
 var sqliteTypeMap = typeMap{
@@ -56,4 +83,5 @@ var sqliteTypeMap = typeMap{
 	TypeDouble:    "REAL",
 	TypeBinary:    "BLOB",
 	TypeVarBinary: "BLOB",
+	TypeBlob:      "BLOB",
 }
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Quote an identifier such as a table or column name
+func (x Type) Quote(ident string) string {
+	q := `"`
+	if x == TypeMysql {
+		q = "`"
+	}
+	return q + strings.ReplaceAll(ident, q, q+q) + q
+}
+
+// The placeholder of the nth (starting at 1) parameter of a query
+func (x Type) Placeholder(n int) string {
+	if x == TypePostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// Replace the ? placeholders of a query with the placeholders of this database. The query must not contain ? in
+// string literals.
+func (x Type) Rebind(query string) string {
+	if x != TypePostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for {
+		i := strings.IndexByte(query, '?')
+		if i < 0 {
+			break
+		}
+		n++
+		b.WriteString(query[:i])
+		b.WriteString(x.Placeholder(n))
+		query = query[i+1:]
+	}
+	b.WriteString(query)
+	return b.String()
+}
+
+// Check if INSERT statements can return the generated columns using RETURNING. Otherwise the id of an inserted row
+// is read using sql.Result.LastInsertId.
+func (x Type) Returning() bool {
+	return x == TypePostgres
+}
+
+// The maximum number of parameters a single statement can have. SQLite has allowed 32766 since 3.32.
+func (x Type) MaxParams() int {
+	switch x {
+	case TypePostgres, TypeMysql:
+		return 65535
+	}
+	return 32766
+}
+
+// Check if sql.Result.LastInsertId returns the id of the first row of a multi-row INSERT instead of the last
+func (x Type) FirstInsertId() bool {
+	return x == TypeMysql
+}
@@ -0,0 +1,26 @@
+package driver
+
+import "testing"
+
+func TestQuote(t *testing.T) {
+	for d, expected := range map[Type]string{
+		TypeSqlite3:  `"a""b"`,
+		TypePostgres: `"a""b"`,
+		TypeMysql:    "`a\"b`",
+	} {
+		if got := d.Quote(`a"b`); got != expected {
+			t.Errorf("%s: expected %s; got %s", d, expected, got)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	q := "SELECT * FROM t WHERE a = ? AND b IN (?, ?)"
+	if got := TypeSqlite3.Rebind(q); got != q {
+		t.Errorf("expected sqlite queries to be unchanged; got %s", got)
+	}
+	expected := "SELECT * FROM t WHERE a = $1 AND b IN ($2, $3)"
+	if got := TypePostgres.Rebind(q); got != expected {
+		t.Errorf("expected %s; got %s", expected, got)
+	}
+}